@@ -0,0 +1,98 @@
+// Package cli holds the exit code contract shared by the command line tools,
+// so scripts can tell why a conversion failed:
+//
+//	0  ExitOK      success
+//	1  ExitError   any other error
+//	2  ExitUsage   bad arguments or option values
+//	3  ExitDecode  the input could not be read or decoded
+//	4  ExitWrite   the output could not be encoded or written
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Exit codes of the command line tools.
+const (
+	ExitOK     = 0
+	ExitError  = 1
+	ExitUsage  = 2
+	ExitDecode = 3
+	ExitWrite  = 4
+)
+
+// ErrUsage is returned by a tool after printing its usage. Exit reports it
+// with ExitUsage without printing it again.
+var ErrUsage = Usage(errors.New("usage"))
+
+// exitError is an error with the exit code it causes.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withCode wraps err with an exit code, nil stays nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// Usage marks err as caused by bad arguments.
+func Usage(err error) error { return withCode(ExitUsage, err) }
+
+// Decode marks err as caused by reading or decoding the input.
+func Decode(err error) error { return withCode(ExitDecode, err) }
+
+// Write marks err as caused by encoding or writing the output.
+func Write(err error) error { return withCode(ExitWrite, err) }
+
+// ExitCode returns the exit code for err: ExitOK for nil, the code err was
+// marked with, also through further wrapping, or ExitError.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ExitError
+}
+
+// Join combines the errors of a batch that went on after a failure: nil if
+// all of them are nil, otherwise one error with all messages, marked with the
+// exit code the failures share, or ExitError if their codes differ.
+func Join(errs ...error) error {
+	err := errors.Join(errs...)
+	if err == nil {
+		return nil
+	}
+	code := -1
+	for _, e := range errs {
+		if e == nil {
+			continue
+		}
+		if code == -1 {
+			code = ExitCode(e)
+		} else if code != ExitCode(e) {
+			code = ExitError
+		}
+	}
+	return withCode(code, err)
+}
+
+// Exit prints err to w, unless it is nil or ErrUsage, and returns its exit
+// code. Tools end with os.Exit(cli.Exit(os.Stdout, run())).
+func Exit(w io.Writer, err error) int {
+	if err != nil && !errors.Is(err, ErrUsage) {
+		fmt.Fprintln(w, "Error:", err)
+	}
+	return ExitCode(err)
+}
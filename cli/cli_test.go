@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExit(t *testing.T) {
+	base := errors.New("broken")
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		output string
+	}{
+		{"success", nil, ExitOK, ""},
+		{"plain", base, ExitError, "Error: broken\n"},
+		{"usage", Usage(base), ExitUsage, "Error: broken\n"},
+		{"printed usage", ErrUsage, ExitUsage, ""},
+		{"decode", fmt.Errorf("loading image: %w", Decode(base)), ExitDecode, "Error: loading image: broken\n"},
+		{"write", Write(fmt.Errorf("creating SAG file: %w", base)), ExitWrite, "Error: creating SAG file: broken\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if code := Exit(&out, tt.err); code != tt.code {
+			t.Errorf("%s: exit code %d, want %d", tt.name, code, tt.code)
+		}
+		if out.String() != tt.output {
+			t.Errorf("%s: output %q, want %q", tt.name, out.String(), tt.output)
+		}
+	}
+
+	if !errors.Is(Decode(base), base) {
+		t.Error("Decode hides the wrapped error from errors.Is")
+	}
+	if Usage(nil) != nil || Decode(nil) != nil || Write(nil) != nil {
+		t.Error("marking nil returned an error")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	base := errors.New("broken")
+	tests := []struct {
+		name string
+		errs []error
+		code int
+	}{
+		{"all succeeded", []error{nil, nil}, ExitOK},
+		{"one write failure", []error{nil, Write(base), nil}, ExitWrite},
+		{"same codes", []error{Decode(base), Decode(base)}, ExitDecode},
+		{"different codes", []error{Decode(base), nil, Write(base)}, ExitError},
+	}
+	for _, tt := range tests {
+		err := Join(tt.errs...)
+		if code := ExitCode(err); code != tt.code {
+			t.Errorf("%s: exit code %d, want %d", tt.name, code, tt.code)
+		}
+		if tt.code != ExitOK && !errors.Is(err, base) {
+			t.Errorf("%s: the joined error hides the failures from errors.Is", tt.name)
+		}
+	}
+}
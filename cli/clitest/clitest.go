@@ -0,0 +1,75 @@
+// Package clitest runs the run function of a command line tool in a test, the
+// way its main function does, and returns the exit code.
+package clitest
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../../cli"
+	"../../sag"
+)
+
+// Run calls run with args as the command line arguments and returns the exit
+// code main would exit with, together with everything printed to stdout.
+// The flags of the previous call are dropped, so run can define its flags
+// again. Invalid flags are reported by the flag package and leave the rest of
+// the arguments to run, which should then fail with ExitUsage.
+func Run(t testing.TB, run func() error, args ...string) (int, string) {
+	t.Helper()
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	oldArgs, oldFlags, oldStdout := os.Args, flag.CommandLine, os.Stdout
+	defer func() { os.Args, flag.CommandLine, os.Stdout = oldArgs, oldFlags, oldStdout }()
+	os.Args = append([]string{t.Name()}, args...)
+	flag.CommandLine = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flag.CommandLine.SetOutput(stdout)
+	os.Stdout = stdout
+
+	code := cli.Exit(stdout, run())
+
+	if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	output, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(output)
+}
+
+// SAGFile writes a SAG file with the given number of 8×8 frames, a white pixel
+// moving across black, to the test's temporary directory and returns its name.
+func SAGFile(t testing.TB, frames int) string {
+	t.Helper()
+
+	palette := []color.Color{color.RGBA{A: 0xff}, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}}
+	images := make([]*image.Paletted, frames)
+	delays := make([]int, frames)
+	for i := range images {
+		images[i] = image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		images[i].Pix[i%64] = 1
+		delays[i] = 100
+	}
+
+	filename := filepath.Join(t.TempDir(), "input.sag")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := sag.Encode(file, images, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"./cli"
+	"./convert"
+	"./imgcolor"
+)
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run prints the histogram of the file given on the command line.
+func run() error {
+	output := flag.String("o", "csv", "output format: csv, json")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: colorhist [options] <input> <format>")
+		fmt.Println("Supported formats: gif, tiff, webp, jpeg, png")
+		flag.PrintDefaults()
+		return cli.ErrUsage
+	}
+
+	loader, err := convert.LoaderByName(flag.Arg(1))
+	if err != nil {
+		return cli.Usage(err)
+	}
+
+	images, _, err := loader.Load(flag.Arg(0))
+	if err != nil {
+		return cli.Decode(fmt.Errorf("loading image: %w", err))
+	}
+
+	// Count the colors of all frames, not just the first one
+	entries := imgcolor.Histogram(convert.CountColors(images))
+
+	switch *output {
+	case "csv":
+		err = imgcolor.WriteHistogramCSV(os.Stdout, entries)
+	case "json":
+		err = imgcolor.WriteHistogramJSON(os.Stdout, entries)
+	default:
+		return cli.Usage(fmt.Errorf("unsupported output format: %s", *output))
+	}
+	return cli.Write(err)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"./cli"
+	"./cli/clitest"
+)
+
+// TestRunExitCodes checks the exit codes of colorhist for valid and invalid
+// arguments and for an input file that does not exist.
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{"imgcolor/example.gif", "gif"}, cli.ExitOK},
+		{"missing format", []string{"imgcolor/example.gif"}, cli.ExitUsage},
+		{"unknown format", []string{"imgcolor/example.gif", "bmp"}, cli.ExitUsage},
+		{"missing input", []string{missing, "gif"}, cli.ExitDecode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, output := clitest.Run(t, run, test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\n%s", code, test.code, output)
+			}
+		})
+	}
+}
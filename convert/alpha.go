@@ -0,0 +1,54 @@
+package convert
+
+import (
+	"image"
+	"image/draw"
+)
+
+// HasAlpha reports whether any frame has a pixel that is not fully opaque.
+// Without Flatten, such pixels are quantized as if composited over black.
+func HasAlpha(frames []image.Image) bool {
+	for _, frame := range frames {
+		if !isOpaque(frame) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpaque reports whether all pixels of the image are fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unpremultiply returns frames with transparent pixels as *image.NRGBA (or
+// *image.NRGBA64), so
+// every loader hands out alpha the same way, no matter whether the decoder
+// kept it premultiplied. Opaque frames are returned as they are.
+func unpremultiply(frames []image.Image) []image.Image {
+	for i, frame := range frames {
+		switch frame.(type) {
+		case *image.NRGBA, *image.NRGBA64:
+			continue
+		}
+		if isOpaque(frame) {
+			continue
+		}
+		bounds := frame.Bounds()
+		dst := image.NewNRGBA(bounds)
+		draw.Draw(dst, bounds, frame, bounds.Min, draw.Src)
+		frames[i] = dst
+	}
+	return frames
+}
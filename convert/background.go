@@ -0,0 +1,65 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// ParseColor parses an opaque color given as "R,G,B" with components 0-255.
+func ParseColor(s string) (color.RGBA, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, want R,G,B", s)
+	}
+
+	var v [3]uint8
+	for i, part := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		v[i] = uint8(n)
+	}
+
+	return color.RGBA{v[0], v[1], v[2], 255}, nil
+}
+
+// ParsePaletteEntries parses palette entries given as "index=R,G,B" with an
+// index of 0-255.
+func ParsePaletteEntries(entries []string) (map[int]color.Color, error) {
+	colors := make(map[int]color.Color)
+	for _, entry := range entries {
+		index, rgb, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid palette entry %q, want index=R,G,B", entry)
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(index))
+		if err != nil || i < 0 || i > 255 {
+			return nil, fmt.Errorf("invalid palette index %q, want 0 to 255", index)
+		}
+		c, err := ParseColor(rgb)
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = c
+	}
+	return colors, nil
+}
+
+// Flatten composites every frame over a solid background color, so transparent
+// and semi-transparent pixels become opaque before the palette is built.
+func Flatten(frames []image.Image, background color.Color) []image.Image {
+	flattened := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		dst := image.NewRGBA(bounds)
+		draw.Draw(dst, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+		draw.Draw(dst, bounds, frame, bounds.Min, draw.Over)
+		flattened[i] = dst
+	}
+	return flattened
+}
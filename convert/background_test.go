@@ -0,0 +1,75 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("0, 128,255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (color.RGBA{0, 128, 255, 255}); c != want {
+		t.Errorf("ParseColor = %v, want %v", c, want)
+	}
+
+	for _, s := range []string{"", "1,2", "1,2,256", "a,b,c"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Errorf("ParseColor(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestFlattenOverBlue(t *testing.T) {
+	blue := color.RGBA{B: 255, A: 255}
+
+	// Half-transparent red (premultiplied) next to a fully transparent pixel
+	frame := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	frame.Set(0, 0, color.RGBA{R: 128, A: 128})
+	frame.Set(1, 0, color.RGBA{})
+
+	flat := Flatten([]image.Image{frame}, blue)
+
+	got := color.RGBAModel.Convert(flat[0].At(0, 0)).(color.RGBA)
+	if want := (color.RGBA{R: 128, B: 127, A: 255}); got != want {
+		t.Errorf("semi-transparent pixel = %v, want %v", got, want)
+	}
+	if got := flat[0].At(1, 0); got != blue {
+		t.Errorf("transparent pixel = %v, want %v", got, blue)
+	}
+}
+
+func TestFlattenTransparentIndex(t *testing.T) {
+	// GIF frames mark transparency with a palette entry whose alpha is 0
+	palette := color.Palette{color.RGBA{}, color.RGBA{G: 255, A: 255}}
+	frame := image.NewPaletted(image.Rect(0, 0, 2, 1), palette)
+	frame.SetColorIndex(1, 0, 1)
+
+	flat := Flatten([]image.Image{frame}, color.RGBA{B: 255, A: 255})
+	colors := CountColors(flat)
+	if len(colors) != 2 {
+		t.Errorf("got %d colors after flattening, want 2", len(colors))
+	}
+	for c := range colors {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			t.Errorf("color %v is not opaque", c)
+		}
+	}
+}
+
+func TestParsePaletteEntries(t *testing.T) {
+	entries, err := ParsePaletteEntries([]string{"0=0,0,0", "255=255, 128, 0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (color.RGBA{A: 255}) || entries[255] != (color.RGBA{R: 255, G: 128, A: 255}) {
+		t.Errorf("entries = %v", entries)
+	}
+	for _, invalid := range []string{"0", "256=1,2,3", "-1=1,2,3", "x=1,2,3", "1=1,2"} {
+		if _, err := ParsePaletteEntries([]string{invalid}); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
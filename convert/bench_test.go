@@ -0,0 +1,117 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../imgcolor"
+	"../sag"
+)
+
+// benchImage returns a 200×200 image with 256 distinct colors in blocks of
+// similar colors, like a photo that is already close to a full palette.
+func benchImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			i := (y/13)*16 + x/13 // 16×16 blocks of 13×13 pixels
+			img.SetRGBA(x, y, color.RGBA{R: uint8(i%16) * 17, G: uint8(i/16) * 17, B: uint8(i * 7), A: 255})
+		}
+	}
+	return img
+}
+
+// benchAnimation returns a small animation of 8 frames of 64×32 pixels with a
+// moving gradient, similar to a panel-sized GIF.
+func benchAnimation() []image.Image {
+	frames := make([]image.Image, 8)
+	for i := range frames {
+		frames[i] = gradientFrame(64, 32, i)
+	}
+	return frames
+}
+
+func BenchmarkCountColors(b *testing.B) {
+	frames := []image.Image{benchImage()}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		CountColors(frames)
+	}
+}
+
+func BenchmarkCountColorsAnimation(b *testing.B) {
+	frames := benchAnimation()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		CountColors(frames)
+	}
+}
+
+func BenchmarkApplyPalette(b *testing.B) {
+	img := benchImage()
+	frames := []image.Image{img}
+	mapper := newPaletteMapper(BuildPalette(CountColors(frames), Options{}), imgcolor.RGB)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		applyPalette(img, mapper, nil)
+	}
+}
+
+func BenchmarkEncodeSAG(b *testing.B) {
+	images := benchAnimation()
+	frames, palette := ReduceColors(images, CountColors(images), Options{})
+	delays := make([]int, len(frames))
+	for i := range delays {
+		delays[i] = 100
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := sag.Encode(ioutil.Discard, frames, delays, palette); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// writeCounter counts the Write calls that reach the file, one syscall each.
+type writeCounter struct {
+	f      *os.File
+	writes int
+}
+
+func (wc *writeCounter) Write(p []byte) (int, error) {
+	wc.writes++
+	return wc.f.Write(p)
+}
+
+func BenchmarkEncodeSAGFile(b *testing.B) {
+	images := benchAnimation()
+	frames, palette := ReduceColors(images, CountColors(images), Options{})
+	delays := make([]int, len(frames))
+	for i := range delays {
+		delays[i] = 100
+	}
+	file, err := os.Create(filepath.Join(b.TempDir(), "bench.sag"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	wc := &writeCounter{f: file}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		if err := sag.Encode(wc, frames, delays, palette); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(wc.writes)/float64(b.N), "writes/op")
+}
@@ -0,0 +1,57 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// DiffFrames compares two animations pixel by pixel by color, not by index,
+// so a reordered palette counts as no change. It returns the number of
+// differing pixels of every frame and a heatmap of the frame size: pixels
+// that never differ show the first frame of a, darkened, the others are red,
+// the brighter the more frames they differ in.
+func DiffFrames(a, b []*image.Paletted) ([]int, *image.RGBA, error) {
+	if len(a) != len(b) {
+		return nil, nil, fmt.Errorf("diff: %d frames against %d", len(a), len(b))
+	}
+	if len(a) == 0 {
+		return nil, nil, fmt.Errorf("diff: no frames")
+	}
+	size := a[0].Bounds().Size()
+	for i := range a {
+		if sa, sb := a[i].Bounds().Size(), b[i].Bounds().Size(); sa != size || sb != size {
+			return nil, nil, fmt.Errorf("diff: frame %d is %dx%d against %dx%d, want %dx%d", i, sa.X, sa.Y, sb.X, sb.Y, size.X, size.Y)
+		}
+	}
+
+	counts := make([]int, len(a))
+	changed := make([]int, size.X*size.Y) // Number of differing frames per pixel
+	for i := range a {
+		ma, mb := a[i].Bounds().Min, b[i].Bounds().Min
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				ca := color.RGBAModel.Convert(a[i].At(ma.X+x, ma.Y+y))
+				cb := color.RGBAModel.Convert(b[i].At(mb.X+x, mb.Y+y))
+				if ca != cb {
+					counts[i]++
+					changed[y*size.X+x]++
+				}
+			}
+		}
+	}
+
+	heatmap := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	min := a[0].Bounds().Min
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if n := changed[y*size.X+x]; n > 0 {
+				heatmap.SetRGBA(x, y, color.RGBA{R: uint8(128 + 127*n/len(a)), A: 0xff})
+				continue
+			}
+			c := color.RGBAModel.Convert(a[0].At(min.X+x, min.Y+y)).(color.RGBA)
+			heatmap.SetRGBA(x, y, color.RGBA{R: c.R / 4, G: c.G / 4, B: c.B / 4, A: 0xff})
+		}
+	}
+	return counts, heatmap, nil
+}
@@ -0,0 +1,66 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"../sag"
+)
+
+func TestDiffFrames(t *testing.T) {
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	frames := make([]*image.Paletted, 3)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 5, 5), palette)
+		for p := range frames[i].Pix {
+			frames[i].Pix[p] = uint8((p + i) % 3)
+		}
+	}
+	var buf bytes.Buffer
+	if err := sag.Encode(&buf, frames, []int{50, 50, 50}, palette); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := sag.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := sag.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file against itself has no differences
+	counts, heatmap, err := DiffFrames(decoded, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range counts {
+		if n != 0 {
+			t.Errorf("frame %d: %d differing pixels, want 0", i, n)
+		}
+	}
+	if heatmap.Bounds() != image.Rect(0, 0, 5, 5) {
+		t.Errorf("heatmap bounds %v", heatmap.Bounds())
+	}
+
+	// A reordered palette changes the indices, not the colors
+	reordered, _ := SortPalette(decoded, decoded[0].Palette, ByHue)
+	if counts, _, _ := DiffFrames(decoded, reordered); counts[0]+counts[1]+counts[2] != 0 {
+		t.Errorf("reordered palette: %v differing pixels, want none", counts)
+	}
+
+	again[1].SetColorIndex(2, 3, (again[1].ColorIndexAt(2, 3)+1)%3)
+	counts, heatmap, _ = DiffFrames(decoded, again)
+	if counts[0] != 0 || counts[1] != 1 || counts[2] != 0 {
+		t.Errorf("one changed pixel: counts %v, want [0 1 0]", counts)
+	}
+	if got := heatmap.RGBAAt(2, 3); got.R < 128 || got.G != 0 {
+		t.Errorf("heatmap at the changed pixel = %v, want red", got)
+	}
+
+	if _, _, err := DiffFrames(decoded, again[:2]); err == nil {
+		t.Error("frame counts 3 and 2 compared without an error")
+	}
+}
@@ -0,0 +1,202 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Dither selects the dithering applied when mapping pixels to the palette.
+type Dither int
+
+const (
+	DitherNone           Dither = iota // Every pixel gets its nearest palette color
+	DitherOrdered4                     // Ordered dithering with a 4×4 Bayer matrix
+	DitherOrdered8                     // Ordered dithering with an 8×8 Bayer matrix
+	DitherTemporal                     // Floyd–Steinberg error diffusion, carried over into the next frame
+	DitherFloydSteinberg               // Floyd–Steinberg error diffusion within every frame
+)
+
+// ditherNames maps the names accepted by ParseDither to the dither modes.
+var ditherNames = map[string]Dither{
+	"none":     DitherNone,
+	"ordered":  DitherOrdered8,
+	"ordered4": DitherOrdered4,
+	"ordered8": DitherOrdered8,
+	"temporal": DitherTemporal,
+	"fs":       DitherFloydSteinberg,
+}
+
+// ParseDither returns the dither mode for a name: none, ordered (8×8),
+// ordered4, ordered8, fs (Floyd–Steinberg) or temporal.
+func ParseDither(name string) (Dither, error) {
+	d, ok := ditherNames[name]
+	if !ok {
+		return DitherNone, fmt.Errorf("unknown dither mode %q, want none, ordered, ordered4, ordered8, fs or temporal", name)
+	}
+	return d, nil
+}
+
+// orderedDitherSpread is the range in 8-bit color steps that the Bayer
+// thresholds spread a pixel over, roughly the spacing of a 256-color palette.
+const orderedDitherSpread = 32
+
+var (
+	bayer4 = bayerMatrix(4)
+	bayer8 = bayerMatrix(8)
+)
+
+// matrix returns the Bayer matrix of the dither mode, or nil for none.
+func (d Dither) matrix() [][]int {
+	switch d {
+	case DitherOrdered4:
+		return bayer4
+	case DitherOrdered8:
+		return bayer8
+	}
+	return nil
+}
+
+// bayerMatrix builds the n×n Bayer threshold matrix with the values 0..n*n-1,
+// n must be a power of two.
+func bayerMatrix(n int) [][]int {
+	m := [][]int{{0}}
+	for size := 1; size < n; size *= 2 {
+		next := make([][]int, 2*size)
+		for y := range next {
+			next[y] = make([]int, 2*size)
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := 4 * m[y][x]
+				next[y][x] = v
+				next[y][x+size] = v + 2
+				next[y+size][x] = v + 3
+				next[y+size][x+size] = v + 1
+			}
+		}
+		m = next
+	}
+	return m
+}
+
+// applyPaletteOrdered applies the palette of the mapper like applyPalette, but
+// offsets every pixel by the threshold of the Bayer matrix before matching it.
+// The matrix is anchored at the frame origin, so unchanged pixels get the same
+// index in every frame and static regions do not flicker. strength scales the
+// thresholds, 0 maps every pixel to its nearest color.
+func applyPaletteOrdered(frame image.Image, mapper *paletteMapper, prev *image.Paletted, matrix [][]int, strength float64) *image.Paletted {
+	bounds := frame.Bounds()
+	newFrame := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), mapper.palette)
+	n := len(matrix)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx, fy := x-bounds.Min.X, y-bounds.Min.Y
+			prevIndex := -1
+			if prev != nil && image.Pt(fx, fy).In(prev.Bounds()) {
+				prevIndex = int(prev.ColorIndexAt(fx, fy))
+			}
+
+			// Threshold in (-spread/2, spread/2), centered around zero
+			offset := ((2*matrix[fy%n][fx%n]+1)*orderedDitherSpread)/(2*n*n) - orderedDitherSpread/2
+			offset = int(math.Round(float64(offset) * strength))
+
+			c := frame.At(x, y)
+			r, g, b, a := c.RGBA()
+			target := color.RGBA{
+				R: clampChannel(int(r>>8) + offset),
+				G: clampChannel(int(g>>8) + offset),
+				B: clampChannel(int(b>>8) + offset),
+				A: uint8(a >> 8),
+			}
+			index := mapper.indexFor(target, c, prevIndex)
+			newFrame.SetColorIndex(fx, fy, uint8(index))
+		}
+	}
+
+	return newFrame
+}
+
+// ditherState is the dithering state carried from one frame to the next.
+type ditherState struct {
+	carry [][3]int // Error added to the first row of the next frame, nil for none
+}
+
+// applyPaletteDiffused applies the palette of the mapper like applyPalette
+// with Floyd–Steinberg error diffusion: the difference between every pixel
+// and its palette color is spread over its right and lower neighbors. The
+// error that would flow below the last row is carried, halved, into the first
+// row of the next frame through state, so smooth gradients do not restart
+// their pattern in every frame; a zeroed state dithers the frame on its own.
+// strength scales the spread error: 1 is plain
+// Floyd–Steinberg, 0 maps every pixel to its nearest color.
+func applyPaletteDiffused(frame image.Image, mapper *paletteMapper, prev *image.Paletted, state *ditherState, strength float64) *image.Paletted {
+	bounds := frame.Bounds()
+	w := bounds.Dx()
+	newFrame := image.NewPaletted(image.Rect(0, 0, w, bounds.Dy()), mapper.palette)
+
+	// Error rows with one extra column on each side, so no bounds checks are needed
+	curr := make([][3]int, w+2)
+	next := make([][3]int, w+2)
+	if len(state.carry) == len(curr) {
+		copy(curr, state.carry)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx, fy := x-bounds.Min.X, y-bounds.Min.Y
+			prevIndex := -1
+			if prev != nil && image.Pt(fx, fy).In(prev.Bounds()) {
+				prevIndex = int(prev.ColorIndexAt(fx, fy))
+			}
+
+			c := frame.At(x, y)
+			r, g, b, a := c.RGBA()
+			e := curr[fx+1]
+			target := color.RGBA{
+				R: clampChannel(int(r>>8) + e[0]/16),
+				G: clampChannel(int(g>>8) + e[1]/16),
+				B: clampChannel(int(b>>8) + e[2]/16),
+				A: uint8(a >> 8),
+			}
+			index := mapper.indexFor(target, c, prevIndex)
+			newFrame.SetColorIndex(fx, fy, uint8(index))
+
+			// The errors are kept in 1/16 steps, the weights are 7, 3, 5 and 1
+			pr, pg, pb, _ := mapper.palette[index].RGBA()
+			diff := [3]int{int(target.R) - int(pr>>8), int(target.G) - int(pg>>8), int(target.B) - int(pb>>8)}
+			for i, d := range diff {
+				d = int(math.Round(float64(d) * strength))
+				curr[fx+2][i] += 7 * d
+				next[fx][i] += 3 * d
+				next[fx+1][i] += 5 * d
+				next[fx+2][i] += d
+			}
+		}
+		curr, next = next, curr
+		clear(next)
+	}
+
+	// After the last row, curr holds the error diffused below the frame
+	for i := range curr {
+		for j := range curr[i] {
+			curr[i][j] /= 2
+		}
+	}
+	state.carry = curr
+
+	return newFrame
+}
+
+// clampChannel limits v to the range of an 8-bit color channel.
+func clampChannel(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
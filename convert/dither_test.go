@@ -0,0 +1,172 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"../imgcolor"
+)
+
+func TestBayerMatrix(t *testing.T) {
+	want := [][]int{
+		{0, 8, 2, 10},
+		{12, 4, 14, 6},
+		{3, 11, 1, 9},
+		{15, 7, 13, 5},
+	}
+	got := bayerMatrix(4)
+	for y := range want {
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Fatalf("bayerMatrix(4) = %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestOrderedDitherIdenticalFrames(t *testing.T) {
+	// A horizontal gray ramp between black and white dithers into a pattern
+	ramp := image.NewRGBA(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(x * 8)
+			ramp.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	frames := []image.Image{ramp, ramp}
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}}
+
+	for _, dither := range []Dither{DitherOrdered4, DitherOrdered8} {
+		result := Quantize(frames, CountColors(frames), Options{Palette: palette, Dither: dither})
+		if !bytes.Equal(result.Frames[0].Pix, result.Frames[1].Pix) {
+			t.Errorf("dither %d: identical frames were dithered differently", dither)
+		}
+
+		// Ordered dithering must mix neighboring palette colors within a row
+		plain := Quantize(frames, CountColors(frames), Options{Palette: palette})
+		if bytes.Equal(result.Frames[0].Pix, plain.Frames[0].Pix) {
+			t.Errorf("dither %d: output equals the undithered frame", dither)
+		}
+	}
+}
+
+func TestParseDither(t *testing.T) {
+	for name, want := range ditherNames {
+		if got, err := ParseDither(name); err != nil || got != want {
+			t.Errorf("ParseDither(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseDither("floyd"); err == nil {
+		t.Error("ParseDither(\"floyd\") succeeded, want error")
+	}
+}
+
+func TestTemporalDither(t *testing.T) {
+	// A slowly brightening gray gradient, the kind of content DitherTemporal is for
+	frames := make([]image.Image, 4)
+	for i := range frames {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 4))
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 16; x++ {
+				v := uint8(x*12 + i*3)
+				img.Set(x, y, color.RGBA{v, v, v, 255})
+			}
+		}
+		frames[i] = img
+	}
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}}
+	opts := Options{Palette: palette, Dither: DitherTemporal}
+
+	first := Quantize(frames, nil, opts)
+	second := Quantize(frames, nil, opts)
+	for i := range frames {
+		if !bytes.Equal(first.Frames[i].Pix, second.Frames[i].Pix) {
+			t.Errorf("frame %d differs between two runs", i)
+		}
+	}
+
+	// The first frame starts without error, just like a single frame
+	single := Quantize(frames[:1], nil, opts)
+	if !bytes.Equal(first.Frames[0].Pix, single.Frames[0].Pix) {
+		t.Error("first frame depends on the frames after it")
+	}
+
+	plain := Quantize(frames, nil, Options{Palette: palette})
+	if bytes.Equal(first.Frames[0].Pix, plain.Frames[0].Pix) {
+		t.Error("output equals the undithered frame")
+	}
+}
+
+func TestFloydSteinbergDither(t *testing.T) {
+	// A still gray gradient, the same in every frame
+	img := image.NewRGBA(image.Rect(0, 0, 16, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(x*12 + y)
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	frames := []image.Image{img, img, img}
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}}
+
+	// Without the carried error every frame is dithered on its own
+	result := Quantize(frames, nil, Options{Palette: palette, Dither: DitherFloydSteinberg})
+	for i := 1; i < len(frames); i++ {
+		if !bytes.Equal(result.Frames[i].Pix, result.Frames[0].Pix) {
+			t.Errorf("frame %d differs from the identical frame 0", i)
+		}
+	}
+	plain := Quantize(frames, nil, Options{Palette: palette})
+	if bytes.Equal(result.Frames[0].Pix, plain.Frames[0].Pix) {
+		t.Error("output equals the undithered frame")
+	}
+
+	// The zero strength of Options means full strength
+	full := Quantize(frames, nil, Options{Palette: palette, Dither: DitherFloydSteinberg, DitherStrength: 1})
+	if !bytes.Equal(full.Frames[0].Pix, result.Frames[0].Pix) {
+		t.Error("dithering without a strength differs from strength 1")
+	}
+}
+
+func TestDitherStrength(t *testing.T) {
+	// A ramp between the gray and the white palette entry, all of it nearest to gray
+	ramp := image.NewRGBA(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(140 + x)
+			ramp.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}}
+	mapper := newPaletteMapper(palette, imgcolor.RGB)
+
+	used := func(dither Dither, strength float64) int {
+		var state ditherState
+		frame := quantizeFrame(ramp, mapper, nil, dither, strength, &state)
+		seen := map[uint8]bool{}
+		for _, index := range frame.Pix {
+			seen[index] = true
+		}
+		return len(seen)
+	}
+	for _, dither := range []Dither{DitherFloydSteinberg, DitherTemporal, DitherOrdered8} {
+		if n := used(dither, 0); n != 1 {
+			t.Errorf("dither %d at strength 0.0 uses %d indices, want 1 like no dithering", dither, n)
+		}
+	}
+	if n := used(DitherTemporal, 1); n < 2 {
+		t.Errorf("Floyd–Steinberg at strength 1.0 uses %d indices, want at least 2", n)
+	}
+
+	// A gentler diffusion picks white less often
+	count := func(strength float64) int {
+		var state ditherState
+		frame := quantizeFrame(ramp, mapper, nil, DitherTemporal, strength, &state)
+		return bytes.Count(frame.Pix, []byte{2})
+	}
+	if half, full := count(0.5), count(1); half >= full {
+		t.Errorf("white pixels at strength 0.5: %d, at 1.0: %d, want fewer", half, full)
+	}
+}
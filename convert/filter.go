@@ -0,0 +1,122 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// FrameFilter transforms a single frame, e.g. to adjust its colors before the
+// palette is built. Filters return a new image and leave their input as is.
+type FrameFilter func(image.Image) image.Image
+
+// ApplyFilters applies the filters in order to every frame.
+func ApplyFilters(frames []image.Image, filters ...FrameFilter) []image.Image {
+	if len(filters) == 0 {
+		return frames
+	}
+
+	filtered := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		for _, filter := range filters {
+			frame = filter(frame)
+		}
+		filtered[i] = frame
+	}
+	return filtered
+}
+
+// Brightness adds delta to every RGB channel, clamped to 0..255.
+func Brightness(delta int) FrameFilter {
+	return pixelFilter(func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{
+			R: clampChannel(int(c.R) + delta),
+			G: clampChannel(int(c.G) + delta),
+			B: clampChannel(int(c.B) + delta),
+			A: c.A,
+		}
+	})
+}
+
+// Contrast scales the distance of every RGB channel from the middle gray 128
+// by factor: 1 keeps the frame, 0 turns it gray, above 1 increases contrast.
+func Contrast(factor float64) FrameFilter {
+	scale := func(v uint8) uint8 {
+		return clampChannel(int(roundHalfUp((float64(v)-128)*factor + 128)))
+	}
+	return pixelFilter(func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{R: scale(c.R), G: scale(c.G), B: scale(c.B), A: c.A}
+	})
+}
+
+// Saturation scales the distance of every RGB channel from the pixel's luma
+// by factor: 1 keeps the frame, 0 turns it into grayscale, above 1 saturates.
+func Saturation(factor float64) FrameFilter {
+	return pixelFilter(func(c color.NRGBA) color.NRGBA {
+		luma := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		scale := func(v uint8) uint8 {
+			return clampChannel(int(roundHalfUp((float64(v)-luma)*factor + luma)))
+		}
+		return color.NRGBA{R: scale(c.R), G: scale(c.G), B: scale(c.B), A: c.A}
+	})
+}
+
+// LumaWeights are the RGB coefficients of a luminance, summing to 1.
+type LumaWeights struct {
+	R, G, B float64
+}
+
+var (
+	// Rec601 are the ITU-R BT.601 (NTSC) weights.
+	Rec601 = LumaWeights{R: 0.299, G: 0.587, B: 0.114}
+	// Rec709 are the ITU-R BT.709 (HDTV, sRGB) weights.
+	Rec709 = LumaWeights{R: 0.2126, G: 0.7152, B: 0.0722}
+)
+
+// ParseLumaWeights returns the weights for the names "rec601" and "rec709".
+func ParseLumaWeights(name string) (LumaWeights, error) {
+	switch name {
+	case "rec601", "601":
+		return Rec601, nil
+	case "rec709", "709":
+		return Rec709, nil
+	}
+	return LumaWeights{}, fmt.Errorf("unknown luma weights %q, want rec601 or rec709", name)
+}
+
+// Luma returns the luminance of c in 0..255.
+func (w LumaWeights) Luma(c color.NRGBA) uint8 {
+	return clampChannel(int(roundHalfUp(w.R*float64(c.R) + w.G*float64(c.G) + w.B*float64(c.B))))
+}
+
+// Grayscale replaces every pixel with its luminance, so the palette built
+// afterwards only holds grays.
+func Grayscale(weights LumaWeights) FrameFilter {
+	return pixelFilter(func(c color.NRGBA) color.NRGBA {
+		l := weights.Luma(c)
+		return color.NRGBA{R: l, G: l, B: l, A: c.A}
+	})
+}
+
+// pixelFilter returns a filter mapping every pixel, unpremultiplied, with f.
+func pixelFilter(f func(color.NRGBA) color.NRGBA) FrameFilter {
+	return func(frame image.Image) image.Image {
+		bounds := frame.Bounds()
+		dst := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(frame.At(x, y)).(color.NRGBA)
+				dst.SetNRGBA(x, y, f(c))
+			}
+		}
+		return dst
+	}
+}
+
+// roundHalfUp rounds v to the nearest integer, halves up.
+func roundHalfUp(v float64) float64 {
+	if v < 0 {
+		return -roundHalfUp(-v)
+	}
+	return float64(int(v + 0.5))
+}
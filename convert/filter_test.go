@@ -0,0 +1,104 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// filterPixel applies the filter to a 1×1 image of c and returns the result.
+func filterPixel(filter FrameFilter, c color.NRGBA) color.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, c)
+	return color.NRGBAModel.Convert(filter(img).At(0, 0)).(color.NRGBA)
+}
+
+func TestBrightness(t *testing.T) {
+	got := filterPixel(Brightness(50), color.NRGBA{R: 230, G: 100, B: 0, A: 255})
+	if want := (color.NRGBA{R: 255, G: 150, B: 50, A: 255}); got != want {
+		t.Errorf("brightness +50 = %v, want %v", got, want)
+	}
+	got = filterPixel(Brightness(-50), color.NRGBA{R: 30, G: 100, B: 255, A: 255})
+	if want := (color.NRGBA{R: 0, G: 50, B: 205, A: 255}); got != want {
+		t.Errorf("brightness -50 = %v, want %v", got, want)
+	}
+}
+
+func TestContrast(t *testing.T) {
+	got := filterPixel(Contrast(2), color.NRGBA{R: 100, G: 128, B: 200, A: 255})
+	if want := (color.NRGBA{R: 72, G: 128, B: 255, A: 255}); got != want {
+		t.Errorf("contrast 2 = %v, want %v", got, want)
+	}
+	got = filterPixel(Contrast(0), color.NRGBA{R: 10, G: 250, B: 90, A: 255})
+	if want := (color.NRGBA{R: 128, G: 128, B: 128, A: 255}); got != want {
+		t.Errorf("contrast 0 = %v, want %v", got, want)
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	// Luma of (200, 100, 50) is 0.299*200 + 0.587*100 + 0.114*50 = 124.2
+	got := filterPixel(Saturation(0), color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	if want := (color.NRGBA{R: 124, G: 124, B: 124, A: 255}); got != want {
+		t.Errorf("saturation 0 = %v, want %v", got, want)
+	}
+	got = filterPixel(Saturation(1), color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	if want := (color.NRGBA{R: 200, G: 100, B: 50, A: 255}); got != want {
+		t.Errorf("saturation 1 = %v, want %v", got, want)
+	}
+	got = filterPixel(Saturation(2), color.NRGBA{R: 200, G: 100, B: 50, A: 128})
+	if want := (color.NRGBA{R: 255, G: 76, B: 0, A: 128}); got != want {
+		t.Errorf("saturation 2 = %v, want %v", got, want)
+	}
+}
+
+func TestApplyFiltersInOrder(t *testing.T) {
+	frame := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	frame.SetNRGBA(0, 0, color.NRGBA{R: 100, A: 255})
+
+	// Brightening first lets the contrast push the channel further
+	out := ApplyFilters([]image.Image{frame}, Brightness(28), Contrast(2))
+	if got := color.NRGBAModel.Convert(out[0].At(0, 0)).(color.NRGBA); got.R != 128 {
+		t.Errorf("R = %d, want 128", got.R)
+	}
+	if frame.NRGBAAt(0, 0).R != 100 {
+		t.Error("ApplyFilters modified its input")
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 200}
+	// 0.299*200 + 0.587*100 + 0.114*50 = 124.4, 0.2126*200 + 0.7152*100 + 0.0722*50 = 117.65
+	for _, tc := range []struct {
+		name string
+		want uint8
+	}{{"rec601", 124}, {"rec709", 118}} {
+		weights, err := ParseLumaWeights(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := filterPixel(Grayscale(weights), c)
+		if want := (color.NRGBA{R: tc.want, G: tc.want, B: tc.want, A: 200}); got != want {
+			t.Errorf("%s: grayscale = %v, want %v", tc.name, got, want)
+		}
+	}
+	if _, err := ParseLumaWeights("rec2020"); err == nil {
+		t.Error("ParseLumaWeights accepted an unknown name")
+	}
+}
+
+func TestGrayscalePalette(t *testing.T) {
+	frame := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			frame.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: uint8(255 - x*y), A: 255})
+		}
+	}
+	frames := ApplyFilters([]image.Image{frame}, Grayscale(Rec709))
+	_, palette := ReduceColors(frames, CountColors(frames), Options{MaxColors: 16})
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		if r != g || g != b {
+			t.Errorf("palette[%d] = %v is not gray", i, c)
+		}
+	}
+}
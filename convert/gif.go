@@ -0,0 +1,311 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// EncodeGIF writes the frames as an endlessly looping GIF. delays are in
+// milliseconds and rounded to the 1/100 s steps of GIF.
+func EncodeGIF(w io.Writer, frames []*image.Paletted, delays []int) error {
+	// GIF stores delays in 1/100th of a second
+	gifDelays := make([]int, len(delays))
+	for i, delay := range delays {
+		gifDelays[i] = (delay + 5) / 10
+	}
+
+	return gif.EncodeAll(w, &gif.GIF{
+		Image:     frames,
+		Delay:     gifDelays,
+		LoopCount: 0, // Infinite loop
+	})
+}
+
+// ClampDelays returns the delays with every delay below minDelay raised to it.
+// Many GIF viewers play delays of 0 or 10 ms much slower than intended, a
+// minimum of e.g. 20 ms keeps the preview close to the timing on the panel.
+func ClampDelays(delays []int, minDelay int) []int {
+	clamped := make([]int, len(delays))
+	for i, delay := range delays {
+		clamped[i] = max(delay, minDelay)
+	}
+	return clamped
+}
+
+// composeGIF renders the frames of a GIF onto its full canvas, as a viewer
+// shows them. GIF frames may cover only the part of the canvas that changed,
+// and the disposal method of every frame decides what is left of it for the
+// next one: DisposalNone keeps it, DisposalBackground clears its rectangle and
+// DisposalPrevious restores the canvas from before the frame was drawn.
+// image/gif already de-interlaces interlaced frames, they are composed like
+// the others. If all frames share one palette, the composed frames keep it and their
+// indices, so Quantize takes them over unchanged.
+func composeGIF(g *gif.GIF) []image.Image {
+	rect := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if rect.Empty() {
+		for _, frame := range g.Image {
+			rect = rect.Union(frame.Bounds())
+		}
+	}
+
+	var canvas gifCanvas
+	if palette := sharedPalette(Images(g.Image)); palette != nil {
+		canvas = newPalettedCanvas(rect, palette, g.BackgroundIndex)
+	} else {
+		canvas = &rgbaCanvas{image.NewRGBA(rect)}
+	}
+
+	frames := make([]image.Image, len(g.Image))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous gifCanvas
+		if disposal == gif.DisposalPrevious {
+			previous = canvas.clone()
+		}
+		canvas.draw(frame)
+		frames[i] = canvas.clone().image()
+
+		switch disposal {
+		case gif.DisposalBackground:
+			canvas.clear(frame.Bounds())
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// gifCanvas is the canvas a GIF is composed on.
+type gifCanvas interface {
+	draw(frame *image.Paletted) // Draws the opaque pixels of the frame
+	clear(rect image.Rectangle) // Resets the rectangle to the background
+	clone() gifCanvas
+	image() image.Image
+}
+
+// palettedCanvas composes frames that all use its palette by their indices.
+type palettedCanvas struct {
+	img         *image.Paletted
+	transparent int   // Index of the transparent color, -1 if there is none
+	background  uint8 // Index of cleared pixels
+}
+
+func newPalettedCanvas(rect image.Rectangle, palette []color.Color, backgroundIndex byte) *palettedCanvas {
+	c := &palettedCanvas{img: image.NewPaletted(rect, palette), transparent: -1}
+	for i, col := range palette {
+		if _, _, _, a := col.RGBA(); a == 0 {
+			c.transparent = i
+			break
+		}
+	}
+
+	// Viewers show cleared pixels transparent, without transparency the
+	// background color of the GIF is used
+	switch {
+	case c.transparent >= 0:
+		c.background = uint8(c.transparent)
+	case int(backgroundIndex) < len(palette):
+		c.background = backgroundIndex
+	}
+	c.clear(rect)
+	return c
+}
+
+func (c *palettedCanvas) draw(frame *image.Paletted) {
+	rect := frame.Bounds().Intersect(c.img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if index := frame.ColorIndexAt(x, y); int(index) != c.transparent {
+				c.img.SetColorIndex(x, y, index)
+			}
+		}
+	}
+}
+
+func (c *palettedCanvas) clear(rect image.Rectangle) {
+	rect = rect.Intersect(c.img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c.img.SetColorIndex(x, y, c.background)
+		}
+	}
+}
+
+func (c *palettedCanvas) clone() gifCanvas {
+	img := image.NewPaletted(c.img.Bounds(), c.img.Palette)
+	copy(img.Pix, c.img.Pix)
+	return &palettedCanvas{img: img, transparent: c.transparent, background: c.background}
+}
+
+func (c *palettedCanvas) image() image.Image {
+	return c.img
+}
+
+// rgbaCanvas composes frames with different palettes by their colors.
+type rgbaCanvas struct {
+	img *image.RGBA
+}
+
+func (c *rgbaCanvas) draw(frame *image.Paletted) {
+	draw.Draw(c.img, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+}
+
+func (c *rgbaCanvas) clear(rect image.Rectangle) {
+	draw.Draw(c.img, rect, image.Transparent, image.Point{}, draw.Src)
+}
+
+func (c *rgbaCanvas) clone() gifCanvas {
+	img := image.NewRGBA(c.img.Bounds())
+	copy(img.Pix, c.img.Pix)
+	return &rgbaCanvas{img}
+}
+
+func (c *rgbaCanvas) image() image.Image {
+	return c.img
+}
+
+// limitGIFFrames reads the GIF in r block by block up to the end of frame n,
+// or of all frames with n <= 0, and returns this prefix, completed with the
+// GIF trailer, for gif.DecodeAll, together with the offset in it where every
+// frame ends. Only the compressed data of the first n frames is held in
+// memory, however long the file is.
+//
+// With a stop function a broken block, e.g. a damaged trailer or a cut-off
+// frame, ends the prefix after the last complete frame instead of failing,
+// and stop is called with the error. Only a broken header or no complete
+// frame at all are an error then. Damage within the compressed data of a
+// frame is found by decodeGIFPrefix.
+func limitGIFFrames(r io.Reader, n int, stop func(error)) ([]byte, []int, error) {
+	br := bufio.NewReader(r)
+	var out bytes.Buffer
+	copyN := func(n int) error {
+		_, err := io.CopyN(&out, br, int64(n))
+		return err
+	}
+	// copySubBlocks copies data sub-blocks up to the terminating empty one
+	copySubBlocks := func() error {
+		for {
+			size, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			out.WriteByte(size)
+			if size == 0 {
+				return nil
+			}
+			if err := copyN(int(size)); err != nil {
+				return err
+			}
+		}
+	}
+	// colorTableSize returns the size of the color table a packed field announces
+	colorTableSize := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+
+	// Header and logical screen descriptor, then the global color table
+	if err := copyN(13); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	if err := copyN(colorTableSize(out.Bytes()[10])); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+
+	var ends []int // End of every complete frame
+	// fail ends the scan at a broken block, after the last complete frame when salvaging
+	fail := func(err error) ([]byte, []int, error) {
+		if stop == nil || len(ends) == 0 {
+			return nil, nil, err
+		}
+		stop(fmt.Errorf("gif: stopped after frame %d: %w", len(ends), err))
+		out.Truncate(ends[len(ends)-1])
+		out.WriteByte(0x3b)
+		return out.Bytes(), ends, nil
+	}
+
+	for n <= 0 || len(ends) < n {
+		block, err := br.ReadByte()
+		if err != nil {
+			return fail(unexpectedEOF(err))
+		}
+		out.WriteByte(block)
+		switch block {
+		case 0x21: // Extension: label and sub-blocks
+			if err := copyN(1); err != nil {
+				return fail(unexpectedEOF(err))
+			}
+			if err := copySubBlocks(); err != nil {
+				return fail(unexpectedEOF(err))
+			}
+		case 0x2c: // Image descriptor, local color table, LZW code size and data
+			if err := copyN(9); err != nil {
+				return fail(unexpectedEOF(err))
+			}
+			if err := copyN(colorTableSize(out.Bytes()[out.Len()-1]) + 1); err != nil {
+				return fail(unexpectedEOF(err))
+			}
+			if err := copySubBlocks(); err != nil {
+				return fail(unexpectedEOF(err))
+			}
+			ends = append(ends, out.Len())
+		case 0x3b: // Trailer, the file has fewer frames
+			return out.Bytes(), ends, nil
+		default:
+			return fail(errors.New("gif: unknown block type"))
+		}
+	}
+
+	out.WriteByte(0x3b)
+	return out.Bytes(), ends, nil
+}
+
+// decodeGIFPrefix decodes the longest prefix of the GIF data whose frames
+// decode without error, for a GIF that limitGIFFrames found intact block by
+// block, but whose compressed data is damaged, e.g. broken LZW codes in one
+// frame. ends are the offsets where the frames end, err is the error of
+// decoding all of them; it is returned if not even the first frame decodes,
+// otherwise stop is called with it.
+func decodeGIFPrefix(data []byte, ends []int, err error, stop func(error)) (*gif.GIF, error) {
+	// A prefix decodes if all its frames do, so the longest one is found by bisection
+	decode := func(frames int) (*gif.GIF, error) {
+		return gif.DecodeAll(bytes.NewReader(append(data[:ends[frames-1]:ends[frames-1]], 0x3b)))
+	}
+	var decoded *gif.GIF
+	good, bad := 0, len(ends)
+	for bad-good > 1 {
+		mid := (good + bad) / 2
+		if g, err := decode(mid); err == nil {
+			decoded, good = g, mid
+		} else {
+			bad = mid
+		}
+	}
+	if good == 0 {
+		return nil, err
+	}
+	stop(fmt.Errorf("gif: stopped after frame %d: %w", good, err))
+	return decoded, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for data that ends
+// before it is complete.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
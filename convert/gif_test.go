@@ -0,0 +1,534 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"../sag"
+)
+
+func TestPreserveTimingGIFRoundTrip(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	src := &gif.GIF{Delay: []int{3, 7, 25}}
+	for i := range src.Delay {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.Pix[i] = 1
+		src.Image = append(src.Image, frame)
+	}
+	filename := filepath.Join(t.TempDir(), "timing.gif")
+	var gifData bytes.Buffer
+	if err := gif.EncodeAll(&gifData, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, gifData.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	images, delays, err := GIFLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, colors := ReduceColors(images, CountColors(images), Options{})
+
+	var sagData bytes.Buffer
+	if err := sag.EncodeWithOptions(&sagData, frames, delays, colors, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := sag.Decode(&sagData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := EncodeGIF(&out, decoded, decodedDelays); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Delay, src.Delay) {
+		t.Errorf("GIF delays %v, want %v", result.Delay, src.Delay)
+	}
+}
+
+func TestClampDelaysGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 2, 2), palette),
+		image.NewPaletted(image.Rect(0, 0, 2, 2), palette),
+		image.NewPaletted(image.Rect(0, 0, 2, 2), palette),
+	}
+	delays := []int{10, 0, 100}
+
+	var buf bytes.Buffer
+	if err := EncodeGIF(&buf, frames, ClampDelays(delays, 20)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 2, 10}; !reflect.DeepEqual(result.Delay, want) {
+		t.Errorf("GIF delays %v, want %v", result.Delay, want)
+	}
+	if delays[0] != 10 || delays[1] != 0 {
+		t.Errorf("ClampDelays modified its input: %v", delays)
+	}
+}
+
+func TestGIFLoaderDisposal(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	palette := color.Palette{red, blue, green, color.RGBA{}}
+	sub := func(rect image.Rectangle, index uint8) *image.Paletted {
+		frame := image.NewPaletted(rect, palette)
+		for i := range frame.Pix {
+			frame.Pix[i] = index
+		}
+		return frame
+	}
+
+	src := &gif.GIF{
+		Image: []*image.Paletted{
+			sub(image.Rect(0, 0, 4, 4), 0), // red background
+			sub(image.Rect(1, 1, 3, 3), 1), // transient blue square
+			sub(image.Rect(0, 0, 1, 1), 2), // green corner, cleared afterwards
+			sub(image.Rect(3, 3, 4, 4), 2), // green opposite corner
+		},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{ColorModel: palette, Width: 4, Height: 4},
+	}
+	filename := filepath.Join(t.TempDir(), "disposal.gif")
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, _, err := GIFLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		frame int
+		pt    image.Point
+		want  color.Color
+	}{
+		{1, image.Pt(1, 1), blue},
+		{1, image.Pt(0, 0), red},
+		{2, image.Pt(1, 1), red}, // the blue square is gone again
+		{2, image.Pt(0, 0), green},
+		{3, image.Pt(0, 0), color.RGBA{}}, // cleared to the transparent background
+		{3, image.Pt(2, 2), red},
+		{3, image.Pt(3, 3), green},
+	}
+	for _, tt := range tests {
+		if frames[tt.frame].Bounds() != image.Rect(0, 0, 4, 4) {
+			t.Fatalf("frame %d bounds %v, want the 4x4 canvas", tt.frame, frames[tt.frame].Bounds())
+		}
+		got := color.RGBAModel.Convert(frames[tt.frame].At(tt.pt.X, tt.pt.Y))
+		if got != tt.want {
+			t.Errorf("frame %d pixel %v = %v, want %v", tt.frame, tt.pt, got, tt.want)
+		}
+	}
+}
+
+func TestSmallPaletteGIFRoundTrip(t *testing.T) {
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i*16 + 8), G: uint8(255 - i*16), B: 128, A: 255}
+	}
+	src := &gif.GIF{Delay: []int{10, 10}}
+	for i := range src.Delay {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 4), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8((j + i) % len(palette))
+		}
+		src.Image = append(src.Image, frame)
+	}
+	filename := filepath.Join(t.TempDir(), "16colors.gif")
+	var gifData bytes.Buffer
+	if err := gif.EncodeAll(&gifData, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, gifData.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	images, delays, err := GIFLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, colors := ReduceColors(images, CountColors(images), Options{})
+	if len(colors) != len(palette) {
+		t.Fatalf("quantized palette has %d colors, want %d", len(colors), len(palette))
+	}
+
+	var sagData bytes.Buffer
+	if err := sag.EncodeWithOptions(&sagData, frames, delays, colors, &sag.Options{PaletteSize: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := sag.Decode(&sagData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frame := range decoded {
+		if len(frame.Palette) != len(palette) {
+			t.Errorf("decoded frame %d has %d palette colors, want %d", i, len(frame.Palette), len(palette))
+		}
+	}
+
+	var out bytes.Buffer
+	if err := EncodeGIF(&out, decoded, decodedDelays); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(result.Image[0].Palette); got != len(palette) {
+		t.Errorf("GIF palette has %d colors, want %d", got, len(palette))
+	}
+}
+
+func TestGIFLoaderMaxFrames(t *testing.T) {
+	anim := &gif.GIF{}
+	for i := 0; i < 10; i++ {
+		// Every other frame brings its own local color table
+		palette := color.Palette{color.Black, color.White}
+		if i%2 == 1 {
+			palette = color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}, color.White}
+		}
+		frame := image.NewPaletted(image.Rect(0, 0, 16, 8), palette)
+		frame.Pix[i] = 1
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10+i)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.gif")
+	if err := os.WriteFile(full, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Cut off within the last frames, which must not be read at all
+	cut := filepath.Join(dir, "cut.gif")
+	if err := os.WriteFile(cut, buf.Bytes()[:buf.Len()*8/10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	all, _, err := GIFLoader{}.Load(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := (GIFLoader{}).Load(cut); err == nil {
+		t.Fatal("loading the cut GIF without a limit succeeded")
+	}
+
+	frames, delays, err := GIFLoader{MaxFrames: 3}.Load(cut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	if want := []int{100, 110, 120}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays %v, want %v", delays, want)
+	}
+	for i, frame := range frames {
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 16; x++ {
+				if got, want := color.RGBAModel.Convert(frame.At(x, y)), color.RGBAModel.Convert(all[i].At(x, y)); got != want {
+					t.Fatalf("frame %d pixel (%d,%d) = %v, want %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	// A limit beyond the frame count reads them all
+	if frames, _, err := (GIFLoader{MaxFrames: 50}).Load(full); err != nil || len(frames) != 10 {
+		t.Errorf("MaxFrames 50: %d frames, %v, want all 10", len(frames), err)
+	}
+}
+
+func TestReduceColorsPerFrameGIF(t *testing.T) {
+	var images []image.Image
+	for _, c := range []color.NRGBA{{R: 255, G: 200, A: 255}, {B: 60, A: 255}} {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < 16; i++ {
+			img.SetNRGBA(i%4, i/4, c)
+		}
+		img.SetNRGBA(0, 0, color.NRGBA{R: c.R / 2, G: c.G / 2, B: c.B / 2, A: 255})
+		images = append(images, img)
+	}
+
+	frames := ReduceColorsPerFrame(images, Options{})
+	var buf bytes.Buffer
+	if err := EncodeGIF(&buf, frames, []int{100, 100}); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, img := range images {
+		if n := len(frames[i].Palette); n != 2 {
+			t.Errorf("frame %d: palette of %d colors, want 2", i, n)
+		}
+		for _, p := range []image.Point{{0, 0}, {3, 3}} {
+			want := color.NRGBAModel.Convert(img.At(p.X, p.Y))
+			if got := color.NRGBAModel.Convert(g.Image[i].At(p.X, p.Y)); got != want {
+				t.Errorf("frame %d pixel %v = %v, want %v", i, p, got, want)
+			}
+		}
+	}
+}
+
+// interlacedRows returns the rows of a frame of the given height in the
+// order an interlaced GIF stores them: every 8th row from 0, every 8th from
+// 4, every 4th from 2 and every 2nd from 1.
+func interlacedRows(height int) []int {
+	var rows []int
+	for _, pass := range []struct{ start, step int }{{0, 8}, {4, 8}, {2, 4}, {1, 2}} {
+		for y := pass.start; y < height; y += pass.step {
+			rows = append(rows, y)
+		}
+	}
+	return rows
+}
+
+// encodeInterlacedGIF encodes src as a GIF with all frames interlaced. The
+// standard encoder cannot interlace, so the rows are stored in interlaced
+// order and the flag is set in every image descriptor afterwards.
+func encodeInterlacedGIF(t *testing.T, src *gif.GIF) []byte {
+	shuffled := *src
+	shuffled.Image = make([]*image.Paletted, len(src.Image))
+	for i, frame := range src.Image {
+		stored := image.NewPaletted(frame.Rect, frame.Palette)
+		for k, y := range interlacedRows(frame.Rect.Dy()) {
+			copy(stored.Pix[k*stored.Stride:(k+1)*stored.Stride], frame.Pix[y*frame.Stride:])
+		}
+		shuffled.Image[i] = stored
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &shuffled); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	tableSize := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+	skipSubBlocks := func(p int) int {
+		for data[p] != 0 {
+			p += int(data[p]) + 1
+		}
+		return p + 1
+	}
+	p := 13 + tableSize(data[10])
+	for data[p] != 0x3b {
+		switch data[p] {
+		case 0x21:
+			p = skipSubBlocks(p + 2)
+		case 0x2c:
+			data[p+9] |= 0x40
+			p = skipSubBlocks(p + 10 + tableSize(data[p+9]) + 1)
+		default:
+			t.Fatalf("unknown GIF block %#02x at %d", data[p], p)
+		}
+	}
+	return data
+}
+
+func TestGIFLoaderInterlaced(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{B: 255, A: 255},
+		color.RGBA{R: 255, G: 255, A: 255}, color.RGBA{},
+	}
+	// Every row has its own pattern, so a row in the wrong place shows
+	frame := func(rect image.Rectangle, seed int) *image.Paletted {
+		img := image.NewPaletted(rect, palette)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				img.SetColorIndex(x, y, uint8((x+y*y+seed)%5))
+			}
+		}
+		return img
+	}
+	// An optimized GIF: a full first frame, then partial frames of odd heights
+	// with transparent pixels, drawn over what the disposal left
+	src := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 10, 17), 0),
+			frame(image.Rect(2, 3, 7, 14), 1),
+			frame(image.Rect(1, 5, 9, 10), 2),
+			frame(image.Rect(0, 9, 10, 17), 3),
+		},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{ColorModel: palette, Width: 10, Height: 17},
+	}
+
+	dir := t.TempDir()
+	plainFile, interlacedFile := filepath.Join(dir, "plain.gif"), filepath.Join(dir, "interlaced.gif")
+	var plain bytes.Buffer
+	if err := gif.EncodeAll(&plain, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(plainFile, plain.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(interlacedFile, encodeInterlacedGIF(t, src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _, err := GIFLoader{}.Load(plainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, delays, err := GIFLoader{}.Load(interlacedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Bounds() != want[i].Bounds() {
+			t.Fatalf("frame %d bounds %v, want %v", i, got[i].Bounds(), want[i].Bounds())
+		}
+		for y := 0; y < 17; y++ {
+			for x := 0; x < 10; x++ {
+				if g, w := color.RGBAModel.Convert(got[i].At(x, y)), color.RGBAModel.Convert(want[i].At(x, y)); g != w {
+					t.Fatalf("frame %d pixel (%d,%d) = %v, want %v", i, x, y, g, w)
+				}
+			}
+		}
+	}
+
+	// The composed frames convert to the same SAG file as the plain GIF
+	encode := func(images []image.Image) []byte {
+		frames, reduced := ReduceColors(images, CountColors(images), Options{})
+		var buf bytes.Buffer
+		if err := sag.Encode(&buf, frames, delays, reduced); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	if !bytes.Equal(encode(got), encode(want)) {
+		t.Error("the interlaced GIF converts to another SAG file than the plain one")
+	}
+}
+
+func TestGIFLoaderLenient(t *testing.T) {
+	anim := &gif.GIF{}
+	for i := 0; i < 4; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+		frame.Pix[i] = 1
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 5)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if data[len(data)-1] != 0x3b {
+		t.Fatal("the GIF does not end with its trailer")
+	}
+
+	dir := t.TempDir()
+	damaged := map[string][]byte{
+		// Without the trailer byte
+		"no-trailer.gif": data[:len(data)-1],
+		// Garbage where the trailer should be
+		"bad-trailer.gif": append(append([]byte(nil), data[:len(data)-1]...), 0x00, 0xff, 0x12),
+	}
+	for name, content := range damaged {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := (GIFLoader{}).Load(filename); err == nil {
+			t.Errorf("%s: strict loading succeeded", name)
+		}
+
+		var warnings []error
+		frames, delays, err := GIFLoader{Lenient: true, Warn: func(err error) { warnings = append(warnings, err) }}.Load(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(frames) != 4 || len(delays) != 4 {
+			t.Fatalf("%s: %d frames, want 4", name, len(frames))
+		}
+		if len(warnings) != 1 {
+			t.Errorf("%s: %d warnings, want 1", name, len(warnings))
+		}
+		for i, frame := range frames {
+			if got := color.RGBAModel.Convert(frame.At(i, 0)); got != color.RGBAModel.Convert(color.White) {
+				t.Errorf("%s: frame %d pixel (%d,0) = %v, want white", name, i, i, got)
+			}
+		}
+	}
+
+	// Broken LZW codes in frame 2 keep frame 1, the blocks are all intact
+	_, ends, err := limitGIFFrames(bytes.NewReader(data), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	descriptor := ends[0] + bytes.IndexByte(corrupt[ends[0]:], 0x2c)
+	// Descriptor, local color table and LZW code size, then the first data sub-block
+	start := descriptor + 10 + 1
+	if packed := corrupt[descriptor+9]; packed&0x80 != 0 {
+		start += 3 << (packed&0x07 + 1)
+	}
+	block := corrupt[start+1 : start+1+int(corrupt[start])]
+	for i := range block {
+		block[i] = 0xff
+	}
+	filename := filepath.Join(dir, "lzw.gif")
+	if err := os.WriteFile(filename, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := (GIFLoader{}).Load(filename); err == nil {
+		t.Error("lzw.gif: strict loading succeeded")
+	}
+	var warnings []error
+	frames, _, err := GIFLoader{Lenient: true, Warn: func(err error) { warnings = append(warnings, err) }}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || len(warnings) != 1 {
+		t.Errorf("lzw.gif: %d frames with %d warnings, want 1 frame and 1 warning", len(frames), len(warnings))
+	}
+
+	// A frame cut in half is dropped, the complete ones before it are kept
+	filename = filepath.Join(dir, "cut.gif")
+	if err := os.WriteFile(filename, data[:len(data)-8], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	frames, _, err = GIFLoader{Lenient: true}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Errorf("cut GIF: %d frames, want 3", len(frames))
+	}
+}
@@ -0,0 +1,228 @@
+// Package convert prepares animated images for the SAG format: it loads the
+// supported source formats, transforms the frames and reduces them to a
+// shared 256-color palette.
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// DefaultDelay is the delay in milliseconds given to the frame of still images.
+const DefaultDelay = 100
+
+// ImageLoader is an interface for loading animated image formats.
+// Load returns the frames and their delays in milliseconds. Frames of still
+// images keep their full colors, the palette is built later by ReduceColors.
+// Frames of PNG and WebP images with transparent pixels are *image.NRGBA.
+type ImageLoader interface {
+	Load(filename string) ([]image.Image, []int, error)
+}
+
+// LoaderByName returns the loader for a format name: gif, tiff, webp, jpeg
+// (or jpg) and png.
+func LoaderByName(format string) (ImageLoader, error) {
+	switch format {
+	case "gif":
+		return GIFLoader{}, nil
+	case "tiff":
+		return TIFFLoader{}, nil
+	case "webp":
+		return WebPLoader{}, nil
+	case "jpeg", "jpg":
+		return JPEGLoader{}, nil
+	case "png":
+		return PNGLoader{}, nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// GIFLoader loads GIF images. With MaxFrames > 0 only the first MaxFrames
+// frames are read, the rest of the file is never decoded or held in memory.
+// Lenient keeps the complete frames of a GIF with a broken block, e.g. a
+// damaged trailer, or with damaged image data, the frames before the damaged
+// one, instead of failing; Warn, if set, gets the error that ended the frames
+// early.
+type GIFLoader struct {
+	MaxFrames int
+	Lenient   bool
+	Warn      func(err error)
+}
+
+func (g GIFLoader) Load(filename string) ([]image.Image, []int, error) {
+	frames, delays, err := g.load(filename)
+	return checkLoaded(filename, "gif", frames, delays, err)
+}
+
+func (g GIFLoader) load(filename string) ([]image.Image, []int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var gifImage *gif.GIF
+	if g.MaxFrames > 0 || g.Lenient {
+		var stop func(error)
+		if g.Lenient {
+			stop = func(err error) {
+				if g.Warn != nil {
+					g.Warn(err)
+				}
+			}
+		}
+		data, ends, err := limitGIFFrames(file, g.MaxFrames, stop)
+		if err != nil {
+			return nil, nil, err
+		}
+		gifImage, err = gif.DecodeAll(bytes.NewReader(data))
+		// Damaged image data only shows up when decoding, keep the frames before it
+		if err != nil && g.Lenient {
+			gifImage, err = decodeGIFPrefix(data, ends, err, stop)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if gifImage, err = gif.DecodeAll(file); err != nil {
+		return nil, nil, err
+	}
+
+	// GIF stores delays in 1/100th of a second, SAG in milliseconds
+	delays := make([]int, len(gifImage.Delay))
+	for i, delay := range gifImage.Delay {
+		delays[i] = delay * 10
+	}
+
+	return composeGIF(gifImage), delays, nil
+}
+
+// TIFFLoader loads TIFF images.
+type TIFFLoader struct{}
+
+func (t TIFFLoader) Load(filename string) ([]image.Image, []int, error) {
+	return loadStill(filename, "tiff", tiff.Decode)
+}
+
+// JPEGLoader loads JPEG photos as a single frame.
+type JPEGLoader struct{}
+
+func (j JPEGLoader) Load(filename string) ([]image.Image, []int, error) {
+	return loadStill(filename, "jpeg", jpeg.Decode)
+}
+
+// PNGLoader loads PNG images as a single frame.
+type PNGLoader struct{}
+
+func (p PNGLoader) Load(filename string) ([]image.Image, []int, error) {
+	return loadStill(filename, "png", png.Decode)
+}
+
+// WebPLoader loads WebP images, including animated ones.
+type WebPLoader struct{}
+
+func (w WebPLoader) Load(filename string) ([]image.Image, []int, error) {
+	frames, delays, err := w.load(filename)
+	return checkLoaded(filename, "webp", frames, delays, err)
+}
+
+func (w WebPLoader) load(filename string) ([]image.Image, []int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isAnimatedWebP(chunks) {
+		frames, delays, err := decodeAnimatedWebP(chunks)
+		return unpremultiply(frames), delays, err
+	}
+
+	img, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unpremultiply([]image.Image{img}), []int{DefaultDelay}, nil
+}
+
+// loadStill decodes a single image file as the only frame of an animation.
+func loadStill(filename, format string, decode func(io.Reader) (image.Image, error)) ([]image.Image, []int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return checkLoaded(filename, format, nil, nil, err)
+	}
+	defer file.Close()
+
+	img, err := decode(file)
+	if err != nil {
+		return checkLoaded(filename, format, nil, nil, err)
+	}
+
+	return checkLoaded(filename, format, unpremultiply([]image.Image{img}), []int{DefaultDelay}, nil)
+}
+
+// checkLoaded validates the frames returned by a loader, so unusable input is
+// rejected right away instead of failing somewhere later in the pipeline.
+// Errors are prefixed with the file name and format.
+func checkLoaded(filename, format string, frames []image.Image, delays []int, err error) ([]image.Image, []int, error) {
+	if err == nil {
+		err = validateFrames(frames, delays)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s (%s): %w", filename, format, err)
+	}
+	return frames, delays, nil
+}
+
+// validateFrames checks that there is at least one non-empty frame, every
+// frame has a delay and uses a color model the conversion supports.
+func validateFrames(frames []image.Image, delays []int) error {
+	if len(frames) == 0 {
+		return errors.New("the file contains no frames")
+	}
+	if len(delays) != len(frames) {
+		return fmt.Errorf("%d delays for %d frames", len(delays), len(frames))
+	}
+
+	for i, frame := range frames {
+		if frame.Bounds().Empty() {
+			return fmt.Errorf("frame %d is empty", i)
+		}
+		if !supportedColorModel(frame.ColorModel()) {
+			name := fmt.Sprintf("%T", frame)
+			if frame.ColorModel() == color.CMYKModel {
+				name = "CMYK"
+			}
+			return fmt.Errorf("frame %d uses the unsupported %s color model, convert it to RGB first", i, name)
+		}
+	}
+
+	return nil
+}
+
+// supportedColorModel reports whether colors of the model convert to RGB
+// faithfully. CMYK is rejected, its RGB conversion ignores color profiles.
+func supportedColorModel(m color.Model) bool {
+	switch m {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+		color.GrayModel, color.Gray16Model, color.AlphaModel, color.Alpha16Model,
+		color.YCbCrModel, color.NYCbCrAModel:
+		return true
+	}
+	_, ok := m.(color.Palette)
+	return ok
+}
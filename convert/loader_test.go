@@ -0,0 +1,270 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../sag"
+)
+
+func TestJPEGLoader(t *testing.T) {
+	// Left half red, right half blue
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 8 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "photo.jpg")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	loaded, delays, err := JPEGLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || len(delays) != 1 {
+		t.Fatalf("got %d frames and %d delays, want 1 each", len(loaded), len(delays))
+	}
+
+	frames, palette := ReduceColors(loaded, CountColors(loaded), Options{})
+
+	var buf bytes.Buffer
+	if err := sag.Encode(&buf, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := sag.NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := dec.Header()
+	if header.Width != 16 || header.Height != 8 || header.FrameCount != 1 {
+		t.Errorf("header = %dx%d with %d frames, want 16x8 with 1 frame", header.Width, header.Height, header.FrameCount)
+	}
+
+	// Away from the edge the colors must survive the conversion
+	for _, tc := range []struct {
+		x    int
+		want color.RGBA
+	}{{2, color.RGBA{R: 255, A: 255}}, {13, color.RGBA{B: 255, A: 255}}} {
+		r, g, b, _ := frames[0].At(tc.x, 4).RGBA()
+		if dr, dg, db := int(r>>8)-int(tc.want.R), int(g>>8)-int(tc.want.G), int(b>>8)-int(tc.want.B); dr*dr+dg*dg+db*db > 40*40 {
+			t.Errorf("pixel (%d,4) = %v, want about %v", tc.x, frames[0].At(tc.x, 4), tc.want)
+		}
+	}
+}
+
+func TestPNGLoaderSolidRed(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+
+	filename := filepath.Join(t.TempDir(), "red.png")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	loaded, delays, err := PNGLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, palette := ReduceColors(loaded, CountColors(loaded), Options{})
+
+	var buf bytes.Buffer
+	if err := sag.Encode(&buf, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded[0].At(2, 2); got != color.Color(red) {
+		t.Errorf("pixel (2,2) = %v after the round trip, want %v", got, red)
+	}
+}
+
+func TestLoaderByName(t *testing.T) {
+	for _, name := range []string{"gif", "tiff", "webp", "jpeg", "jpg", "png"} {
+		if _, err := LoaderByName(name); err != nil {
+			t.Errorf("LoaderByName(%q): %v", name, err)
+		}
+	}
+	if _, err := LoaderByName("bmp"); err == nil {
+		t.Error("LoaderByName(\"bmp\") succeeded, want error")
+	}
+}
+
+func TestLoaderMissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	for _, name := range []string{"gif", "tiff", "webp", "jpeg", "png"} {
+		loader, err := LoaderByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := loader.Load(missing); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: loading a missing file: %v, want os.ErrNotExist", name, err)
+		}
+	}
+}
+
+func TestSingleFrameDelay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "still.png")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, solidFrame(4, 4, color.RGBA{G: 255, A: 255})); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	loaded, delays, err := PNGLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 1 || delays[0] != DefaultDelay {
+		t.Fatalf("loader delays = %v, want [%d]", delays, DefaultDelay)
+	}
+
+	// The delay must stay in milliseconds through the SAG file
+	frames, palette := ReduceColors(loaded, CountColors(loaded), Options{})
+	var buf bytes.Buffer
+	if err := sag.Encode(&buf, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0] != DefaultDelay {
+		t.Errorf("decoded delays = %v, want [%d] ms", decoded, DefaultDelay)
+	}
+}
+
+func TestGIFLoaderTruncated(t *testing.T) {
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 16, 16), color.Palette{color.Black, color.White})
+		frame.Pix[i] = 1
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "truncated.gif")
+	if err := os.WriteFile(filename, buf.Bytes()[:buf.Len()/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := GIFLoader{}.Load(filename)
+	if err == nil {
+		t.Fatal("loading a truncated GIF succeeded")
+	}
+	if msg := err.Error(); !strings.Contains(msg, filename) || !strings.Contains(msg, "gif") {
+		t.Errorf("error %q does not name the file and format", msg)
+	}
+}
+
+func TestLoadStillUnsupportedColorModel(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cmyk.tiff")
+	if err := os.WriteFile(filename, []byte("II*\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stands in for the TIFF decoder returning a CMYK image
+	decodeCMYK := func(io.Reader) (image.Image, error) {
+		return image.NewCMYK(image.Rect(0, 0, 4, 4)), nil
+	}
+	_, _, err := loadStill(filename, "tiff", decodeCMYK)
+	if err == nil {
+		t.Fatal("loading a CMYK image succeeded")
+	}
+	if msg := err.Error(); !strings.Contains(msg, filename) || !strings.Contains(msg, "CMYK") {
+		t.Errorf("error %q does not name the file and the color model", msg)
+	}
+}
+
+func TestValidateFrames(t *testing.T) {
+	if err := validateFrames(nil, nil); err == nil {
+		t.Error("no frames passed validation")
+	}
+	frames := []image.Image{image.NewRGBA(image.Rect(0, 0, 0, 5))}
+	if err := validateFrames(frames, []int{100}); err == nil {
+		t.Error("an empty frame passed validation")
+	}
+	frames = []image.Image{image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio420)}
+	if err := validateFrames(frames, []int{100}); err != nil {
+		t.Errorf("a YCbCr JPEG frame failed validation: %v", err)
+	}
+}
+
+func TestPNGAlphaFlattenOverWhite(t *testing.T) {
+	// Half-transparent red, stored premultiplied in memory and unpremultiplied by the PNG encoder
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 128, A: 128})
+	img.SetRGBA(1, 0, color.RGBA{G: 255, A: 255})
+
+	filename := filepath.Join(t.TempDir(), "alpha.png")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	loaded, _, err := PNGLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded[0].(*image.NRGBA); !ok {
+		t.Errorf("frame is %T, want *image.NRGBA", loaded[0])
+	}
+	if !HasAlpha(loaded) {
+		t.Error("HasAlpha = false for a half-transparent pixel")
+	}
+
+	flat := Flatten(loaded, color.White)
+	if HasAlpha(flat) {
+		t.Error("HasAlpha = true after flattening")
+	}
+	got := color.RGBAModel.Convert(flat[0].At(0, 0)).(color.RGBA)
+	if want := (color.RGBA{R: 255, G: 127, B: 127, A: 255}); got != want {
+		t.Errorf("half-transparent red over white = %v, want %v", got, want)
+	}
+	if got := color.RGBAModel.Convert(flat[0].At(1, 0)).(color.RGBA); got != (color.RGBA{G: 255, A: 255}) {
+		t.Errorf("opaque pixel = %v, want it unchanged", got)
+	}
+}
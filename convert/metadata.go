@@ -0,0 +1,22 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"../sag"
+)
+
+// ParseMetadata parses metadata entries given as "key=value". The key ends at
+// the first "=", so the value may contain more of them.
+func ParseMetadata(entries []string) ([]sag.MetaEntry, error) {
+	metadata := make([]sag.MetaEntry, 0, len(entries))
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid metadata %q, want key=value", entry)
+		}
+		metadata = append(metadata, sag.MetaEntry{Key: key, Value: value})
+	}
+	return metadata, nil
+}
@@ -0,0 +1,45 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"../sag"
+)
+
+func TestParseMetadataRoundTrip(t *testing.T) {
+	metadata, err := ParseMetadata([]string{"author=Ada", "source=https://example.com/a.gif?x=1&y=2", "created=2024-05-01", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sag.MetaEntry{
+		{Key: "author", Value: "Ada"},
+		{Key: "source", Value: "https://example.com/a.gif?x=1&y=2"},
+		{Key: "created", Value: "2024-05-01"},
+		{Key: "empty", Value: ""},
+	}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Fatalf("ParseMetadata = %v, want %v", metadata, want)
+	}
+	for _, invalid := range []string{"author", "=value"} {
+		if _, err := ParseMetadata([]string{invalid}); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+
+	frame := solidFrame(4, 4, color.White)
+	var buf bytes.Buffer
+	if err := sag.EncodeWithOptions(&buf, []*image.Paletted{frame, frame}, []int{40, 40}, frame.Palette, &sag.Options{Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := sag.ReadHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(header.Metadata, want) {
+		t.Errorf("stored metadata = %v, want %v", header.Metadata, want)
+	}
+}
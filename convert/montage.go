@@ -0,0 +1,121 @@
+package convert
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+// Layout of the contact sheet written by Montage.
+const (
+	montagePadding     = 2 // Space around and between the cells
+	montageLabelHeight = 7 // Height of the label strip below every frame
+)
+
+var (
+	montageBackground = color.RGBA{R: 0x30, G: 0x30, B: 0x30, A: 0xff}
+	montageLabelColor = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// digitGlyphs is a 3×5 pixel font for the frame numbers, one row per string.
+var digitGlyphs = [10][5]string{
+	{"###", "#.#", "#.#", "#.#", "###"},
+	{".#.", "##.", ".#.", ".#.", "###"},
+	{"###", "..#", "###", "#..", "###"},
+	{"###", "..#", "###", "..#", "###"},
+	{"#.#", "#.#", "###", "..#", "..#"},
+	{"###", "#..", "###", "..#", "###"},
+	{"###", "#..", "###", "#.#", "###"},
+	{"###", "..#", "..#", "..#", "..#"},
+	{"###", "#.#", "###", "#.#", "###"},
+	{"###", "#.#", "###", "..#", "###"},
+}
+
+// Montage draws all frames into one contact sheet with cols frames per row and
+// the frame number below every frame. If cols <= 0, a roughly square grid is used.
+func Montage(frames []*image.Paletted, cols int) (*image.RGBA, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("montage: no frames")
+	}
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(frames)))))
+	}
+	if cols > len(frames) {
+		cols = len(frames)
+	}
+	rows := (len(frames) + cols - 1) / cols
+
+	// Every cell is as large as the largest frame and wide enough for its label
+	var cell image.Point
+	for _, frame := range frames {
+		size := frame.Bounds().Size()
+		cell.X = max(cell.X, size.X)
+		cell.Y = max(cell.Y, size.Y)
+	}
+	cell.X = max(cell.X, labelWidth(len(frames)-1))
+	cell.Y += montageLabelHeight
+
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		montagePadding+cols*(cell.X+montagePadding),
+		montagePadding+rows*(cell.Y+montagePadding)))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(montageBackground), image.Point{}, draw.Src)
+
+	for i, frame := range frames {
+		origin := montageCellOrigin(i, cols, cell)
+		bounds := frame.Bounds()
+		draw.Draw(sheet, bounds.Sub(bounds.Min).Add(origin), frame, bounds.Min, draw.Src)
+		drawLabel(sheet, origin.Add(image.Pt(0, cell.Y-montageLabelHeight+1)), i)
+	}
+
+	return sheet, nil
+}
+
+// montageCellOrigin returns the top-left corner of cell i of the contact sheet.
+func montageCellOrigin(i, cols int, cell image.Point) image.Point {
+	return image.Pt(
+		montagePadding+(i%cols)*(cell.X+montagePadding),
+		montagePadding+(i/cols)*(cell.Y+montagePadding),
+	)
+}
+
+// labelWidth returns the width in pixels of the label for frame number n.
+func labelWidth(n int) int {
+	return 4*len(strconv.Itoa(n)) - 1
+}
+
+// drawLabel draws the number n with its top-left corner at p.
+func drawLabel(img *image.RGBA, p image.Point, n int) {
+	for i, digit := range strconv.Itoa(n) {
+		glyph := digitGlyphs[digit-'0']
+		for y, row := range glyph {
+			for x, px := range row {
+				if px == '#' {
+					img.SetRGBA(p.X+4*i+x, p.Y+y, montageLabelColor)
+				}
+			}
+		}
+	}
+}
+
+// Layout of the palette preview written by PalettePreview.
+const (
+	paletteSwatchSize = 12 // Width and height of a swatch, wide enough for the label "255"
+	paletteColumns    = 16 // Swatches per row
+)
+
+// PalettePreview draws a swatch of every palette color with its index below,
+// 16 per row, in the layout of Montage.
+func PalettePreview(palette []color.Color) (*image.RGBA, error) {
+	if len(palette) == 0 {
+		return nil, errors.New("palette preview: empty palette")
+	}
+
+	swatches := make([]*image.Paletted, len(palette))
+	for i, c := range palette {
+		swatches[i] = image.NewPaletted(image.Rect(0, 0, paletteSwatchSize, paletteSwatchSize), color.Palette{c})
+	}
+	return Montage(swatches, paletteColumns)
+}
@@ -0,0 +1,101 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMontage(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	frames := make([]*image.Paletted, 10)
+	for i := range frames {
+		frames[i] = solidFrame(16, 12, red)
+	}
+
+	sheet, err := Montage(frames, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 columns and 3 rows of 16×(12+label) cells with padding
+	cell := image.Pt(16, 12+montageLabelHeight)
+	want := image.Rect(0, 0, montagePadding+4*(cell.X+montagePadding), montagePadding+3*(cell.Y+montagePadding))
+	if sheet.Bounds() != want {
+		t.Errorf("sheet bounds = %v, want %v", sheet.Bounds(), want)
+	}
+
+	// The last frame sits in the second column of the third row
+	origin := montageCellOrigin(9, 4, cell)
+	if got := sheet.RGBAAt(origin.X+8, origin.Y+6); got != red {
+		t.Errorf("frame 9 pixel = %v, want %v", got, red)
+	}
+	// The cell after it stays empty
+	empty := montageCellOrigin(10, 4, cell)
+	if got := sheet.RGBAAt(empty.X+8, empty.Y+6); got != montageBackground {
+		t.Errorf("empty cell pixel = %v, want background", got)
+	}
+	// The label of frame 9 starts with the top bar of the digit
+	if got := sheet.RGBAAt(origin.X, origin.Y+12+1); got != montageLabelColor {
+		t.Errorf("label pixel = %v, want label color", got)
+	}
+}
+
+func TestMontageNoFrames(t *testing.T) {
+	if _, err := Montage(nil, 8); err == nil {
+		t.Error("Montage without frames succeeded, want error")
+	}
+}
+
+func TestMontageSquareGrid(t *testing.T) {
+	frames := make([]*image.Paletted, 9)
+	for i := range frames {
+		frames[i] = solidFrame(8, 8, color.White)
+	}
+
+	sheet, err := Montage(frames, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := sheet.Bounds().Dx(), montagePadding+3*(8+montageLabelHeight+montagePadding); w != montagePadding+3*(8+montagePadding) || sheet.Bounds().Dy() != h {
+		t.Errorf("sheet bounds = %v, want a 3×3 grid", sheet.Bounds())
+	}
+}
+
+func TestPalettePreview(t *testing.T) {
+	palette := make([]color.Color, 20)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i * 12), G: 255 - uint8(i*12), B: 0x80, A: 255}
+	}
+
+	preview, err := PalettePreview(palette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every cell of the grid holds one swatch of a distinct color, the rest are empty
+	cell := image.Pt(paletteSwatchSize, paletteSwatchSize+montageLabelHeight)
+	seen := make(map[color.RGBA]bool)
+	for i := 0; i < 2*paletteColumns; i++ {
+		origin := montageCellOrigin(i, paletteColumns, cell)
+		got := preview.RGBAAt(origin.X+paletteSwatchSize/2, origin.Y+paletteSwatchSize/2)
+		if got == montageBackground {
+			continue
+		}
+		if i >= len(palette) {
+			t.Errorf("cell %d holds a swatch, the palette has %d colors", i, len(palette))
+			continue
+		}
+		if got != palette[i] {
+			t.Errorf("swatch %d = %v, want %v", i, got, palette[i])
+		}
+		seen[got] = true
+	}
+	if len(seen) != len(palette) {
+		t.Errorf("%d distinct swatches, want %d", len(seen), len(palette))
+	}
+
+	if _, err := PalettePreview(nil); err == nil {
+		t.Error("PalettePreview(nil) succeeded, want error")
+	}
+}
@@ -0,0 +1,151 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// PaletteOrder selects the order of SortPalette.
+type PaletteOrder int
+
+const (
+	ByLuminance PaletteOrder = iota // Dark to bright
+	ByHue                           // Red over green to blue, grays first
+)
+
+// paletteOrderNames maps the names accepted by ParsePaletteOrder to the orders.
+var paletteOrderNames = map[string]PaletteOrder{
+	"luminance": ByLuminance,
+	"hue":       ByHue,
+}
+
+// ParsePaletteOrder returns the palette order for a name: luminance or hue.
+func ParsePaletteOrder(name string) (PaletteOrder, error) {
+	order, ok := paletteOrderNames[name]
+	if !ok {
+		return ByLuminance, fmt.Errorf("unknown palette order %q, want luminance or hue", name)
+	}
+	return order, nil
+}
+
+// SortPalette reorders the palette and remaps the indices of all frames, so the
+// frames look exactly as before. Palette effects of the firmware (fades,
+// rotations) can then rely on the index order. The input is not modified.
+func SortPalette(frames []*image.Paletted, palette []color.Color, order PaletteOrder) ([]*image.Paletted, []color.Color) {
+	// perm[i] is the old index of the color at new index i
+	perm := make([]int, len(palette))
+	for i := range perm {
+		perm[i] = i
+	}
+	key := luminance
+	if order == ByHue {
+		key = hueKey
+	}
+	sort.SliceStable(perm, func(i, j int) bool { return key(palette[perm[i]]) < key(palette[perm[j]]) })
+
+	sorted := make([]color.Color, len(palette))
+	var remap [256]uint8
+	for newIndex, oldIndex := range perm {
+		sorted[newIndex] = palette[oldIndex]
+		remap[oldIndex] = uint8(newIndex)
+	}
+
+	remapped := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		dst := image.NewPaletted(frame.Bounds(), sorted)
+		for j, index := range frame.Pix {
+			dst.Pix[j] = remap[index]
+		}
+		remapped[i] = dst
+	}
+
+	return remapped, sorted
+}
+
+// luminance returns the Rec. 601 luma of c scaled to 0..255000.
+func luminance(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	return (299*int(r>>8) + 587*int(g>>8) + 114*int(b>>8))
+}
+
+// hueKey orders colors by hue in 6 sectors of 256 steps each, grays sort
+// before all hues. Colors of the same hue are ordered by luminance.
+func hueKey(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	rr, gg, bb := int(r>>8), int(g>>8), int(b>>8)
+	hi := max(rr, gg, bb)
+	lo := min(rr, gg, bb)
+
+	hue := -1
+	if d := hi - lo; d > 0 {
+		switch hi {
+		case rr:
+			hue = (256*(gg-bb)/d + 6*256) % (6 * 256)
+		case gg:
+			hue = 2*256 + 256*(bb-rr)/d
+		default:
+			hue = 4*256 + 256*(rr-gg)/d
+		}
+	}
+	return (hue+1)*256000 + luminance(c)
+}
+
+// OptimizeDelta remaps the indices of all frames for the delta encoding,
+// which marks a pixel unchanged only if it keeps its index. A consistent
+// renaming alone cannot change which pixels keep their index, so palette
+// entries that the SAG header stores as the same 8-bit RGB color (e.g. black
+// and the transparent black of a GIF) are merged into the one used most, and
+// the remaining entries are ordered by use, the most used first. The frames
+// look exactly as before in the SAG file, and the number of unchanged pixels
+// never decreases. The input is not modified.
+func OptimizeDelta(frames []*image.Paletted, palette []color.Color) ([]*image.Paletted, []color.Color) {
+	var uses [256]int
+	for _, frame := range frames {
+		for _, index := range frame.Pix {
+			uses[index]++
+		}
+	}
+
+	// perm[i] is the old index of the color at new index i, before merging
+	perm := make([]int, len(palette))
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.SliceStable(perm, func(i, j int) bool { return uses[perm[i]] > uses[perm[j]] })
+
+	// Entries are visited by use, so each stored color keeps its most used entry
+	var optimized []color.Color
+	var remap [256]uint8
+	kept := make(map[[3]uint8]uint8)
+	for _, oldIndex := range perm {
+		key := storedColor(palette[oldIndex])
+		newIndex, ok := kept[key]
+		if !ok {
+			newIndex = uint8(len(optimized))
+			kept[key] = newIndex
+			optimized = append(optimized, palette[oldIndex])
+		}
+		remap[oldIndex] = newIndex
+	}
+
+	remapped := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		dst := image.NewPaletted(frame.Bounds(), optimized)
+		for j, index := range frame.Pix {
+			dst.Pix[j] = remap[index]
+		}
+		remapped[i] = dst
+	}
+
+	return remapped, optimized
+}
+
+// storedColor returns c as the 8-bit RGB color the SAG header stores for it,
+// rounded like the sag package does.
+func storedColor(c color.Color) [3]uint8 {
+	r, g, b, _ := c.RGBA()
+	round := func(v uint32) uint8 { return uint8((v*0xff + 0x7fff) / 0xffff) }
+	return [3]uint8{round(r), round(g), round(b)}
+}
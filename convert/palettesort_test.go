@@ -0,0 +1,110 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSortPaletteByLuminance(t *testing.T) {
+	palette := []color.Color{
+		color.RGBA{R: 255, G: 255, B: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+		color.RGBA{A: 255},
+		color.RGBA{G: 200, A: 255},
+		color.RGBA{R: 255, A: 255},
+	}
+	frames := make([]*image.Paletted, 2)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 5, 3), palette)
+		for j := range frames[i].Pix {
+			frames[i].Pix[j] = uint8((j*3 + i) % len(palette))
+		}
+	}
+
+	sortedFrames, sorted := SortPalette(frames, palette, ByLuminance)
+
+	for i := 1; i < len(sorted); i++ {
+		if luminance(sorted[i]) < luminance(sorted[i-1]) {
+			t.Errorf("palette entry %d %v is darker than entry %d %v", i, sorted[i], i-1, sorted[i-1])
+		}
+	}
+	for i := range frames {
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				if got, want := sortedFrames[i].At(x, y), frames[i].At(x, y); got != want {
+					t.Fatalf("frame %d pixel (%d, %d) = %v after sorting, want %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+	if frames[0].Pix[0] != 0 || frames[0].Palette[0] != palette[0] {
+		t.Error("SortPalette modified its input")
+	}
+}
+
+func TestSortPaletteByHue(t *testing.T) {
+	palette := []color.Color{
+		color.RGBA{B: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{R: 128, G: 128, B: 128, A: 255},
+		color.RGBA{R: 255, A: 255},
+	}
+	_, sorted := SortPalette(nil, palette, ByHue)
+	want := []color.Color{palette[2], palette[3], palette[1], palette[0]}
+	for i := range want {
+		if sorted[i] != want[i] {
+			t.Errorf("sorted = %v, want %v", sorted, want)
+			break
+		}
+	}
+}
+
+func TestOptimizeDelta(t *testing.T) {
+	// Opaque and transparent black are the same color in the SAG header
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255}, color.RGBA{}, color.RGBA{R: 255, A: 255}}
+	frames := make([]*image.Paletted, 4)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 6, 4), palette)
+		for j := range frames[i].Pix {
+			switch {
+			case j < 12: // Background alternating between the two blacks
+				frames[i].Pix[j] = uint8(2 * (i % 2))
+			default:
+				frames[i].Pix[j] = uint8((j + i) % len(palette))
+			}
+		}
+	}
+
+	optimized, optimizedPalette := OptimizeDelta(frames, palette)
+
+	before, after := identicalPixels(frames), identicalPixels(optimized)
+	if after < before {
+		t.Errorf("identical pixels after = %d, before = %d, want no decrease", after, before)
+	}
+	if after < before+3*12 {
+		t.Errorf("identical pixels after = %d, before = %d, want the black background unchanged", after, before)
+	}
+	if len(optimizedPalette) != 3 {
+		t.Errorf("palette has %d colors, want the two blacks merged into 3", len(optimizedPalette))
+	}
+	for i := range frames {
+		for j := range frames[i].Pix {
+			if got, want := storedColor(optimizedPalette[optimized[i].Pix[j]]), storedColor(palette[frames[i].Pix[j]]); got != want {
+				t.Fatalf("frame %d pixel %d = %v after optimizing, want %v", i, j, got, want)
+			}
+		}
+	}
+
+	// Without duplicate colors the entries are only renamed, most used first
+	frame := image.NewPaletted(image.Rect(0, 0, 3, 1), palette[:2])
+	frame.Pix[0], frame.Pix[1] = 1, 1
+	renamedFrames, renamed := OptimizeDelta([]*image.Paletted{frame}, palette[:2])
+	if len(renamed) != 2 || renamed[0] != palette[1] {
+		t.Errorf("renamed palette = %v, want white first", renamed)
+	}
+	if got := renamedFrames[0].Pix; !bytes.Equal(got, []byte{0, 0, 1}) {
+		t.Errorf("renamed indices = %v, want [0 0 1]", got)
+	}
+}
@@ -0,0 +1,479 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"../imgcolor"
+)
+
+// CountColors builds one shared color count across all frames.
+func CountColors(frames []image.Image) map[color.Color]int {
+	colorCount := make(map[color.Color]int)
+	for _, frame := range frames {
+		imgcolor.CountColorsInImage(frame, colorCount)
+	}
+	return colorCount
+}
+
+// ProgressFunc is called after each frame with the number of frames done so
+// far and the total number of frames.
+type ProgressFunc func(done, total int)
+
+// Options controls how ReduceColors builds and applies the palette.
+type Options struct {
+	Quantizer        imgcolor.Quantizer // Derives the palette, imgcolor.FrequencyQuantizer if nil
+	KMeansIterations int                // Number of k-means iterations refining the palette (0 = off)
+	Metric           imgcolor.Metric    // Color distance used for matching pixels to the palette
+	Palette          []color.Color      // Fixed palette to map the frames onto instead of deriving one
+	MaxColors        int                // Largest derived palette, 256 if 0
+	Dither           Dither             // Dithering applied when mapping the pixels to the palette
+	DitherStrength   float64            // Share of the dithering from 0 (none) to 1, full if 0; use DitherNone to switch it off
+	Progress         ProgressFunc       // Called after every mapped frame, may be nil
+
+	// Reserved forces colors into fixed palette slots, e.g. for overlays of
+	// the firmware. The other colors fill the remaining slots and the pixels
+	// are only mapped onto them, never onto a reserved slot.
+	Reserved map[int]color.Color
+}
+
+// QuantizeResult is the outcome of Quantize.
+type QuantizeResult struct {
+	Frames    []*image.Paletted // Frames mapped onto the palette
+	Palette   []color.Color     // Shared palette of all frames
+	MeanError float64           // Mean squared RGB error per pixel between source and quantized frames
+	MaxError  int               // Largest squared RGB error of a single pixel
+}
+
+// ReduceColors reduces the colors of all frames to one shared palette of at most
+// 256 colors and returns the paletted frames together with that palette.
+// It is Quantize without the error measurement.
+func ReduceColors(frames []image.Image, colorCount map[color.Color]int, opts Options) ([]*image.Paletted, []color.Color) {
+	result := Quantize(frames, colorCount, opts)
+	return result.Frames, result.Palette
+}
+
+// ReduceColorsPerFrame reduces the colors of every frame on its own to a
+// palette of at most 256 colors, for animations whose colors change too much
+// for one shared palette. Every frame gets its own palette; temporal
+// dithering starts over with each frame.
+func ReduceColorsPerFrame(frames []image.Image, opts Options) []*image.Paletted {
+	progress := opts.Progress
+	opts.Progress = nil
+
+	paletted := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		single := []image.Image{frame}
+		reduced, _ := ReduceColors(single, CountColors(single), opts)
+		paletted[i] = reduced[0]
+		if progress != nil {
+			progress(i+1, len(frames))
+		}
+	}
+	return paletted
+}
+
+// Quantize reduces the colors of all frames to one shared palette of at most
+// 256 colors and measures the color error this introduces.
+// colorCount is the count of all frames as returned by CountColors. With a
+// fixed opts.Palette, the frames are mapped onto it as it is. Without one,
+// frames that are all paletted with the same palette keep it, without its
+// duplicate entries, and their indices, unless the quantizer, k-means, the
+// metric or dithering are set.
+//
+// The palette holds every color only once and all frames share one color to
+// index assignment. Where a pixel is equally close to its previous index and
+// another palette entry, the previous index is kept, so the identical-bytes
+// of the SAG delta encoding find as many unchanged pixels as possible.
+//
+// With opts.Reserved the palette leaves room for the reserved colors, which
+// are inserted at their indices afterwards. The reserved colors must fit, see
+// Options.Check; Quantize panics if they do not.
+func Quantize(frames []image.Image, colorCount map[color.Color]int, opts Options) QuantizeResult {
+	if err := opts.Check(); err != nil {
+		panic(err)
+	}
+	result := quantize(frames, colorCount, opts)
+	if len(opts.Reserved) > 0 {
+		result.Palette = reserveColors(result.Frames, result.Palette, opts.Reserved)
+	}
+	return result
+}
+
+// Check reports options that cannot be quantized: reserved indices outside of
+// 0 to 255, or a fixed palette that leaves no room for the reserved colors
+// within the 256 entries of a SAG palette.
+func (opts Options) Check() error {
+	for index := range opts.Reserved {
+		if index < 0 || index > 255 {
+			return fmt.Errorf("reserved palette index %d is outside of 0 to 255", index)
+		}
+	}
+	if len(opts.Reserved) >= 256 {
+		return fmt.Errorf("%d reserved colors leave no palette slot for the frames", len(opts.Reserved))
+	}
+	if len(opts.Palette)+len(opts.Reserved) > 256 {
+		return fmt.Errorf("%d palette colors and %d reserved colors exceed the 256 palette entries", len(opts.Palette), len(opts.Reserved))
+	}
+	return nil
+}
+
+// reserveColors inserts the reserved colors into the palette at their
+// indices and moves the other colors, together with the indices of the
+// frames, to the free slots in order. Slots beyond the palette that are
+// skipped to reach a reserved index are black.
+func reserveColors(frames []*image.Paletted, palette []color.Color, reserved map[int]color.Color) []color.Color {
+	full, slots := reservedPalette(palette, reserved)
+	for _, frame := range frames {
+		for i, index := range frame.Pix {
+			frame.Pix[i] = slots[index]
+		}
+		frame.Palette = full
+	}
+	return full
+}
+
+// reservedPalette returns the palette of reserveColors and the new index of
+// every entry of palette in it.
+func reservedPalette(palette []color.Color, reserved map[int]color.Color) ([]color.Color, []uint8) {
+	size := len(palette) + len(reserved)
+	for index := range reserved {
+		size = max(size, index+1)
+	}
+
+	full := make([]color.Color, size)
+	slots := make([]uint8, len(palette))
+	next := 0
+	for i := range full {
+		switch c, ok := reserved[i]; {
+		case ok:
+			full[i] = c
+		case next < len(palette):
+			full[i] = palette[next]
+			slots[next] = uint8(i)
+			next++
+		default:
+			full[i] = color.RGBA{A: 0xff}
+		}
+	}
+	return full, slots
+}
+
+// quantize is Quantize without the reserved colors.
+func quantize(frames []image.Image, colorCount map[color.Color]int, opts Options) QuantizeResult {
+	palette := opts.Palette
+	if palette == nil {
+		// Frames that already share a palette are taken over without any error
+		if shared := sharedPalette(frames); shared != nil && opts.keepsSharedPalette() {
+			if deduped, slots := dedupePaletteIndices(shared); len(deduped) <= opts.maxColors() {
+				return QuantizeResult{Frames: copyPaletted(frames, deduped, slots, opts.Progress), Palette: deduped}
+			}
+		}
+		palette = BuildPalette(colorCount, opts)
+	}
+
+	// Convert all frames to the new palette
+	mapper := newPaletteMapper(palette, opts.Metric)
+	paletted := make([]*image.Paletted, len(frames))
+	var state ditherState
+	for i, frame := range frames {
+		var prev *image.Paletted
+		if i > 0 {
+			prev = paletted[i-1]
+		}
+		paletted[i] = quantizeFrame(frame, mapper, prev, opts.Dither, opts.ditherStrength(), &state)
+		if opts.Progress != nil {
+			opts.Progress(i+1, len(frames))
+		}
+	}
+
+	result := QuantizeResult{Frames: paletted, Palette: palette, MaxError: mapper.maxError}
+	if mapper.pixels > 0 {
+		result.MeanError = float64(mapper.totalError) / float64(mapper.pixels)
+	}
+	return result
+}
+
+// AdaptiveQuantize quantizes the frames like Quantize with the smallest
+// palette of 2, 4, 8, … or 256 colors whose mean error (see
+// QuantizeResult.MeanError) is at most maxError. If no size meets the target,
+// the result with 256 colors is returned. opts.MaxColors is ignored; with a
+// fixed opts.Palette it is the same as Quantize.
+func AdaptiveQuantize(frames []image.Image, colorCount map[color.Color]int, opts Options, maxError float64) QuantizeResult {
+	if opts.Palette != nil {
+		return Quantize(frames, colorCount, opts)
+	}
+	var result QuantizeResult
+	for size := 2; size <= 256; size *= 2 {
+		opts.MaxColors = size
+		result = Quantize(frames, colorCount, opts)
+		if result.MeanError <= maxError {
+			break
+		}
+	}
+	return result
+}
+
+// SampleColors counts the colors of every step-th pixel of every step-th row
+// of all frames, a representative sample for BuildPalette that takes
+// 1/(step*step) of the time of CountColors. Colors are stored as color.RGBA.
+func SampleColors(frames []image.Image, step int) map[color.Color]int {
+	step = max(step, 1)
+	colorCount := make(map[color.Color]int)
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+			for x := bounds.Min.X; x < bounds.Max.X; x += step {
+				colorCount[color.RGBAModel.Convert(frame.At(x, y))]++
+			}
+		}
+	}
+	return colorCount
+}
+
+// BuildPalette derives a palette of at most opts.MaxColors (default 256)
+// distinct colors from the color count with the quantizer of the options. It is the first pass of a two-pass
+// quantization, e.g. from the count of SampleColors; the second pass is
+// Quantize or ReduceColors with the palette as Options.Palette.
+func BuildPalette(colorCount map[color.Color]int, opts Options) []color.Color {
+	return dedupePalette(opts.quantizer().Quantize(colorCount, opts.maxColors()))
+}
+
+// maxColors returns opts.MaxColors, or 256 if it is not set, less the
+// reserved colors.
+func (opts Options) maxColors() int {
+	n := opts.MaxColors
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+	return max(1, n-len(opts.Reserved))
+}
+
+// keepsSharedPalette reports whether frames that share a palette keep it as
+// their result: no option derives the palette or maps the colors differently.
+func (opts Options) keepsSharedPalette() bool {
+	return opts.Quantizer == nil && opts.KMeansIterations <= 0 && opts.Metric == imgcolor.RGB &&
+		opts.Dither == DitherNone
+}
+
+// quantizer returns opts.Quantizer, refined by k-means if KMeansIterations is set.
+func (opts Options) quantizer() imgcolor.Quantizer {
+	q := opts.Quantizer
+	if q == nil {
+		q = imgcolor.FrequencyQuantizer{}
+	}
+	if opts.KMeansIterations > 0 {
+		q = imgcolor.KMeansQuantizer{Initial: q, Iterations: opts.KMeansIterations, Metric: opts.Metric}
+	}
+	return q
+}
+
+// sharedPalette returns the palette of the frames if all of them are paletted
+// with the same palette, e.g. the global color table of a GIF, otherwise nil.
+func sharedPalette(frames []image.Image) []color.Color {
+	if len(frames) == 0 {
+		return nil
+	}
+	first, ok := frames[0].(*image.Paletted)
+	if !ok || len(first.Palette) > 256 {
+		return nil
+	}
+	for _, frame := range frames[1:] {
+		p, ok := frame.(*image.Paletted)
+		if !ok || !samePalette(p.Palette, first.Palette) {
+			return nil
+		}
+	}
+	return append([]color.Color(nil), first.Palette...)
+}
+
+// samePalette reports whether both palettes hold the same colors in the same order.
+func samePalette(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		r1, g1, b1, a1 := a[i].RGBA()
+		r2, g2, b2, a2 := b[i].RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+			return false
+		}
+	}
+	return true
+}
+
+// copyPaletted copies the indices of paletted frames that all use the same
+// palette into new frames of palette with their origin at (0,0). slots maps
+// every source index to its index in palette.
+func copyPaletted(frames []image.Image, palette []color.Color, slots []uint8, progress ProgressFunc) []*image.Paletted {
+	paletted := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		src := frame.(*image.Paletted)
+		bounds := src.Bounds()
+		dst := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+		for y := 0; y < bounds.Dy(); y++ {
+			start := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := dst.Pix[y*dst.Stride : y*dst.Stride+bounds.Dx()]
+			for x, index := range src.Pix[start : start+bounds.Dx()] {
+				// Indices beyond the palette of a broken file are kept as they are
+				if int(index) < len(slots) {
+					index = slots[index]
+				}
+				row[x] = index
+			}
+		}
+		paletted[i] = dst
+		if progress != nil {
+			progress(i+1, len(frames))
+		}
+	}
+	return paletted
+}
+
+// Images converts paletted frames, e.g. those of a decoded GIF, into plain images.
+func Images(frames []*image.Paletted) []image.Image {
+	images := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		images[i] = frame
+	}
+	return images
+}
+
+// dedupePalette removes palette entries that a SAG file stores as the same
+// 8-bit RGB value as an earlier entry (see storedColor).
+func dedupePalette(palette []color.Color) []color.Color {
+	deduped, _ := dedupePaletteIndices(palette)
+	return deduped
+}
+
+// dedupePaletteIndices is dedupePalette that also returns the index of every
+// entry of palette in the deduplicated palette.
+func dedupePaletteIndices(palette []color.Color) ([]color.Color, []uint8) {
+	seen := make(map[[3]uint8]uint8, len(palette))
+	deduped := make([]color.Color, 0, len(palette))
+	slots := make([]uint8, len(palette))
+	for i, c := range palette {
+		key := storedColor(c)
+		index, ok := seen[key]
+		if !ok {
+			index = uint8(len(deduped))
+			seen[key] = index
+			deduped = append(deduped, c)
+		}
+		slots[i] = index
+	}
+	return deduped, slots
+}
+
+// paletteMapper assigns palette indices to colors. The nearest index and its
+// distance are cached per color, so every occurrence of a color is matched the
+// same way in all frames and the palette is scanned only once per color.
+// It also sums up the RGB error of all mapped pixels.
+type paletteMapper struct {
+	palette []color.Color
+	metric  imgcolor.Metric
+	tree    *imgcolor.PaletteKDTree // Nearest-entry index for the RGB metric, nil for the others
+	cache   map[color.Color]paletteMatch
+
+	pixels     int
+	totalError int64
+	maxError   int
+}
+
+// paletteMatch is the nearest palette index of a color and its distance.
+type paletteMatch struct {
+	index    int
+	distance int
+}
+
+func newPaletteMapper(palette []color.Color, metric imgcolor.Metric) *paletteMapper {
+	m := &paletteMapper{palette: palette, metric: metric, cache: make(map[color.Color]paletteMatch)}
+	if metric == imgcolor.RGB {
+		m.tree = imgcolor.NewPaletteKDTree(palette)
+	}
+	return m
+}
+
+// index returns the palette index for c. If prev >= 0 is the index of the same
+// pixel in the previous frame and it is as close to c as the nearest entry,
+// prev is returned instead.
+func (m *paletteMapper) index(c color.Color, prev int) int {
+	return m.indexFor(c, c, prev)
+}
+
+// indexFor returns the palette index for target like index, but measures the
+// error against the source color c. Dithering uses it to match an offset color.
+func (m *paletteMapper) indexFor(target, c color.Color, prev int) int {
+	match, ok := m.cache[target]
+	if !ok {
+		if m.tree != nil {
+			match.index = m.tree.Nearest(target)
+		} else {
+			match.index = m.metric.NearestColorIndex(m.palette, target)
+		}
+		match.distance = m.metric.Distance(target, m.palette[match.index])
+		m.cache[target] = match
+	}
+
+	index := match.index
+	if prev >= 0 && prev != match.index && prev < len(m.palette) && m.metric.Distance(target, m.palette[prev]) == match.distance {
+		index = prev
+	}
+
+	dist := imgcolor.RGB.Distance(c, m.palette[index])
+	m.pixels++
+	m.totalError += int64(dist)
+	m.maxError = max(m.maxError, dist)
+
+	return index
+}
+
+// ditherStrength returns the share of the dithering to apply, 1 if unset.
+func (o Options) ditherStrength() float64 {
+	if o.DitherStrength <= 0 || o.DitherStrength > 1 {
+		return 1
+	}
+	return o.DitherStrength
+}
+
+// quantizeFrame maps a frame onto the palette of the mapper with the given
+// dithering, scaled by strength. state carries the dithering state from frame
+// to frame, it starts zeroed for the first frame.
+func quantizeFrame(frame image.Image, mapper *paletteMapper, prev *image.Paletted, dither Dither, strength float64, state *ditherState) *image.Paletted {
+	if strength == 0 {
+		return applyPalette(frame, mapper, prev)
+	}
+	switch dither {
+	case DitherTemporal:
+		return applyPaletteDiffused(frame, mapper, prev, state, strength)
+	case DitherFloydSteinberg:
+		return applyPaletteDiffused(frame, mapper, prev, &ditherState{}, strength)
+	}
+	if matrix := dither.matrix(); matrix != nil {
+		return applyPaletteOrdered(frame, mapper, prev, matrix, strength)
+	}
+	return applyPalette(frame, mapper, prev)
+}
+
+// applyPalette applies the palette of the mapper to an image. prev is the
+// previous quantized frame or nil. The returned frame always starts at (0,0),
+// even if the image (e.g. a cropped sub-image) does not.
+func applyPalette(frame image.Image, mapper *paletteMapper, prev *image.Paletted) *image.Paletted {
+	bounds := frame.Bounds()
+	newFrame := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), mapper.palette)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx, fy := x-bounds.Min.X, y-bounds.Min.Y
+			prevIndex := -1
+			if prev != nil && image.Pt(fx, fy).In(prev.Bounds()) {
+				prevIndex = int(prev.ColorIndexAt(fx, fy))
+			}
+			index := mapper.index(frame.At(x, y), prevIndex)
+			newFrame.SetColorIndex(fx, fy, uint8(index))
+		}
+	}
+
+	return newFrame
+}
@@ -0,0 +1,518 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../imgcolor"
+)
+
+// identicalPixels counts the pixels that keep their index from one frame to the next.
+func identicalPixels(frames []*image.Paletted) int {
+	return NewStats(nil, nil, frames, frames[0].Palette).IdenticalPixels
+}
+
+func TestReduceColorsTemporalStability(t *testing.T) {
+	// (10,0,0) is exactly between the palette colors (0,0,0) and (20,0,0)
+	between := color.RGBA{R: 10, A: 255}
+	frame1 := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	frame2 := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		frame1.Set(x, 0, color.RGBA{R: 20, A: 255})
+		frame2.Set(x, 0, between)
+	}
+	frame1.Set(0, 0, color.RGBA{A: 255})
+	frames := []image.Image{frame1, frame2}
+
+	colorCount := map[color.Color]int{
+		color.RGBA{R: 20, A: 255}: 5,
+		color.RGBA{A: 255}:        4,
+	}
+
+	// Before: every frame is mapped on its own, the tie always goes to index 0 (black)
+	palette := imgcolor.ExtractPalette(colorCount, 256)
+	mapper := newPaletteMapper(palette, imgcolor.RGB)
+	independent := []*image.Paletted{applyPalette(frame1, mapper, nil), applyPalette(frame2, mapper, nil)}
+
+	// After: the tie keeps the index of the previous frame
+	stable, _ := ReduceColors(frames, colorCount, Options{})
+
+	before, after := identicalPixels(independent), identicalPixels(stable)
+	if after <= before {
+		t.Errorf("identical pixels after = %d, before = %d, want an improvement", after, before)
+	}
+	if after != 4 {
+		t.Errorf("identical pixels = %d, want all 4", after)
+	}
+}
+
+func TestReduceColorsDedupesPalette(t *testing.T) {
+	// The same visual red as two different color types
+	colorCount := map[color.Color]int{
+		color.RGBA{R: 255, A: 255}:  3,
+		color.NRGBA{R: 255, A: 255}: 2,
+		color.RGBA{B: 255, A: 255}:  1,
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	frame.Set(0, 0, color.RGBA{R: 255, A: 255})
+	frame.Set(1, 0, color.NRGBA{R: 255, A: 255})
+
+	frames, palette := ReduceColors([]image.Image{frame}, colorCount, Options{})
+	if len(palette) != 2 {
+		t.Errorf("palette has %d colors, want 2: %v", len(palette), palette)
+	}
+	if frames[0].ColorIndexAt(0, 0) != frames[0].ColorIndexAt(1, 0) {
+		t.Error("equal colors were written with different indices")
+	}
+}
+
+func TestReduceColorsFixedPalette(t *testing.T) {
+	act := make([]byte, 772)
+	brand := []color.RGBA{{R: 200, A: 255}, {G: 200, A: 255}, {B: 200, A: 255}}
+	for i, c := range brand {
+		act[i*3], act[i*3+1], act[i*3+2] = c.R, c.G, c.B
+	}
+	act[769] = byte(len(brand))
+
+	filename := filepath.Join(t.TempDir(), "brand.act")
+	if err := os.WriteFile(filename, act, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	palette, err := imgcolor.LoadPalette(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A gradient with many more colors than the palette
+	frame := image.NewRGBA(image.Rect(0, 0, 64, 4))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 4; y++ {
+			frame.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 60), B: uint8(255 - x*4), A: 255})
+		}
+	}
+	frames := []image.Image{frame}
+
+	paletted, used := ReduceColors(frames, CountColors(frames), Options{Palette: palette})
+	if len(used) != len(brand) {
+		t.Fatalf("output palette has %d colors, want %d", len(used), len(brand))
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 64; x++ {
+			c := paletted[0].At(x, y)
+			if c != color.Color(brand[0]) && c != color.Color(brand[1]) && c != color.Color(brand[2]) {
+				t.Fatalf("pixel (%d,%d) = %v is not a brand color", x, y, c)
+			}
+		}
+	}
+}
+
+func TestQuantizeError(t *testing.T) {
+	colors := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	frame := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for x := 0; x < 3; x++ {
+		frame.SetRGBA(x, 0, colors[x])
+		frame.SetRGBA(x, 1, colors[2-x])
+	}
+	frames := []image.Image{frame}
+
+	// The image fits into the palette, so nothing is lost
+	result := Quantize(frames, CountColors(frames), Options{})
+	if result.MeanError != 0 || result.MaxError != 0 {
+		t.Errorf("error = %v mean, %d max, want 0", result.MeanError, result.MaxError)
+	}
+
+	// Forcing a single red palette entry makes the green and blue pixels lossy
+	result = Quantize(frames, CountColors(frames), Options{Palette: []color.Color{colors[0]}})
+	if want := 255*255 + 255*255; result.MaxError != want {
+		t.Errorf("max error = %d, want %d", result.MaxError, want)
+	}
+	if want := float64(4*(255*255+255*255)) / 6; result.MeanError != want {
+		t.Errorf("mean error = %v, want %v", result.MeanError, want)
+	}
+}
+
+func TestQuantizeProgress(t *testing.T) {
+	frames := []image.Image{
+		solidFrame(2, 2, color.RGBA{R: 255, A: 255}),
+		solidFrame(2, 2, color.RGBA{G: 255, A: 255}),
+		solidFrame(2, 2, color.RGBA{B: 255, A: 255}),
+	}
+
+	var calls [][2]int
+	opts := Options{Progress: func(done, total int) { calls = append(calls, [2]int{done, total}) }}
+	Quantize(frames, CountColors(frames), opts)
+
+	want := [][2]int{{1, 3}, {2, 3}, {3, 3}}
+	if len(calls) != len(want) {
+		t.Fatalf("got %d progress calls, want %d", len(calls), len(want))
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("call %d = %v, want %v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestQuantizeKeepsSharedGIFPalette(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{A: 255},
+		color.RGBA{R: 200, G: 10, B: 10, A: 255},
+		color.RGBA{R: 201, G: 10, B: 10, A: 255},
+		color.RGBA{R: 10, G: 10, B: 250, A: 255},
+	}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 6, 4), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8((j + i) % len(palette))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := Images(decoded.Image)
+	result := Quantize(frames, CountColors(frames), Options{})
+
+	if !samePalette(result.Palette, palette) {
+		t.Errorf("palette = %v, want the source palette %v", result.Palette, palette)
+	}
+	if result.MeanError != 0 || result.MaxError != 0 {
+		t.Errorf("error = %v mean, %d max, want 0", result.MeanError, result.MaxError)
+	}
+	for i, frame := range result.Frames {
+		if !bytes.Equal(frame.Pix, anim.Image[i].Pix) {
+			t.Errorf("frame %d indices changed", i)
+		}
+	}
+}
+
+func TestQuantizeSharedPaletteDuplicates(t *testing.T) {
+	// Entry 2 repeats entry 1, as GIF encoders padding their tables do
+	red := color.RGBA{R: 255, A: 255}
+	palette := color.Palette{color.RGBA{A: 255}, red, red, color.RGBA{B: 255, A: 255}}
+	frames := make([]image.Image, 2)
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 1), palette)
+		copy(frame.Pix, []uint8{0, 1, 2, 3})
+		frames[i] = frame
+	}
+
+	result := Quantize(frames, CountColors(frames), Options{})
+	if want := (color.Palette{palette[0], red, palette[3]}); !samePalette(result.Palette, want) {
+		t.Errorf("palette = %v, want %v", result.Palette, want)
+	}
+	for i, frame := range result.Frames {
+		if want := []uint8{0, 1, 1, 2}; !bytes.Equal(frame.Pix, want) {
+			t.Errorf("frame %d indices = %v, want %v", i, frame.Pix, want)
+		}
+	}
+
+	// Options that derive or map the colors differently are not bypassed
+	for _, opts := range []Options{
+		{KMeansIterations: 2},
+		{Quantizer: imgcolor.FrequencyQuantizer{}},
+		{Metric: imgcolor.Lab},
+		{Dither: DitherOrdered8},
+	} {
+		if opts.keepsSharedPalette() {
+			t.Errorf("%+v keeps the shared palette", opts)
+		}
+	}
+}
+
+func TestDedupePaletteRounds(t *testing.T) {
+	// 16-bit colors are deduplicated by the 8-bit value the SAG file stores,
+	// which rounds: 0x01ff and 0x0280 are both stored as 2, 0x0100 as 1
+	palette := []color.Color{
+		color.RGBA64{R: 0x0100, A: 0xffff},
+		color.RGBA64{R: 0x01ff, A: 0xffff},
+		color.RGBA64{R: 0x0280, A: 0xffff},
+	}
+	deduped, slots := dedupePaletteIndices(palette)
+	if len(deduped) != 2 || !bytes.Equal(slots, []uint8{0, 1, 1}) {
+		t.Errorf("deduplicated to %v with indices %v, want 2 entries and [0 1 1]", deduped, slots)
+	}
+}
+
+func TestQuantizeLocalColorTables(t *testing.T) {
+	// Index 0 and 1 mean different colors in each frame's local color table
+	red, green := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}
+	blue, white := color.RGBA{B: 255, A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	anim := &gif.GIF{}
+	for _, palette := range []color.Palette{{red, green}, {blue, white}, {green, red}} {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(j % 2)
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := Images(decoded.Image)
+	result := Quantize(frames, CountColors(frames), Options{})
+	if len(result.Palette) != 4 {
+		t.Errorf("got %d palette colors, want 4", len(result.Palette))
+	}
+	for i, frame := range result.Frames {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 4; x++ {
+				r1, g1, b1, _ := frame.At(x, y).RGBA()
+				r2, g2, b2, _ := anim.Image[i].At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Errorf("frame %d pixel (%d, %d) = %v, want %v", i, x, y, frame.At(x, y), anim.Image[i].At(x, y))
+				}
+			}
+		}
+	}
+	if result.MaxError != 0 {
+		t.Errorf("max error = %d, want 0", result.MaxError)
+	}
+}
+
+func TestReduceColorsLeavesInputUnchanged(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i * 7)
+	}
+	palette := color.Palette{color.Black, color.White}
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	paletted.Pix[5] = 1
+
+	for _, frames := range [][]image.Image{
+		{rgba, rgba},         // palette built from the colors
+		{paletted, paletted}, // shared palette taken over
+	} {
+		input := append([]image.Image(nil), frames...)
+		pix := make([][]byte, len(frames))
+		for i, frame := range frames {
+			pix[i] = append([]byte(nil), framePix(frame)...)
+		}
+
+		result, _ := ReduceColors(frames, CountColors(frames), Options{Dither: DitherOrdered8})
+
+		for i, frame := range frames {
+			if frame != input[i] {
+				t.Errorf("%T: frame %d of the input slice replaced", input[0], i)
+			}
+			if !bytes.Equal(framePix(frame), pix[i]) {
+				t.Errorf("%T: pixels of frame %d modified", input[0], i)
+			}
+			if image.Image(result[i]) == frame {
+				t.Errorf("%T: frame %d returned instead of a new one", input[0], i)
+			}
+		}
+	}
+}
+
+// framePix returns the pixel buffer of an RGBA or paletted frame.
+func framePix(frame image.Image) []byte {
+	switch f := frame.(type) {
+	case *image.RGBA:
+		return f.Pix
+	case *image.Paletted:
+		return f.Pix
+	}
+	return nil
+}
+
+func TestTwoPassFixedPalette(t *testing.T) {
+	frames := Images([]*image.Paletted{
+		solidFrame(8, 8, color.RGBA{R: 250, G: 10, B: 10, A: 255}),
+		solidFrame(8, 8, color.RGBA{R: 10, G: 10, B: 240, A: 255}),
+	})
+	palette := BuildPalette(SampleColors(frames, 2), Options{})
+	if len(palette) != 2 {
+		t.Fatalf("sampled palette has %d colors, want 2", len(palette))
+	}
+
+	// A quantizer that must not be asked, the palette is already given
+	opts := Options{Palette: palette, Quantizer: panicQuantizer{}, Dither: DitherOrdered8}
+	first := Quantize(frames, nil, opts)
+	second := Quantize(frames, nil, opts)
+
+	if !samePalette(first.Palette, palette) {
+		t.Errorf("palette = %v, want the given %v", first.Palette, palette)
+	}
+	for i := range first.Frames {
+		if !bytes.Equal(first.Frames[i].Pix, second.Frames[i].Pix) {
+			t.Errorf("frame %d differs between two runs", i)
+		}
+	}
+}
+
+// panicQuantizer fails the test if a palette is derived.
+type panicQuantizer struct{}
+
+func (panicQuantizer) Quantize(map[color.Color]int, int) []color.Color {
+	panic("palette derived despite Options.Palette")
+}
+
+func TestSampleColors(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	frame.SetRGBA(1, 1, color.RGBA{G: 255, A: 255}) // not on the sample grid
+	colorCount := SampleColors([]image.Image{frame}, 2)
+	if len(colorCount) != 1 || colorCount[color.RGBA{}] != 4 {
+		t.Errorf("SampleColors = %v, want 4 samples of one color", colorCount)
+	}
+}
+
+func TestAdaptiveQuantize(t *testing.T) {
+	colors := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}, {R: 255, G: 255, B: 255, A: 255}}
+	frame := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		frame.SetRGBA(i%8, i/8, colors[i%len(colors)])
+	}
+	frames := []image.Image{frame}
+
+	result := AdaptiveQuantize(frames, CountColors(frames), Options{}, 100)
+	if len(result.Palette) != 4 {
+		t.Errorf("palette has %d colors, want 4", len(result.Palette))
+	}
+	if result.MeanError != 0 {
+		t.Errorf("mean error = %v, want 0", result.MeanError)
+	}
+
+	// A loose target accepts the smallest size already
+	if result := AdaptiveQuantize(frames, CountColors(frames), Options{}, 1e9); len(result.Palette) != 2 {
+		t.Errorf("palette has %d colors with a loose target, want 2", len(result.Palette))
+	}
+}
+
+func TestQuantizeReservedColors(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	cursor := color.RGBA{R: 255, B: 255, A: 255}
+	reserved := map[int]color.Color{0: white, 255: cursor}
+
+	// A colorful frame with more than 256 colors and one with 3 colors, both
+	// also holding the reserved colors themselves
+	many := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := 0; i < 32*32; i++ {
+		many.Set(i%32, i/32, color.RGBA{R: uint8(i * 7), G: uint8(i / 4), B: uint8(255 - i%256), A: 255})
+	}
+	few := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		few.Set(i%4, i/4, []color.Color{white, cursor, color.RGBA{G: 200, A: 255}}[i%3])
+	}
+	many.Set(0, 0, white)
+	many.Set(1, 0, cursor)
+
+	for name, frame := range map[string]image.Image{"many colors": many, "few colors": few} {
+		frames := []image.Image{frame}
+		result := Quantize(frames, CountColors(frames), Options{Reserved: reserved})
+		if len(result.Palette) != 256 {
+			t.Errorf("%s: palette of %d colors, want 256", name, len(result.Palette))
+			continue
+		}
+		if result.Palette[0] != white || result.Palette[255] != cursor {
+			t.Errorf("%s: palette[0] = %v, palette[255] = %v, want the reserved colors", name, result.Palette[0], result.Palette[255])
+		}
+		for _, index := range result.Frames[0].Pix {
+			if index == 0 || index == 255 {
+				t.Errorf("%s: a pixel uses the reserved index %d", name, index)
+				break
+			}
+		}
+		// Pixels of the reserved colors use a copy of them on another slot
+		if name == "few colors" {
+			for i := 0; i < 16; i++ {
+				want := color.RGBAModel.Convert(few.At(i%4, i/4))
+				if got := color.RGBAModel.Convert(result.Frames[0].At(i%4, i/4)); got != want {
+					t.Errorf("%s: pixel %d = %v, want %v", name, i, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOptionsCheckReserved(t *testing.T) {
+	full := make([]color.Color, 256)
+	for i := range full {
+		full[i] = color.RGBA{R: uint8(i), A: 255}
+	}
+	allReserved := map[int]color.Color{}
+	for i := 0; i < 256; i++ {
+		allReserved[i] = color.Black
+	}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"full palette", Options{Palette: full, Reserved: map[int]color.Color{3: color.White}}, "256 palette colors and 1 reserved colors"},
+		{"all slots reserved", Options{Reserved: allReserved}, "no palette slot"},
+		{"index out of range", Options{Reserved: map[int]color.Color{256: color.White}}, "index 256"},
+	} {
+		err := test.opts.Check()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+
+	if err := (Options{Palette: full[:255], Reserved: map[int]color.Color{0: color.White}}).Check(); err != nil {
+		t.Errorf("255 colors and 1 reserved: %v", err)
+	}
+}
+
+func TestStatsQuantizationErrorUsesFrames(t *testing.T) {
+	// Dithering may pick a palette color that is not the nearest one, the error
+	// must be measured against the index actually stored in the frame.
+	source := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	source.Set(0, 0, color.RGBA{R: 4, A: 255})
+	source.Set(1, 0, color.RGBA{R: 20, A: 255})
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 20, A: 255}}
+	frame := image.NewPaletted(source.Bounds(), palette)
+	frame.SetColorIndex(0, 0, 1)
+	frame.SetColorIndex(1, 0, 1)
+
+	colorCount := CountColors([]image.Image{source})
+	stats := NewStats(colorCount, []image.Image{source}, []*image.Paletted{frame}, palette)
+	if want := 16 * 16; stats.QuantizationError != want {
+		t.Errorf("quantization error = %d, want %d", stats.QuantizationError, want)
+	}
+	if stats := NewStats(colorCount, nil, []*image.Paletted{frame}, palette); stats.QuantizationError != 0 {
+		t.Errorf("quantization error without sources = %d, want 0", stats.QuantizationError)
+	}
+}
+
+func TestStatsQuantizationErrorCroppedSource(t *testing.T) {
+	// A cropped source keeps its offset, the quantized frame starts at 0,0
+	full := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range full.Pix {
+		full.Pix[i] = 0xff
+	}
+	source := full.SubImage(image.Rect(2, 2, 4, 4))
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255}}
+	frame := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+	for i := range frame.Pix {
+		frame.Pix[i] = 1
+	}
+
+	stats := NewStats(nil, []image.Image{source}, []*image.Paletted{frame}, palette)
+	if stats.QuantizationError != 0 {
+		t.Errorf("quantization error = %d, want 0", stats.QuantizationError)
+	}
+}
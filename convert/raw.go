@@ -0,0 +1,61 @@
+package convert
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// The raw export has no header and no delta encoding, so firmware can use it
+// without parsing anything:
+//
+// The palette file holds 256 entries of 3 bytes each, R, G and B, 768 bytes
+// in total. Entries beyond the palette of the frames are black.
+//
+// The index file holds the palette index of every pixel as one byte, row by
+// row from the top left, frame after frame: width×height×frameCount bytes.
+// The pixel (x,y) of frame n is at offset (n*height+y)*width+x. Width, height
+// and the delays are not stored.
+
+// EncodeRaw writes the palette of the frames to pal and their indices to idx
+// as described above. All frames must have the same size and palette; frames
+// with a palette of their own, as in a SAG file with local palettes, are
+// rejected because the palette file has room for only one.
+func EncodeRaw(pal, idx io.Writer, frames []*image.Paletted) error {
+	if len(frames) == 0 {
+		return errors.New("raw: no frames")
+	}
+	for n, frame := range frames[1:] {
+		if !samePalette(frame.Palette, frames[0].Palette) {
+			return fmt.Errorf("raw: frame %d has a palette of its own, the raw export stores only one", n+1)
+		}
+	}
+
+	var palette [768]byte
+	for i, c := range frames[0].Palette {
+		if i == 256 {
+			break
+		}
+		rgb := storedColor(c)
+		copy(palette[i*3:], rgb[:])
+	}
+	if _, err := pal.Write(palette[:]); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(idx)
+	size := frames[0].Bounds().Size()
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		if bounds.Size() != size {
+			return errors.New("raw: frames differ in size")
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			start := frame.PixOffset(bounds.Min.X, y)
+			bw.Write(frame.Pix[start : start+size.X])
+		}
+	}
+	return bw.Flush()
+}
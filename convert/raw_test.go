@@ -0,0 +1,63 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"../sag"
+)
+
+func TestEncodeRaw(t *testing.T) {
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 10, G: 20, B: 30, A: 255}}
+	frames := make([]*image.Paletted, 3)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 75, 4), palette)
+		frames[i].SetColorIndex(i, 2, 1)
+	}
+
+	// Go through a SAG file, as sag2raw does
+	var sagData bytes.Buffer
+	if err := sag.Encode(&sagData, frames, []int{100, 100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := sag.Decode(&sagData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pal, idx bytes.Buffer
+	if err := EncodeRaw(&pal, &idx, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if pal.Len() != 768 {
+		t.Errorf("palette file has %d bytes, want 768", pal.Len())
+	}
+	if want := []byte{0, 0, 0, 10, 20, 30}; !bytes.Equal(pal.Bytes()[:6], want) {
+		t.Errorf("palette starts with %v, want %v", pal.Bytes()[:6], want)
+	}
+	if want := 75 * 4 * 3; idx.Len() != want {
+		t.Fatalf("index file has %d bytes, want width×height×frameCount = %d", idx.Len(), want)
+	}
+	for n := range frames {
+		if got := idx.Bytes()[(n*4+2)*75+n]; got != 1 {
+			t.Errorf("frame %d pixel (%d,2) = %d, want 1", n, n, got)
+		}
+	}
+}
+
+func TestEncodeRawRejectsLocalPalettes(t *testing.T) {
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}}),
+		image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.RGBA{A: 255}, color.RGBA{B: 255, A: 255}}),
+	}
+
+	var pal, idx bytes.Buffer
+	if err := EncodeRaw(&pal, &idx, frames); err == nil {
+		t.Error("frames with different palettes encoded, want an error")
+	}
+	if pal.Len() != 0 || idx.Len() != 0 {
+		t.Errorf("wrote %d palette and %d index bytes before failing, want none", pal.Len(), idx.Len())
+	}
+}
@@ -0,0 +1,150 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Scalers maps the names accepted by the resize options to their scaler.
+// "nearest" keeps pixel art crisp, "bilinear" and "catmull" suit photos and
+// "area" keeps fine detail when shrinking detailed sources for the panel.
+var Scalers = map[string]draw.Scaler{
+	"nearest":  draw.NearestNeighbor,
+	"bilinear": draw.BiLinear,
+	"catmull":  draw.CatmullRom,
+	"area":     Area,
+}
+
+// Area is a box filter: every destination pixel is the average of the source
+// pixels it covers, weighted by the covered part of each. Downscaling with it
+// keeps the detail nearest neighbor drops without the ringing of the kernels.
+var Area draw.Scaler = areaScaler{}
+
+type areaScaler struct{}
+
+func (areaScaler) Scale(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, _ *draw.Options) {
+	if dr.Empty() || sr.Empty() {
+		return
+	}
+	scaleX := float64(sr.Dx()) / float64(dr.Dx())
+	scaleY := float64(sr.Dy()) / float64(dr.Dy())
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		y0 := float64(y-dr.Min.Y) * scaleY
+		y1 := y0 + scaleY
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			x0 := float64(x-dr.Min.X) * scaleX
+			x1 := x0 + scaleX
+
+			var sum [4]float64
+			var total float64
+			for py := int(y0); float64(py) < y1 && py < sr.Dy(); py++ {
+				wy := math.Min(y1, float64(py+1)) - math.Max(y0, float64(py))
+				for px := int(x0); float64(px) < x1 && px < sr.Dx(); px++ {
+					w := wy * (math.Min(x1, float64(px+1)) - math.Max(x0, float64(px)))
+					r, g, b, a := src.At(sr.Min.X+px, sr.Min.Y+py).RGBA()
+					sum[0] += w * float64(r)
+					sum[1] += w * float64(g)
+					sum[2] += w * float64(b)
+					sum[3] += w * float64(a)
+					total += w
+				}
+			}
+			for i := range sum {
+				sum[i] /= total
+			}
+
+			// Over keeps the part of the destination the average does not cover
+			if op == draw.Over {
+				r, g, b, a := dst.At(x, y).RGBA()
+				k := 1 - sum[3]/0xffff
+				sum[0] += k * float64(r)
+				sum[1] += k * float64(g)
+				sum[2] += k * float64(b)
+				sum[3] += k * float64(a)
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(sum[0] + 0.5),
+				G: uint16(sum[1] + 0.5),
+				B: uint16(sum[2] + 0.5),
+				A: uint16(sum[3] + 0.5),
+			})
+		}
+	}
+}
+
+// ScalerByName returns the scaler registered under name in Scalers.
+func ScalerByName(name string) (draw.Scaler, error) {
+	scaler, ok := Scalers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown resize filter %q", name)
+	}
+	return scaler, nil
+}
+
+// ParseSizes parses a comma-separated list of sizes given as "WxH", e.g.
+// "75x75,150x150".
+func ParseSizes(s string) ([]image.Point, error) {
+	var sizes []image.Point
+	for _, part := range strings.Split(s, ",") {
+		w, h, ok := strings.Cut(strings.TrimSpace(part), "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("invalid size %q, want WxH with positive width and height", part)
+		}
+		sizes = append(sizes, image.Pt(width, height))
+	}
+	return sizes, nil
+}
+
+// SizedFilename returns the name of the SAG file of one size of a
+// multi-size conversion: prefix_WxH.sag.
+func SizedFilename(prefix string, size image.Point) string {
+	return fmt.Sprintf("%s_%dx%d.sag", prefix, size.X, size.Y)
+}
+
+// Resize scales every frame to width×height pixels. If one of the dimensions
+// is 0, it is derived from the other one so the aspect ratio is kept.
+// With keepAspect set, the frames are scaled to fit into width×height and
+// centered on a black background (letterboxing) instead of being stretched.
+func Resize(frames []image.Image, width, height int, scaler draw.Scaler, keepAspect bool) []image.Image {
+	resized := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		src := frame.Bounds()
+		w, h := width, height
+		if w == 0 {
+			w = (src.Dx()*h + src.Dy()/2) / src.Dy()
+		}
+		if h == 0 {
+			h = (src.Dy()*w + src.Dx()/2) / src.Dx()
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		target := dst.Bounds()
+		if keepAspect {
+			draw.Draw(dst, target, image.NewUniform(color.Black), image.Point{}, draw.Src)
+			target = fitRect(src, w, h)
+		}
+		scaler.Scale(dst, target, frame, src, draw.Src, nil)
+		resized[i] = dst
+	}
+	return resized
+}
+
+// fitRect returns the largest rectangle with the aspect ratio of src that fits
+// into width×height, centered within it.
+func fitRect(src image.Rectangle, width, height int) image.Rectangle {
+	w, h := width, (src.Dy()*width+src.Dx()/2)/src.Dx()
+	if h > height {
+		w, h = (src.Dx()*height+src.Dy()/2)/src.Dy(), height
+	}
+	x, y := (width-w)/2, (height-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
@@ -0,0 +1,176 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/draw"
+
+	"../sag"
+)
+
+// solidFrame returns a w×h paletted frame filled with c.
+func solidFrame(w, h int, c color.Color) *image.Paletted {
+	return image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{c})
+}
+
+func TestResizeHeaderSize(t *testing.T) {
+	frames := Images([]*image.Paletted{
+		solidFrame(40, 30, color.RGBA{R: 255, A: 255}),
+		solidFrame(40, 30, color.RGBA{B: 255, A: 255}),
+	})
+
+	for _, name := range []string{"nearest", "bilinear", "catmull"} {
+		scaler, err := ScalerByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resized := Resize(frames, 16, 12, scaler, false)
+		paletted, palette := ReduceColors(resized, CountColors(resized), Options{})
+
+		var buf bytes.Buffer
+		if err := sag.Encode(&buf, paletted, []int{10, 10}, palette); err != nil {
+			t.Fatal(err)
+		}
+
+		dec, err := sag.NewDecoder(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := dec.Header()
+		if header.Width != 16 || header.Height != 12 {
+			t.Errorf("%s: header size = %dx%d, want 16x12", name, header.Width, header.Height)
+		}
+	}
+}
+
+func TestResizeDerivedDimension(t *testing.T) {
+	frames := Images([]*image.Paletted{solidFrame(40, 20, color.White)})
+
+	resized := Resize(frames, 10, 0, draw.NearestNeighbor, false)
+	if got := resized[0].Bounds().Size(); got != image.Pt(10, 5) {
+		t.Errorf("size = %v, want (10,5)", got)
+	}
+}
+
+func TestResizeKeepAspectLetterbox(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	frames := Images([]*image.Paletted{solidFrame(20, 10, red)})
+
+	resized := Resize(frames, 10, 10, draw.NearestNeighbor, true)
+	frame := resized[0]
+	if got := frame.Bounds().Size(); got != image.Pt(10, 10) {
+		t.Fatalf("size = %v, want (10,10)", got)
+	}
+
+	// The 2:1 source fills rows 2..6 and leaves black bars above and below
+	for y := 0; y < 10; y++ {
+		want := color.Color(color.Black)
+		if y >= 2 && y < 7 {
+			want = red
+		}
+		r1, g1, b1, _ := frame.At(5, y).RGBA()
+		r2, g2, b2, _ := want.RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 {
+			t.Errorf("pixel (5,%d) = %v, want %v", y, frame.At(5, y), want)
+		}
+	}
+}
+
+func TestResizeAreaCheckerboard(t *testing.T) {
+	board := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				board.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	// Nearest neighbor picks one of every 2×2 pixels, losing the pattern to a solid color
+	nearest := Resize([]image.Image{board}, 4, 4, draw.NearestNeighbor, false)[0]
+	area := Resize([]image.Image{board}, 4, 4, Area, false)[0]
+	first := color.GrayModel.Convert(nearest.At(0, 0)).(color.Gray).Y
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if got := color.GrayModel.Convert(nearest.At(x, y)).(color.Gray).Y; got != first || got != 0 && got != 255 {
+				t.Errorf("nearest (%d,%d) = %d, want a solid %d", x, y, got, first)
+			}
+			// The average of 2 white and 2 black pixels
+			if got := color.GrayModel.Convert(area.At(x, y)).(color.Gray).Y; got < 127 || got > 128 {
+				t.Errorf("area (%d,%d) = %d, want 127 or 128", x, y, got)
+			}
+		}
+	}
+}
+
+func TestResizeAreaFractional(t *testing.T) {
+	// Shrinking 3 columns into 2 weights the middle one half for each
+	src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{G: 255, A: 255})
+	src.Set(2, 0, color.RGBA{B: 255, A: 255})
+
+	dst := Resize([]image.Image{src}, 2, 1, Area, false)[0]
+	for x, want := range []color.RGBA{{R: 170, G: 85, A: 255}, {G: 85, B: 170, A: 255}} {
+		if got := color.RGBAModel.Convert(dst.At(x, 0)); got != want {
+			t.Errorf("pixel %d = %v, want %v", x, got, want)
+		}
+	}
+	if scaler, err := ScalerByName("area"); err != nil || scaler != Area {
+		t.Errorf("ScalerByName(area) = %v, %v", scaler, err)
+	}
+}
+
+func TestResizeMultipleSizes(t *testing.T) {
+	frames := Images([]*image.Paletted{
+		solidFrame(150, 150, color.RGBA{R: 255, A: 255}),
+		solidFrame(150, 150, color.RGBA{G: 255, A: 255}),
+	})
+	sizes, err := ParseSizes("75x75, 150x150")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frames are decoded once and every size is resized from them
+	prefix := filepath.Join(t.TempDir(), "anim")
+	for _, size := range sizes {
+		file, err := os.Create(SizedFilename(prefix, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resized := Resize(frames, size.X, size.Y, Area, false)
+		if err := EncodeImages(file, resized, []int{100, 100}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	for _, want := range []string{"anim_75x75.sag", "anim_150x150.sag"} {
+		file, err := os.Open(filepath.Join(filepath.Dir(prefix), want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := sag.NewDecoder(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", want, err)
+		}
+		header := dec.Header()
+		if got := fmt.Sprintf("anim_%dx%d.sag", header.Width, header.Height); got != want {
+			t.Errorf("%s: header size %dx%d", want, header.Width, header.Height)
+		}
+	}
+
+	for _, invalid := range []string{"75", "75x", "0x10", "75x75,x3"} {
+		if _, err := ParseSizes(invalid); err == nil {
+			t.Errorf("ParseSizes(%q) accepted it", invalid)
+		}
+	}
+}
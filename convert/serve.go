@@ -0,0 +1,38 @@
+package convert
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+
+	"../sag"
+)
+
+// PreviewHandler serves the SAG file as an animated GIF. The file is read
+// again for every request, so a new conversion shows up on reload.
+func PreviewHandler(filename string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, err := os.Open(filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+
+		frames, delays, err := sag.Decode(file)
+		if err != nil {
+			http.Error(w, "decoding "+filename+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Encode into a buffer first, so errors can still be reported
+		var buf bytes.Buffer
+		if err := EncodeGIF(&buf, frames, delays); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store")
+		buf.WriteTo(w)
+	})
+}
@@ -0,0 +1,58 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../sag"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}}
+	frames := make([]*image.Paletted, 3)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frames[i].SetColorIndex(i, i, 1)
+	}
+
+	filename := filepath.Join(t.TempDir(), "preview.sag")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sag.Encode(file, frames, []int{120, 120, 120}, palette); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	rec := httptest.NewRecorder()
+	PreviewHandler(filename).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/gif" {
+		t.Errorf("Content-Type = %q, want image/gif", ct)
+	}
+	anim, err := gif.DecodeAll(rec.Body)
+	if err != nil {
+		t.Fatalf("response is no valid GIF: %v", err)
+	}
+	if len(anim.Image) != 3 || anim.Delay[0] != 12 {
+		t.Errorf("got %d frames with delay %d, want 3 with 12", len(anim.Image), anim.Delay[0])
+	}
+}
+
+func TestPreviewHandlerMissingFile(t *testing.T) {
+	rec := httptest.NewRecorder()
+	PreviewHandler(filepath.Join(t.TempDir(), "missing.sag")).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// SheetFrame is the place of a frame on a sprite sheet and its delay, as
+// written to the JSON description of the sheet.
+type SheetFrame struct {
+	X       int `json:"x"`
+	Y       int `json:"y"`
+	W       int `json:"w"`
+	H       int `json:"h"`
+	DelayMs int `json:"delayMs"`
+}
+
+// SpriteSheet draws the frames side by side into one image for web players,
+// cols frames per row, or all of them in one row if cols <= 0. Unlike
+// Montage it has no padding or labels, so every frame sits at a multiple of
+// the frame size. delays are in milliseconds.
+func SpriteSheet(frames []*image.Paletted, delays []int, cols int) (*image.RGBA, []SheetFrame, error) {
+	if len(frames) == 0 {
+		return nil, nil, errors.New("sprite sheet: no frames")
+	}
+	if cols <= 0 || cols > len(frames) {
+		cols = len(frames)
+	}
+	rows := (len(frames) + cols - 1) / cols
+	size := frames[0].Bounds().Size()
+
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*size.X, rows*size.Y))
+	rects := make([]SheetFrame, len(frames))
+	for i, frame := range frames {
+		if frame.Bounds().Size() != size {
+			return nil, nil, errors.New("sprite sheet: the frames differ in size")
+		}
+		x, y := i%cols*size.X, i/cols*size.Y
+		draw.Draw(sheet, image.Rect(x, y, x+size.X, y+size.Y), frame, frame.Bounds().Min, draw.Src)
+		rects[i] = SheetFrame{X: x, Y: y, W: size.X, H: size.Y, DelayMs: delays[i]}
+	}
+	return sheet, rects, nil
+}
+
+// WriteSheetJSON writes the frames of a sprite sheet as a JSON array of
+// {x, y, w, h, delayMs} objects.
+func WriteSheetJSON(w io.Writer, frames []SheetFrame) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(frames)
+}
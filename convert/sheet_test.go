@@ -0,0 +1,64 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+
+	"../sag"
+)
+
+func TestSpriteSheet(t *testing.T) {
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}}
+	frames := make([]*image.Paletted, 5)
+	delays := make([]int, len(frames))
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 6, 4), palette)
+		frames[i].SetColorIndex(i, 0, 1)
+		delays[i] = 40 * (i + 1)
+	}
+	var buf bytes.Buffer
+	if err := sag.EncodeWithOptions(&buf, frames, delays, palette, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	frames, delays, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		cols int
+		size image.Point
+	}{{0, image.Pt(30, 4)}, {2, image.Pt(12, 12)}, {5, image.Pt(30, 4)}} {
+		sheet, rects, err := SpriteSheet(frames, delays, tc.cols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sheet.Bounds().Size(); got != tc.size {
+			t.Errorf("%d columns: sheet size %v, want %v", tc.cols, got, tc.size)
+		}
+
+		var out bytes.Buffer
+		if err := WriteSheetJSON(&out, rects); err != nil {
+			t.Fatal(err)
+		}
+		var decoded []SheetFrame
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != len(frames) {
+			t.Fatalf("%d columns: %d frames in the JSON, want %d", tc.cols, len(decoded), len(frames))
+		}
+		for i, r := range decoded {
+			if r.W != 6 || r.H != 4 || r.DelayMs != delays[i] {
+				t.Errorf("%d columns: frame %d = %+v", tc.cols, i, r)
+			}
+			// The marked pixel of every frame is found at its rectangle
+			if got := sheet.RGBAAt(r.X+i, r.Y); got != (color.RGBA{R: 255, A: 255}) {
+				t.Errorf("%d columns: frame %d pixel at (%d,%d) = %v", tc.cols, i, r.X+i, r.Y, got)
+			}
+		}
+	}
+}
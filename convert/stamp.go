@@ -0,0 +1,78 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// StampSuffix is appended to the output file name to get the file that holds
+// the stamp of the conversion that wrote it.
+const StampSuffix = ".stamp"
+
+// SourceStamp returns a hash over the content of the source files, e.g. the
+// input, a palette file and the converter itself, and the conversion settings.
+// An output written with the same stamp is up to date as long as its content
+// is unchanged.
+func SourceStamp(sourceFilenames []string, settings []string) (string, error) {
+	h := sha256.New()
+	for _, filename := range sourceFilenames {
+		// Every source adds its own hash, so the contents cannot run into each other
+		sum, err := fileHash(filename)
+		if err != nil {
+			return "", err
+		}
+		h.Write(sum)
+	}
+	// The settings are separated from the content and each other by a zero byte
+	io.WriteString(h, "\x00"+strings.Join(settings, "\x00"))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileHash returns the SHA-256 hash of the content of a file.
+func fileHash(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// UpToDate reports whether all output files were written by a conversion with
+// the given stamp and still have the content it wrote, so they do not need to
+// be converted again.
+func UpToDate(stamp string, outputFilenames ...string) bool {
+	for _, filename := range outputFilenames {
+		stored, err := os.ReadFile(filename + StampSuffix)
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(string(stored))
+		if len(fields) != 2 || fields[0] != stamp {
+			return false
+		}
+		sum, err := fileHash(filename)
+		if err != nil || hex.EncodeToString(sum) != fields[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteStamp records the stamp of the conversion that wrote the output file,
+// together with the hash of the written content.
+func WriteStamp(outputFilename, stamp string) error {
+	sum, err := fileHash(outputFilename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFilename+StampSuffix, []byte(stamp+" "+hex.EncodeToString(sum)+"\n"), 0o644)
+}
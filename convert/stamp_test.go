@@ -0,0 +1,110 @@
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStampSkipsUnchangedSources(t *testing.T) {
+	dir := t.TempDir()
+	sources := []string{"a.gif", "b.gif", "c.gif"}
+	for i, name := range sources {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{byte(i), 1, 2, 3}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	palette := filepath.Join(dir, "palette.gpl")
+	if err := os.WriteFile(palette, []byte("GIMP Palette\n0 0 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settings := []string{"dither=ordered", "format=gif", "palette=" + palette}
+
+	// run converts every source whose output is not up to date and returns the skipped ones
+	run := func(settings []string) []string {
+		var skipped []string
+		for _, name := range sources {
+			source := filepath.Join(dir, name)
+			output := source + ".sag"
+			stamp, err := SourceStamp([]string{source, palette}, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if UpToDate(stamp, output) {
+				skipped = append(skipped, name)
+				continue
+			}
+			if err := os.WriteFile(output, []byte("SAG"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteStamp(output, stamp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return skipped
+	}
+
+	if skipped := run(settings); len(skipped) != 0 {
+		t.Errorf("first run skipped %v, want none", skipped)
+	}
+	if skipped := run(settings); len(skipped) != len(sources) {
+		t.Errorf("second run skipped %v, want all of %v", skipped, sources)
+	}
+
+	// A changed source, other settings or a deleted output are converted again
+	if err := os.WriteFile(filepath.Join(dir, "b.gif"), []byte{9}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.gif.sag")); err != nil {
+		t.Fatal(err)
+	}
+	if skipped := run(settings); len(skipped) != 1 || skipped[0] != "a.gif" {
+		t.Errorf("after changing b and deleting the output of c: skipped %v, want [a.gif]", skipped)
+	}
+	if skipped := run([]string{"dither=none", "format=gif", "palette=" + palette}); len(skipped) != 0 {
+		t.Errorf("with other settings: skipped %v, want none", skipped)
+	}
+
+	// A changed palette file under the same name converts all sources again
+	if err := os.WriteFile(palette, []byte("GIMP Palette\n255 255 255\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if skipped := run([]string{"dither=none", "format=gif", "palette=" + palette}); len(skipped) != 0 {
+		t.Errorf("after changing the palette file: skipped %v, want none", skipped)
+	}
+}
+
+func TestUpToDateAllOutputs(t *testing.T) {
+	dir := t.TempDir()
+	output, preview := filepath.Join(dir, "out.sag"), filepath.Join(dir, "palette.png")
+	for _, name := range []string{output, preview} {
+		if err := os.WriteFile(name, []byte{1}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteStamp(name, "stamp"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !UpToDate("stamp", output, preview) {
+		t.Error("outputs with the stamp are not up to date")
+	}
+
+	if UpToDate("other", output, preview) {
+		t.Error("up to date with another stamp")
+	}
+
+	// An output changed after the conversion is converted again
+	if err := os.WriteFile(output, []byte{2}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if UpToDate("stamp", output) {
+		t.Error("up to date with a changed output")
+	}
+
+	if err := os.Remove(preview); err != nil {
+		t.Fatal(err)
+	}
+	if UpToDate("stamp", preview) {
+		t.Error("up to date with a missing output")
+	}
+}
@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"../imgcolor"
+)
+
+// Stats summarizes a conversion for the verbose output.
+type Stats struct {
+	SourceColors      int   // Number of unique colors in the source frames
+	PaletteColors     int   // Number of colors in the reduced palette
+	QuantizationError int   // Total squared RGB error of the quantized frames against their sources
+	Pixels            int   // Number of pixels in all frames
+	IdenticalPixels   int   // Pixels unchanged from the previous frame
+	FileSize          int64 // Size of the written SAG file in bytes
+	RawSize           int64 // Size of the frames as uncompressed 24-bit RGB
+	UncompressedSize  int64 // Size of the SAG file without DEFLATE, 0 if it is not compressed
+
+	// FrameSSIM holds the structural similarity of every quantized frame to
+	// its source frame. It is only filled by the caller, see FrameSSIM.
+	FrameSSIM []float64
+}
+
+// FrameSSIM returns the SSIM of every quantized frame against its source frame.
+func FrameSSIM(sources []image.Image, frames []*image.Paletted) []float64 {
+	ssim := make([]float64, len(frames))
+	for i, frame := range frames {
+		ssim[i] = imgcolor.SSIM(sources[i], frame)
+	}
+	return ssim
+}
+
+// NewStats computes the statistics of a conversion from the color count of the
+// source frames, the source frames themselves, the quantized frames and their
+// palette. The quantization error compares every pixel of the quantized frames
+// with its source pixel, so it includes dithering, reserved colors and local
+// palettes; it is 0 if sources is nil. sources[i] must be the source of
+// frames[i], so the statistics are taken before frames are added, merged or
+// dropped (-pingpong, -dedupe, -fps). FileSize and UncompressedSize are left
+// for the caller, since they depend on the encoding.
+func NewStats(colorCount map[color.Color]int, sources []image.Image, frames []*image.Paletted, palette []color.Color) Stats {
+	stats := Stats{
+		SourceColors:  len(colorCount),
+		PaletteColors: len(palette),
+	}
+
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		stats.Pixels += bounds.Dx() * bounds.Dy()
+		if sources != nil {
+			stats.QuantizationError += frameError(sources[i], frame)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := frames[i-1]
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if frame.ColorIndexAt(x, y) == prev.ColorIndexAt(x, y) {
+					stats.IdenticalPixels++
+				}
+			}
+		}
+	}
+	stats.RawSize = int64(stats.Pixels) * 3
+
+	return stats
+}
+
+// frameError returns the total squared RGB distance between every pixel of the
+// quantized frame and the same pixel of its source frame. The frames are
+// compared from their top left corner, a cropped source keeps its offset.
+func frameError(source image.Image, frame *image.Paletted) int {
+	total := 0
+	bounds := frame.Bounds()
+	offset := source.Bounds().Min.Sub(bounds.Min)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			total += imgcolor.RGB.Distance(source.At(x+offset.X, y+offset.Y), frame.Palette[frame.ColorIndexAt(x, y)])
+		}
+	}
+	return total
+}
+
+// Print writes the statistics in a human readable form to w.
+func (s Stats) Print(w io.Writer) {
+	fmt.Fprintf(w, "colors:        %d unique, reduced to %d\n", s.SourceColors, s.PaletteColors)
+	fmt.Fprintf(w, "quant. error:  %d total, %.2f per pixel\n", s.QuantizationError, ratio(int64(s.QuantizationError), int64(s.Pixels)))
+	fmt.Fprintf(w, "delta:         %d of %d pixels unchanged from the previous frame (%.1f%%)\n", s.IdenticalPixels, s.Pixels, 100*ratio(int64(s.IdenticalPixels), int64(s.Pixels)))
+	fmt.Fprintf(w, "file size:     %d bytes, raw RGB %d bytes (%.1f%%)\n", s.FileSize, s.RawSize, 100*ratio(s.FileSize, s.RawSize))
+	if s.UncompressedSize > 0 {
+		fmt.Fprintf(w, "deflate:       %d bytes uncompressed, compressed to %.1f%%\n", s.UncompressedSize, 100*ratio(s.FileSize, s.UncompressedSize))
+	}
+	if len(s.FrameSSIM) > 0 {
+		sum, worst := 0.0, 0
+		for i, v := range s.FrameSSIM {
+			sum += v
+			if v < s.FrameSSIM[worst] {
+				worst = i
+			}
+		}
+		fmt.Fprintf(w, "ssim:          %.4f mean, %.4f lowest at frame %d\n", sum/float64(len(s.FrameSSIM)), s.FrameSSIM[worst], worst)
+		for i, v := range s.FrameSSIM {
+			fmt.Fprintf(w, "  frame %4d:  %.4f\n", i, v)
+		}
+	}
+}
+
+// ratio returns a/b, or 0 if b is 0.
+func ratio(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
@@ -0,0 +1,133 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"../imgcolor"
+	"../sag"
+)
+
+// FrameFunc returns source frame i of an animation. It may decode or generate
+// the frame on demand, so the frames never have to be in memory all at once.
+type FrameFunc func(i int) (image.Image, error)
+
+// EncodeImages quantizes the frames, e.g. *image.RGBA frames drawn by a
+// generator, to one shared palette with the options and writes them as a SAG
+// file to w. All frames must have the same size; delays are in milliseconds.
+// It is the in-memory counterpart of EncodeStream.
+func EncodeImages(w io.Writer, frames []image.Image, delays []int, opts Options) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to encode")
+	}
+	if len(delays) != len(frames) {
+		return fmt.Errorf("%d delays for %d frames", len(delays), len(frames))
+	}
+	if err := opts.Check(); err != nil {
+		return err
+	}
+	first := frames[0].Bounds().Size()
+	for i, frame := range frames {
+		if size := frame.Bounds().Size(); size != first {
+			return fmt.Errorf("frame %d is %dx%d, frame 0 is %dx%d", i, size.X, size.Y, first.X, first.Y)
+		}
+	}
+
+	paletted, palette := ReduceColors(frames, CountColors(frames), opts)
+	return sag.Encode(w, paletted, delays, palette)
+}
+
+// EncodeStream quantizes and writes an animation of count frames one frame at
+// a time. The palette is built in a first pass over all frames (unless
+// opts.Palette is set), the second pass maps and writes every frame right away.
+// Only the current and the previous quantized frame are kept in memory.
+// frame is called once per pass for every frame and must return frames of the
+// same size; delays are in milliseconds, one per frame. sagOpts may be nil, as
+// with sag.EncodeWithOptions, but row skipping is decided frame by frame.
+// opts.Progress is called after every written frame.
+func EncodeStream(w io.Writer, count int, frame FrameFunc, delays []int, opts Options, sagOpts *sag.Options) error {
+	if count <= 0 {
+		return errors.New("no frames to encode")
+	}
+	if len(delays) != count {
+		return fmt.Errorf("%d delays for %d frames", len(delays), count)
+	}
+	if err := opts.Check(); err != nil {
+		return err
+	}
+
+	palette := opts.Palette
+	if palette == nil {
+		// Frames that already share a palette keep it, as with Quantize
+		colorCount := make(map[color.Color]int)
+		var shared color.Palette
+		sharing := true
+		for i := 0; i < count; i++ {
+			src, err := frame(i)
+			if err != nil {
+				return err
+			}
+			imgcolor.CountColorsInImage(src, colorCount)
+			if p, ok := src.(*image.Paletted); sharing && (!ok || len(p.Palette) > 256 || i > 0 && !samePalette(p.Palette, shared)) {
+				sharing = false
+			} else if i == 0 {
+				shared = p.Palette
+			}
+		}
+		if sharing && opts.keepsSharedPalette() {
+			palette = dedupePalette(shared)
+		}
+		if palette == nil || len(palette) > opts.maxColors() {
+			palette = BuildPalette(colorCount, opts)
+		}
+	}
+
+	// The pixels are mapped onto the palette without the reserved colors and
+	// moved to their slots in the full palette afterwards
+	mapper := newPaletteMapper(palette, opts.Metric)
+	var slots []uint8
+	if len(opts.Reserved) > 0 {
+		palette, slots = reservedPalette(palette, opts.Reserved)
+	}
+
+	var enc *sag.Encoder
+	var size image.Point
+	var prev *image.Paletted
+	var state ditherState
+	for i := 0; i < count; i++ {
+		src, err := frame(i)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			size = src.Bounds().Size()
+			if enc, err = sag.NewEncoderDelays(w, size.X, size.Y, delays, palette, sagOpts); err != nil {
+				return err
+			}
+		} else if s := src.Bounds().Size(); s != size {
+			return fmt.Errorf("frame %d is %dx%d, frame 0 is %dx%d", i, s.X, s.Y, size.X, size.Y)
+		}
+
+		quantized := quantizeFrame(src, mapper, prev, opts.Dither, opts.ditherStrength(), &state)
+		prev = quantized
+		if slots != nil {
+			// The encoder compares against the frame it wrote last, prev keeps the mapper's indices
+			moved := image.NewPaletted(quantized.Rect, palette)
+			for j, index := range quantized.Pix {
+				moved.Pix[j] = slots[index]
+			}
+			quantized = moved
+		}
+		if err := enc.WriteFrameDelay(quantized, delays[i]); err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(i+1, count)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+
+	"../sag"
+)
+
+// gradientFrame returns frame i of a synthetic animation with a moving
+// gradient of 64 colors.
+func gradientFrame(w, h, i int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8((x+i)%8) * 32, uint8(y%8) * 32, 0, 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeStreamMatchesEncode(t *testing.T) {
+	const count = 5
+	frames := make([]image.Image, count)
+	delays := make([]int, count)
+	for i := range frames {
+		frames[i] = gradientFrame(20, 10, i)
+		delays[i] = 50
+	}
+
+	var want bytes.Buffer
+	paletted, palette := ReduceColors(frames, CountColors(frames), Options{})
+	if err := sag.Encode(&want, paletted, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	frame := func(i int) (image.Image, error) { return frames[i], nil }
+	if err := EncodeStream(&got, count, frame, delays, Options{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("streamed output differs from Encode (%d vs %d bytes)", got.Len(), want.Len())
+	}
+}
+
+func TestEncodeStreamMatchesEncodeWithOptions(t *testing.T) {
+	// Paletted frames of a GIF keep their palette without the duplicate
+	// entry, reserved colors and per-frame delays are stored as by the
+	// in-memory path
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{R: 255, A: 255}}
+	gifFrames := make([]image.Image, 3)
+	for i := range gifFrames {
+		img := image.NewPaletted(image.Rect(0, 0, 6, 4), palette)
+		img.Pix[i] = uint8(1 + i)
+		gifFrames[i] = img
+	}
+	rgbaFrames := []image.Image{gradientFrame(20, 10, 0), gradientFrame(20, 10, 1), gradientFrame(20, 10, 2)}
+	delays := []int{40, 40, 70000}
+	sagOpts := &sag.Options{FrameDelays: true}
+
+	for _, test := range []struct {
+		name   string
+		frames []image.Image
+		opts   Options
+	}{
+		{"shared palette", gifFrames, Options{}},
+		{"reserved", rgbaFrames, Options{Reserved: map[int]color.Color{0: color.White, 5: color.Black}}},
+		{"temporal dither", rgbaFrames, Options{MaxColors: 8, Dither: DitherTemporal}},
+	} {
+		var want bytes.Buffer
+		paletted, palette := ReduceColors(test.frames, CountColors(test.frames), test.opts)
+		if err := sag.EncodeWithOptions(&want, paletted, delays, palette, sagOpts); err != nil {
+			t.Fatal(err)
+		}
+
+		var got bytes.Buffer
+		frame := func(i int) (image.Image, error) { return test.frames[i], nil }
+		if err := EncodeStream(&got, len(test.frames), frame, delays, test.opts, sagOpts); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%s: streamed output differs from EncodeWithOptions (%d vs %d bytes)", test.name, got.Len(), want.Len())
+		}
+	}
+}
+
+func TestEncodeStreamErrors(t *testing.T) {
+	frame := func(i int) (image.Image, error) { return image.NewRGBA(image.Rect(0, 0, 8, 6-i)), nil }
+	for _, test := range []struct {
+		name   string
+		count  int
+		delays []int
+		want   string
+	}{
+		{"no frames", 0, nil, "no frames"},
+		{"too few delays", 2, []int{100}, "1 delays for 2 frames"},
+		{"different sizes", 2, []int{100, 100}, "frame 1 is 8x5"},
+	} {
+		err := EncodeStream(ioutil.Discard, test.count, frame, test.delays, Options{}, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+
+	// A full fixed palette leaves no slot for a reserved color
+	full := make([]color.Color, 256)
+	for i := range full {
+		full[i] = color.RGBA{G: uint8(i), A: 255}
+	}
+	opts := Options{Palette: full, Reserved: map[int]color.Color{0: color.White}}
+	if err := EncodeStream(ioutil.Discard, 1, frame, []int{100}, opts, nil); err == nil || !strings.Contains(err.Error(), "exceed") {
+		t.Errorf("full palette with a reserved color: %v, want an error", err)
+	}
+}
+
+func TestEncodeImages(t *testing.T) {
+	// A red square moving one pixel to the right over a blue background
+	frames := make([]image.Image, 2)
+	for i := range frames {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(2+i, 2, 4+i, 4), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+		frames[i] = img
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeImages(&buf, frames, []int{80, 120}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, delays, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || delays[0] != 80 {
+		t.Fatalf("decoded %d frames with delays %v, want 2 starting with 80", len(decoded), delays)
+	}
+	for i, frame := range frames {
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 8; x++ {
+				want := color.RGBAModel.Convert(frame.At(x, y))
+				if got := color.RGBAModel.Convert(decoded[i].At(x, y)); got != want {
+					t.Fatalf("frame %d pixel (%d,%d) = %v, want %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeImagesSizeMismatch(t *testing.T) {
+	frames := []image.Image{image.NewRGBA(image.Rect(0, 0, 8, 6)), image.NewRGBA(image.Rect(0, 0, 8, 5))}
+	err := EncodeImages(ioutil.Discard, frames, []int{100, 100}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "frame 1 is 8x5") {
+		t.Errorf("encoding frames of different sizes: %v, want an error naming frame 1", err)
+	}
+}
+
+// peakHeap runs f while sampling the heap and reports the largest HeapAlloc seen.
+func peakHeap(b *testing.B, f func()) {
+	var peak uint64
+	done := make(chan struct{})
+	go func() {
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > peak {
+				peak = ms.HeapAlloc
+			}
+			select {
+			case <-done:
+				return
+			default:
+				runtime.Gosched()
+			}
+		}
+	}()
+	f()
+	close(done)
+	b.ReportMetric(float64(peak)/(1<<20), "peak-MiB")
+}
+
+const benchFrames, benchWidth, benchHeight = 200, 128, 64
+
+func BenchmarkEncodeInMemory(b *testing.B) {
+	delays := make([]int, benchFrames)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		runtime.GC()
+		peakHeap(b, func() {
+			frames := make([]image.Image, benchFrames)
+			for i := range frames {
+				frames[i] = gradientFrame(benchWidth, benchHeight, i)
+			}
+			paletted, palette := ReduceColors(frames, CountColors(frames), Options{})
+			sag.Encode(ioutil.Discard, paletted, delays, palette)
+		})
+	}
+}
+
+func BenchmarkEncodeStream(b *testing.B) {
+	delays := make([]int, benchFrames)
+	frame := func(i int) (image.Image, error) { return gradientFrame(benchWidth, benchHeight, i), nil }
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		runtime.GC()
+		peakHeap(b, func() {
+			EncodeStream(ioutil.Discard, benchFrames, frame, delays, Options{}, nil)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// x/image/tiff only encodes a single image, so multi-page TIFF files are
+// written here. Every frame becomes an uncompressed palette-color page.
+
+// TIFF tag and field type constants used by EncodeTIFF.
+const (
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+
+	tiffImageWidth                = 256
+	tiffImageLength               = 257
+	tiffBitsPerSample             = 258
+	tiffCompression               = 259
+	tiffPhotometricInterpretation = 262
+	tiffStripOffsets              = 273
+	tiffSamplesPerPixel           = 277
+	tiffRowsPerStrip              = 278
+	tiffStripByteCounts           = 279
+	tiffXResolution               = 282
+	tiffYResolution               = 283
+	tiffResolutionUnit            = 296
+	tiffColorMap                  = 320
+)
+
+// tiffEntry is a single IFD entry. Values that do not fit into the 4 byte value
+// field are written out of line and referenced by offset.
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    uint32
+}
+
+// EncodeTIFF writes the frames as a multi-page TIFF file with one palette-color
+// page per frame. TIFF has no notion of frame delays, so they are not stored.
+func EncodeTIFF(w io.Writer, frames []*image.Paletted) error {
+	if len(frames) == 0 {
+		return errors.New("tiff: no frames to encode")
+	}
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	offset := uint32(8)
+
+	// Header: little endian byte order, magic number, offset of the first IFD
+	bw.WriteString("II")
+	binary.Write(bw, le, uint16(42))
+	binary.Write(bw, le, offset)
+
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		width, height := bounds.Dx(), bounds.Dy()
+
+		// Every page is laid out as IFD, pixel data, color map and resolution
+		const entryCount = 13
+		ifdSize := uint32(2 + 12*entryCount + 4)
+		pixelOffset := offset + ifdSize
+		pixelSize := uint32(width * height)
+		colorMapOffset := pixelOffset + pixelSize + pixelSize%2 // Keep word alignment
+		resolutionOffset := colorMapOffset + 2*3*256
+		offset = resolutionOffset + 8
+
+		// Offset of the next IFD, 0 for the last page
+		nextIFD := uint32(0)
+		if i < len(frames)-1 {
+			nextIFD = offset
+		}
+
+		entries := [entryCount]tiffEntry{
+			{tiffImageWidth, tiffLong, 1, uint32(width)},
+			{tiffImageLength, tiffLong, 1, uint32(height)},
+			{tiffBitsPerSample, tiffShort, 1, 8},
+			{tiffCompression, tiffShort, 1, 1},
+			{tiffPhotometricInterpretation, tiffShort, 1, 3}, // Palette color
+			{tiffStripOffsets, tiffLong, 1, pixelOffset},
+			{tiffSamplesPerPixel, tiffShort, 1, 1},
+			{tiffRowsPerStrip, tiffLong, 1, uint32(height)},
+			{tiffStripByteCounts, tiffLong, 1, pixelSize},
+			{tiffXResolution, tiffRational, 1, resolutionOffset},
+			{tiffYResolution, tiffRational, 1, resolutionOffset},
+			{tiffResolutionUnit, tiffShort, 1, 2}, // Inch
+			{tiffColorMap, tiffShort, 3 * 256, colorMapOffset},
+		}
+
+		binary.Write(bw, le, uint16(entryCount))
+		for _, e := range entries {
+			binary.Write(bw, le, e.tag)
+			binary.Write(bw, le, e.typ)
+			binary.Write(bw, le, e.count)
+			if e.typ == tiffShort && e.count == 1 {
+				// SHORT values are left-aligned in the value field
+				binary.Write(bw, le, []uint16{uint16(e.value), 0})
+			} else {
+				binary.Write(bw, le, e.value)
+			}
+		}
+		binary.Write(bw, le, nextIFD)
+
+		// Pixel data as a single strip
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			bw.Write(frame.Pix[frame.PixOffset(bounds.Min.X, y):][:width])
+		}
+		if pixelSize%2 == 1 {
+			bw.WriteByte(0)
+		}
+
+		// Color map: all reds, then all greens, then all blues as 16 bit values
+		colorMap := make([]uint16, 3*256)
+		for j, c := range frame.Palette {
+			if j >= 256 {
+				break
+			}
+			r, g, b, _ := c.RGBA()
+			colorMap[j], colorMap[256+j], colorMap[512+j] = uint16(r), uint16(g), uint16(b)
+		}
+		binary.Write(bw, le, colorMap)
+
+		// Resolution of 72 dpi for both axes
+		binary.Write(bw, le, []uint32{72, 1})
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,106 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"../sag"
+)
+
+// readTIFFPages parses the palette-color pages written by EncodeTIFF.
+func readTIFFPages(t *testing.T, data []byte) []*image.Paletted {
+	t.Helper()
+	le := binary.LittleEndian
+	if string(data[0:2]) != "II" || le.Uint16(data[2:4]) != 42 {
+		t.Fatalf("invalid TIFF header % x", data[0:4])
+	}
+
+	var pages []*image.Paletted
+	for ifd := le.Uint32(data[4:8]); ifd != 0; {
+		if ifd%2 != 0 {
+			t.Fatalf("IFD offset %d is not word aligned", ifd)
+		}
+		n := int(le.Uint16(data[ifd:]))
+		values := make(map[uint16]uint32)
+		for i := 0; i < n; i++ {
+			e := data[int(ifd)+2+12*i:]
+			tag, typ, count := le.Uint16(e[0:2]), le.Uint16(e[2:4]), le.Uint32(e[4:8])
+			if typ == tiffShort && count == 1 {
+				values[tag] = uint32(le.Uint16(e[8:10]))
+			} else {
+				values[tag] = le.Uint32(e[8:12])
+			}
+		}
+
+		if values[tiffPhotometricInterpretation] != 3 || values[tiffBitsPerSample] != 8 {
+			t.Fatalf("page %d is not an 8 bit palette-color page", len(pages))
+		}
+		width, height := int(values[tiffImageWidth]), int(values[tiffImageLength])
+		colorMap := data[values[tiffColorMap]:]
+		palette := make(color.Palette, 256)
+		for j := range palette {
+			palette[j] = color.RGBA64{
+				R: le.Uint16(colorMap[2*j:]),
+				G: le.Uint16(colorMap[2*(256+j):]),
+				B: le.Uint16(colorMap[2*(512+j):]),
+				A: 0xffff,
+			}
+		}
+
+		page := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		copy(page.Pix, data[values[tiffStripOffsets]:][:values[tiffStripByteCounts]])
+		pages = append(pages, page)
+
+		ifd = le.Uint32(data[int(ifd)+2+12*n:])
+	}
+	return pages
+}
+
+func TestEncodeTIFFRoundTrip(t *testing.T) {
+	palette := []color.Color{
+		color.RGBA{A: 255},
+		color.RGBA{R: 255, G: 128, A: 255},
+		color.RGBA{B: 200, A: 255},
+	}
+	var source []*image.Paletted
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 5, 3), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8((j + i) % len(palette))
+		}
+		source = append(source, frame)
+	}
+
+	var sagData bytes.Buffer
+	if err := sag.Encode(&sagData, source, []int{100, 100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	frames, _, err := sag.Decode(&sagData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tiffData bytes.Buffer
+	if err := EncodeTIFF(&tiffData, frames); err != nil {
+		t.Fatal(err)
+	}
+	pages := readTIFFPages(t, tiffData.Bytes())
+
+	if len(pages) != len(source) {
+		t.Fatalf("got %d pages, want %d", len(pages), len(source))
+	}
+	for i, page := range pages {
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				r1, g1, b1, _ := page.At(x, y).RGBA()
+				r2, g2, b2, _ := source[i].At(x, y).RGBA()
+				if r1>>8 != r2>>8 || g1>>8 != g2>>8 || b1>>8 != b2>>8 {
+					t.Errorf("page %d pixel (%d,%d) = %v, want %v", i, x, y, page.At(x, y), source[i].At(x, y))
+				}
+			}
+		}
+	}
+}
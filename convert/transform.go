@@ -0,0 +1,295 @@
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseRect parses a rectangle given as "x,y,w,h".
+func ParseRect(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q, want x,y,w,h", s)
+	}
+
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid rectangle %q: %v", s, err)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q, width and height must be positive", s)
+	}
+
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// Crop crops every frame to rect, given relative to the top-left corner of the
+// first frame. The frames are sliced with SubImage, so no pixels are copied.
+func Crop(frames []image.Image, rect image.Rectangle) ([]image.Image, error) {
+	if len(frames) == 0 {
+		return frames, nil
+	}
+
+	bounds := frames[0].Bounds()
+	rect = rect.Add(bounds.Min)
+	if !rect.In(bounds) {
+		return nil, fmt.Errorf("crop rectangle %v lies outside the image bounds %v", rect.Sub(bounds.Min), bounds.Sub(bounds.Min))
+	}
+
+	cropped := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		sub, ok := frame.(interface {
+			SubImage(r image.Rectangle) image.Image
+		})
+		if !ok {
+			return nil, fmt.Errorf("frame %d of type %T cannot be cropped", i, frame)
+		}
+		cropped[i] = sub.SubImage(rect)
+	}
+
+	return cropped, nil
+}
+
+// TrimRect returns the rectangle inside the largest border of one color that
+// all frames share, relative to the top-left corner of the first frame, for
+// Crop. The border color is that of the top-left pixel of the first frame; a
+// row or column only counts as border if it has that color in every frame, so
+// content moving into the border later is kept. Frames without a border, or
+// of only the border color, give their full bounds.
+func TrimRect(frames []image.Image) image.Rectangle {
+	if len(frames) == 0 {
+		return image.Rectangle{}
+	}
+	bounds := frames[0].Bounds()
+	full := bounds.Sub(bounds.Min)
+	if bounds.Empty() {
+		return full
+	}
+	br, bg, bb, ba := frames[0].At(bounds.Min.X, bounds.Min.Y).RGBA()
+
+	// uniform reports whether the area has the border color in every frame
+	uniform := func(area image.Rectangle) bool {
+		for _, frame := range frames {
+			min := frame.Bounds().Min
+			for y := area.Min.Y; y < area.Max.Y; y++ {
+				for x := area.Min.X; x < area.Max.X; x++ {
+					r, g, b, a := frame.At(min.X+x, min.Y+y).RGBA()
+					if r != br || g != bg || b != bb || a != ba {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+
+	rect := full
+	for rect.Dy() > 0 && uniform(image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+1)) {
+		rect.Min.Y++
+	}
+	if rect.Empty() {
+		return full
+	}
+	for uniform(image.Rect(rect.Min.X, rect.Max.Y-1, rect.Max.X, rect.Max.Y)) {
+		rect.Max.Y--
+	}
+	for uniform(image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+1, rect.Max.Y)) {
+		rect.Min.X++
+	}
+	for uniform(image.Rect(rect.Max.X-1, rect.Min.Y, rect.Max.X, rect.Max.Y)) {
+		rect.Max.X--
+	}
+	return rect
+}
+
+// LimitFrames keeps only the first n frames and their delays.
+func LimitFrames(frames []image.Image, delays []int, n int) ([]image.Image, []int) {
+	if n <= 0 || n >= len(frames) {
+		return frames, delays
+	}
+	return frames[:n], delays[:n]
+}
+
+// SliceFrames returns frames first to last, both included and counted from 0,
+// together with their delays. The range must lie within the frames.
+func SliceFrames(frames []*image.Paletted, delays []int, first, last int) ([]*image.Paletted, []int, error) {
+	if first < 0 || last >= len(frames) || first > last {
+		return nil, nil, fmt.Errorf("frame range %d to %d outside the %d frames 0 to %d", first, last, len(frames), len(frames)-1)
+	}
+	return frames[first : last+1], delays[first : last+1], nil
+}
+
+// StrideFrames keeps every k-th frame, starting with the first one. The delays
+// of the skipped frames are added to the kept frame before them, so the total
+// duration of the animation is preserved.
+func StrideFrames(frames []image.Image, delays []int, k int) ([]image.Image, []int) {
+	if k <= 1 {
+		return frames, delays
+	}
+
+	kept := make([]image.Image, 0, (len(frames)+k-1)/k)
+	keptDelays := make([]int, 0, cap(kept))
+	for i, frame := range frames {
+		if i%k == 0 {
+			kept = append(kept, frame)
+			keptDelays = append(keptDelays, 0)
+		}
+		keptDelays[len(keptDelays)-1] += delays[i]
+	}
+
+	return kept, keptDelays
+}
+
+// Posterize snaps every RGB channel of the frames to levels evenly spaced
+// values between 0 and 255. Alpha is kept as it is.
+func Posterize(frames []image.Image, levels int) ([]image.Image, error) {
+	if levels < 2 || levels > 256 {
+		return nil, fmt.Errorf("invalid number of posterize levels %d, want 2 to 256", levels)
+	}
+
+	// Lookup table of the posterized value for every 8-bit value
+	var table [256]uint8
+	steps := levels - 1
+	for v := range table {
+		level := (v*steps + 127) / 255
+		table[v] = uint8((level*255 + steps/2) / steps)
+	}
+
+	posterized := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		dst := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(frame.At(x, y)).(color.NRGBA)
+				dst.SetNRGBA(x, y, color.NRGBA{table[c.R], table[c.G], table[c.B], c.A})
+			}
+		}
+		posterized[i] = dst
+	}
+
+	return posterized, nil
+}
+
+// DedupeFrames merges runs of identical consecutive frames into their first
+// frame, which is shown for the sum of their delays. Storing the result keeps
+// its timing only with per-frame delays (sag.Options.FrameDelays).
+func DedupeFrames(frames []*image.Paletted, delays []int) ([]*image.Paletted, []int) {
+	if len(frames) == 0 {
+		return frames, delays
+	}
+
+	kept := []*image.Paletted{frames[0]}
+	keptDelays := []int{delays[0]}
+	for i := 1; i < len(frames); i++ {
+		last := kept[len(kept)-1]
+		if frames[i].Bounds().Size() == last.Bounds().Size() && samePixels(frames[i], last) && samePalette(frames[i].Palette, last.Palette) {
+			keptDelays[len(keptDelays)-1] += delays[i]
+			continue
+		}
+		kept = append(kept, frames[i])
+		keptDelays = append(keptDelays, delays[i])
+	}
+
+	return kept, keptDelays
+}
+
+// samePixels reports whether two paletted frames of the same size have the same indices.
+func samePixels(a, b *image.Paletted) bool {
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			if a.ColorIndexAt(ab.Min.X+x, ab.Min.Y+y) != b.ColorIndexAt(bb.Min.X+x, bb.Min.Y+y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// PingPong appends the frames in reverse order without the last and the first
+// frame, so the animation plays forward and backward in a seamless loop:
+// N frames become 2N-2.
+func PingPong(frames []*image.Paletted, delays []int) ([]*image.Paletted, []int) {
+	if len(frames) < 3 {
+		return frames, delays
+	}
+
+	out := append([]*image.Paletted(nil), frames...)
+	outDelays := append([]int(nil), delays...)
+	for i := len(frames) - 2; i > 0; i-- {
+		out = append(out, frames[i])
+		outDelays = append(outDelays, delays[i])
+	}
+
+	return out, outDelays
+}
+
+// ResampleFPS resamples the frames to a constant rate of fps frames per
+// second: every output frame is the source frame shown at its start time, so
+// frames shorter than one interval are dropped and longer ones repeated. All
+// delays become 1000/fps milliseconds and the total duration stays within half
+// an interval of the source. A delay of 0, which GIF players show as a default
+// delay, counts as DefaultDelay.
+func ResampleFPS(frames []*image.Paletted, delays []int, fps int) ([]*image.Paletted, []int) {
+	if len(frames) == 0 || fps <= 0 {
+		return frames, delays
+	}
+
+	interval := max((1000+fps/2)/fps, 1)
+	total := 0
+	delays = slices.Clone(delays)
+	for i, d := range delays {
+		if d <= 0 {
+			delays[i] = DefaultDelay
+		}
+		total += delays[i]
+	}
+	n := max((total+interval/2)/interval, 1)
+
+	out := make([]*image.Paletted, n)
+	outDelays := make([]int, n)
+	src, end := 0, delays[0]
+	for k := range out {
+		t := k * interval
+		for t >= end && src < len(frames)-1 {
+			src++
+			end += delays[src]
+		}
+		out[k] = frames[src]
+		outDelays[k] = interval
+	}
+
+	return out, outDelays
+}
+
+// Concat joins the frames and delays of several animations of the same size
+// into one. The frames are mapped onto a palette derived from the colors of
+// all of them, which keeps every color as long as there are at most 256.
+func Concat(frames [][]*image.Paletted, delays [][]int) ([]*image.Paletted, []int, []color.Color, error) {
+	var all []*image.Paletted
+	var allDelays []int
+	for i := range frames {
+		if len(frames[i]) == 0 {
+			return nil, nil, nil, fmt.Errorf("animation %d has no frames", i+1)
+		}
+		size, first := frames[i][0].Bounds().Size(), frames[0][0].Bounds().Size()
+		if size != first {
+			return nil, nil, nil, fmt.Errorf("animation %d is %dx%d, animation 1 is %dx%d", i+1, size.X, size.Y, first.X, first.Y)
+		}
+		all = append(all, frames[i]...)
+		allDelays = append(allDelays, delays[i]...)
+	}
+
+	images := Images(all)
+	paletted, palette := ReduceColors(images, CountColors(images), Options{})
+	return paletted, allDelays, palette, nil
+}
@@ -0,0 +1,449 @@
+package convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"strings"
+	"testing"
+
+	"../sag"
+)
+
+func TestParseRect(t *testing.T) {
+	rect, err := ParseRect("5, 6,10,8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := image.Rect(5, 6, 15, 14); rect != want {
+		t.Errorf("rect = %v, want %v", rect, want)
+	}
+
+	for _, s := range []string{"", "1,2,3", "a,b,c,d", "0,0,0,5", "0,0,5,-1"} {
+		if _, err := ParseRect(s); err == nil {
+			t.Errorf("ParseRect(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	frame := image.NewPaletted(image.Rect(0, 0, 40, 30), color.Palette{color.Black, red})
+	frame.SetColorIndex(5, 6, 1)
+
+	cropped, err := Crop(Images([]*image.Paletted{frame, frame}), image.Rect(5, 6, 15, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paletted, palette := ReduceColors(cropped, CountColors(cropped), Options{})
+
+	var buf bytes.Buffer
+	if err := sag.Encode(&buf, paletted, []int{10, 10}, palette); err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := sag.NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := dec.Header()
+	if header.Width != 10 || header.Height != 8 {
+		t.Errorf("header size = %dx%d, want 10x8", header.Width, header.Height)
+	}
+
+	// The top-left pixel of the crop is the red marker
+	if got := paletted[0].At(0, 0); got != (color.Color(red)) {
+		t.Errorf("pixel (0,0) = %v, want %v", got, red)
+	}
+}
+
+func TestCropOutOfBounds(t *testing.T) {
+	frames := Images([]*image.Paletted{solidFrame(20, 10, color.White)})
+
+	for _, rect := range []image.Rectangle{
+		image.Rect(15, 0, 25, 5),
+		image.Rect(-1, 0, 5, 5),
+		image.Rect(0, 0, 20, 11),
+	} {
+		if _, err := Crop(frames, rect); err == nil {
+			t.Errorf("Crop(%v) succeeded, want error", rect)
+		}
+	}
+}
+
+func TestTrimRect(t *testing.T) {
+	// Three 30×20 frames with a 5 px black border around moving content
+	frames := make([]image.Image, 3)
+	for i := range frames {
+		frame := image.NewRGBA(image.Rect(0, 0, 30, 20))
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		draw.Draw(frame, image.Rect(5+i, 5, 20+i, 15), image.NewUniform(color.White), image.Point{}, draw.Src)
+		frames[i] = frame
+	}
+	// The content covers x 5 to 22 over all frames, the right border is wider
+	want := image.Rect(5, 5, 22, 15)
+	if got := TrimRect(frames); got != want {
+		t.Errorf("TrimRect = %v, want %v", got, want)
+	}
+
+	// With an even border of 5 px all around, the trimmed frames are the content alone
+	for i := range frames {
+		frame := image.NewRGBA(image.Rect(0, 0, 30, 20))
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		draw.Draw(frame, image.Rect(5, 5, 25, 15), image.NewUniform(color.Gray{Y: uint8(50 * (i + 1))}), image.Point{}, draw.Src)
+		frames[i] = frame
+	}
+	frame := frames[1].(*image.RGBA)
+	frame.Set(5, 5, color.Black) // A border-colored pixel inside the content does not count
+	rect := TrimRect(frames)
+	if rect != image.Rect(5, 5, 25, 15) {
+		t.Fatalf("TrimRect = %v, want the 5 px border removed", rect)
+	}
+	trimmed, err := Crop(frames, rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := trimmed[2].Bounds(); b.Dx() != 20 || b.Dy() != 10 {
+		t.Errorf("trimmed frame is %dx%d, want 20x10", b.Dx(), b.Dy())
+	}
+
+	// A frame of only the border color has nothing to trim
+	black := []image.Image{solidFrame(4, 3, color.Black)}
+	if got := TrimRect(black); got != image.Rect(0, 0, 4, 3) {
+		t.Errorf("TrimRect of a single-colored frame = %v, want its full bounds", got)
+	}
+}
+
+func TestStrideFrames(t *testing.T) {
+	frames := make([]image.Image, 10)
+	delays := make([]int, 10)
+	for i := range frames {
+		frames[i] = solidFrame(1, 1, color.Gray{Y: uint8(i)})
+		delays[i] = 40
+	}
+
+	kept, keptDelays := StrideFrames(frames, delays, 2)
+	if len(kept) != 5 || len(keptDelays) != 5 {
+		t.Fatalf("got %d frames and %d delays, want 5", len(kept), len(keptDelays))
+	}
+	for i := range kept {
+		if kept[i] != frames[i*2] {
+			t.Errorf("frame %d is not source frame %d", i, i*2)
+		}
+		if keptDelays[i] != 80 {
+			t.Errorf("delay %d = %d, want 80", i, keptDelays[i])
+		}
+	}
+
+	// An uneven tail keeps the remaining duration
+	_, keptDelays = StrideFrames(frames, delays, 3)
+	if want := []int{120, 120, 120, 40}; !equalInts(keptDelays, want) {
+		t.Errorf("stride 3 delays = %v, want %v", keptDelays, want)
+	}
+}
+
+func TestLimitFrames(t *testing.T) {
+	frames := make([]image.Image, 10)
+	delays := make([]int, 10)
+
+	kept, keptDelays := LimitFrames(frames, delays, 3)
+	if len(kept) != 3 || len(keptDelays) != 3 {
+		t.Errorf("got %d frames and %d delays, want 3", len(kept), len(keptDelays))
+	}
+	if kept, _ := LimitFrames(frames, delays, 0); len(kept) != 10 {
+		t.Errorf("limit 0 kept %d frames, want all", len(kept))
+	}
+}
+
+// equalInts reports whether a and b hold the same values.
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPosterizeTwoLevels(t *testing.T) {
+	ramp := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		ramp.Set(x, 0, color.RGBA{uint8(x), uint8(255 - x), uint8(x / 2), 255})
+	}
+
+	frames, err := Posterize([]image.Image{ramp}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c := range CountColors(frames) {
+		r, g, b, _ := c.RGBA()
+		for _, v := range []uint32{r >> 8, g >> 8, b >> 8} {
+			if v != 0 && v != 255 {
+				t.Fatalf("color %v has a channel of %d, want 0 or 255", c, v)
+			}
+		}
+	}
+
+	if _, err := Posterize([]image.Image{ramp}, 1); err == nil {
+		t.Error("Posterize with 1 level succeeded, want error")
+	}
+}
+
+func TestPosterizeLevels(t *testing.T) {
+	ramp := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		ramp.Set(x, 0, color.RGBA{uint8(x), 0, 0, 255})
+	}
+
+	frames, err := Posterize([]image.Image{ramp}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint32]bool)
+	for c := range CountColors(frames) {
+		r, _, _, _ := c.RGBA()
+		seen[r>>8] = true
+	}
+	for _, v := range []uint32{0, 85, 170, 255} {
+		if !seen[v] {
+			t.Errorf("level %d missing, got %v", v, seen)
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("got %d levels, want 4", len(seen))
+	}
+}
+
+func TestDedupeFramesTiming(t *testing.T) {
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}}
+	first := image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+	first.SetColorIndex(1, 1, 1)
+	duplicate := image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+	copy(duplicate.Pix, first.Pix)
+	last := image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+	last.SetColorIndex(3, 0, 1)
+
+	frames, delays := DedupeFrames([]*image.Paletted{first, duplicate, last}, []int{100, 200, 50})
+	if len(frames) != 2 || !equalInts(delays, []int{300, 50}) {
+		t.Fatalf("got %d frames with delays %v, want 2 with [300 50]", len(frames), delays)
+	}
+
+	var sagData bytes.Buffer
+	if err := sag.EncodeWithOptions(&sagData, frames, delays, palette, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := sag.Decode(&sagData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || !equalInts(decodedDelays, []int{300, 50}) {
+		t.Fatalf("decoded %d frames with delays %v, want 2 with [300 50]", len(decoded), decodedDelays)
+	}
+
+	// sag2gif must show the first picture as long as both original frames together
+	var gifData bytes.Buffer
+	if err := EncodeGIF(&gifData, decoded, decodedDelays); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&gifData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(anim.Delay, []int{30, 5}) {
+		t.Errorf("GIF delays = %v, want [30 5]", anim.Delay)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	palette := []color.Color{color.RGBA{A: 255}}
+	frames := make([]*image.Paletted, 4)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	}
+
+	out, delays := PingPong(frames, []int{10, 20, 30, 40})
+	if len(out) != 2*len(frames)-2 {
+		t.Fatalf("got %d frames, want %d", len(out), 2*len(frames)-2)
+	}
+	for i, want := range []int{0, 1, 2, 3, 2, 1} {
+		if out[i] != frames[want] {
+			t.Errorf("frame %d is not source frame %d", i, want)
+		}
+	}
+	if !equalInts(delays, []int{10, 20, 30, 40, 30, 20}) {
+		t.Errorf("delays = %v, want [10 20 30 40 30 20]", delays)
+	}
+
+	if short, _ := PingPong(frames[:2], []int{10, 20}); len(short) != 2 {
+		t.Errorf("2 frames became %d, want 2", len(short))
+	}
+}
+
+func TestConcat(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	clip := func(c color.Color, delay int) ([]*image.Paletted, []int) {
+		palette := color.Palette{color.RGBA{A: 255}, c}
+		var buf bytes.Buffer
+		frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 2), palette), image.NewPaletted(image.Rect(0, 0, 4, 2), palette)}
+		frames[1].Pix[3] = 1
+		if err := sag.Encode(&buf, frames, []int{delay, delay}, palette); err != nil {
+			t.Fatal(err)
+		}
+		decoded, delays, err := sag.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded, delays
+	}
+	aFrames, aDelays := clip(red, 100)
+	bFrames, bDelays := clip(blue, 40)
+
+	frames, delays, palette, err := Concat([][]*image.Paletted{aFrames, bFrames}, [][]int{aDelays, bDelays})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := sag.EncodeWithOptions(&buf, frames, delays, palette, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != 4 {
+		t.Fatalf("%d frames, want 4", len(decoded))
+	}
+	if want := []int{100, 100, 40, 40}; !equalInts(decodedDelays, want) {
+		t.Errorf("delays %v, want %v", decodedDelays, want)
+	}
+	for i, want := range []color.Color{red, blue} {
+		if got := decoded[1+2*i].At(3, 0); got != want {
+			t.Errorf("frame %d pixel (3,0) = %v, want %v", 1+2*i, got, want)
+		}
+	}
+}
+
+func TestConcatSizeMismatch(t *testing.T) {
+	palette := color.Palette{color.Black}
+	a := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 2), palette)}
+	b := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 3), palette)}
+	_, _, _, err := Concat([][]*image.Paletted{a, b}, [][]int{{100}, {100}})
+	if err == nil || !strings.Contains(err.Error(), "4x3") {
+		t.Errorf("error %v, want a size mismatch naming 4x3", err)
+	}
+}
+
+func TestResampleFPS(t *testing.T) {
+	palette := color.Palette{color.Black}
+	frames := make([]*image.Paletted, 4)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	}
+	// 40 ms is shorter than the interval and dropped, 230 ms is repeated
+	delays := []int{130, 40, 230, 120}
+
+	out, outDelays := ResampleFPS(frames, delays, 10)
+
+	total := 0
+	for i, d := range outDelays {
+		if d != 100 {
+			t.Errorf("delay %d = %d, want 100", i, d)
+		}
+		total += d
+	}
+	if diff := total - 520; diff < -100 || diff > 100 {
+		t.Errorf("total duration %d ms, want 520 within one interval", total)
+	}
+
+	// Frames start at 0, 130, 170 and 400 ms
+	want := []int{0, 0, 2, 2, 3}
+	if len(out) != len(want) {
+		t.Fatalf("%d frames, want %d", len(out), len(want))
+	}
+	for i, w := range want {
+		if out[i] != frames[w] {
+			t.Errorf("frame %d is not source frame %d", i, w)
+		}
+	}
+}
+
+func TestResampleFPSZeroDelays(t *testing.T) {
+	palette := color.Palette{color.Black}
+	frames := make([]*image.Paletted, 3)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	}
+	delays := []int{0, 0, 0}
+
+	// Every frame is shown for DefaultDelay, at 20 fps each one twice
+	out, outDelays := ResampleFPS(frames, delays, 20)
+	want := []int{0, 0, 1, 1, 2, 2}
+	if len(out) != len(want) {
+		t.Fatalf("%d frames, want %d", len(out), len(want))
+	}
+	for i, w := range want {
+		if out[i] != frames[w] || outDelays[i] != 50 {
+			t.Errorf("frame %d: delay %d, want source frame %d for 50 ms", i, outDelays[i], w)
+		}
+	}
+	if delays[0] != 0 {
+		t.Errorf("input delays changed to %v", delays)
+	}
+}
+
+func TestSliceFrames(t *testing.T) {
+	palette := color.Palette{color.Black, color.White, color.RGBA{R: 255, A: 255}}
+	src := make([]*image.Paletted, 8)
+	srcDelays := make([]int, len(src))
+	for i := range src {
+		src[i] = image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+		src[i].Pix[i] = uint8(1 + i%2)
+		srcDelays[i] = 10 * (i + 1)
+	}
+	var buf bytes.Buffer
+	if err := sag.EncodeWithOptions(&buf, src, srcDelays, palette, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	frames, delays, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sliced, slicedDelays, err := SliceFrames(frames, delays, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := sag.EncodeWithOptions(&buf, sliced, slicedDelays, sliced[0].Palette, &sag.Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := sag.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != 3 {
+		t.Fatalf("%d frames, want 3", len(decoded))
+	}
+	if want := []int{40, 50, 60}; !equalInts(decodedDelays, want) {
+		t.Errorf("delays %v, want %v", decodedDelays, want)
+	}
+	for i, frame := range decoded {
+		if !bytes.Equal(frame.Pix, src[3+i].Pix) {
+			t.Errorf("frame %d differs from source frame %d", i, 3+i)
+		}
+	}
+
+	for _, r := range [][2]int{{-1, 2}, {5, 8}, {4, 3}} {
+		if _, _, err := SliceFrames(frames, delays, r[0], r[1]); err == nil {
+			t.Errorf("SliceFrames(%d, %d) of 8 frames succeeded", r[0], r[1])
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// x/image/webp only decodes the first frame of animated WebP files, so the
+// ANMF chunks of the extended format are parsed here and every frame bitstream
+// is decoded on its own and composited onto the canvas.
+
+// webpChunk is a RIFF chunk of a WebP file.
+type webpChunk struct {
+	id   string
+	data []byte
+}
+
+// readWebPChunks splits the RIFF container of a WebP file into its chunks.
+func readWebPChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("webp: invalid RIFF header")
+	}
+	return splitWebPChunks(data[12:])
+}
+
+// splitWebPChunks splits a sequence of RIFF chunks.
+func splitWebPChunks(data []byte) ([]webpChunk, error) {
+	var chunks []webpChunk
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return nil, fmt.Errorf("webp: chunk %q exceeds the file size", id)
+		}
+		chunks = append(chunks, webpChunk{id: id, data: data[:size]})
+
+		// Chunks are padded to an even size
+		if size%2 == 1 && size < len(data) {
+			size++
+		}
+		data = data[size:]
+	}
+	return chunks, nil
+}
+
+// isAnimatedWebP reports whether the chunks describe an animated WebP.
+func isAnimatedWebP(chunks []webpChunk) bool {
+	for _, chunk := range chunks {
+		if chunk.id == "VP8X" && len(chunk.data) >= 1 {
+			return chunk.data[0]&0x02 != 0
+		}
+	}
+	return false
+}
+
+// decodeAnimatedWebP decodes all frames of an animated WebP together with their durations in milliseconds.
+func decodeAnimatedWebP(chunks []webpChunk) ([]image.Image, []int, error) {
+	var canvas *image.RGBA
+	var frames []image.Image
+	var delays []int
+
+	for _, chunk := range chunks {
+		switch chunk.id {
+		case "VP8X":
+			if len(chunk.data) < 10 {
+				return nil, nil, errors.New("webp: short VP8X chunk")
+			}
+			width, height := uint24(chunk.data[4:7])+1, uint24(chunk.data[7:10])+1
+			canvas = image.NewRGBA(image.Rect(0, 0, width, height))
+
+		case "ANMF":
+			if canvas == nil {
+				return nil, nil, errors.New("webp: ANMF chunk before VP8X chunk")
+			}
+			if len(chunk.data) < 16 {
+				return nil, nil, errors.New("webp: short ANMF chunk")
+			}
+			d := chunk.data
+			x, y := 2*uint24(d[0:3]), 2*uint24(d[3:6])
+			width, height := uint24(d[6:9])+1, uint24(d[9:12])+1
+			duration := uint24(d[12:15])
+			flags := d[15]
+
+			img, err := webp.Decode(bytes.NewReader(wrapWebPFrame(d[16:], width, height)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("webp: frame %d: %v", len(frames), err)
+			}
+
+			// Bit 1 set means "do not blend", the frame replaces the canvas area
+			rect := image.Rect(x, y, x+width, y+height)
+			op := draw.Over
+			if flags&0x02 != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+			snapshot := image.NewRGBA(canvas.Bounds())
+			copy(snapshot.Pix, canvas.Pix)
+			frames = append(frames, snapshot)
+			delays = append(delays, duration)
+
+			// Bit 0 set means the frame area is disposed to the background afterwards
+			if flags&0x01 != 0 {
+				draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if len(frames) == 0 {
+		return nil, nil, errors.New("webp: animation without frames")
+	}
+	return frames, delays, nil
+}
+
+// wrapWebPFrame wraps the bitstream chunks of an ANMF frame into a standalone
+// WebP file, adding a VP8X chunk if the frame carries an ALPH chunk.
+func wrapWebPFrame(frameData []byte, width, height int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if len(frameData) >= 4 && string(frameData[0:4]) == "ALPH" {
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10 // Alpha flag
+		putUint24(vp8x[4:7], width-1)
+		putUint24(vp8x[7:10], height-1)
+		writeWebPChunk(&body, "VP8X", vp8x)
+	}
+	body.Write(frameData)
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// writeWebPChunk writes a RIFF chunk including its padding byte.
+func writeWebPChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// uint24 decodes a 24 bit little endian value.
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// putUint24 encodes v as a 24 bit little endian value.
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
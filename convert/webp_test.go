@@ -0,0 +1,147 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bitWriter packs bits LSB-first as required by the VP8L bitstream.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((v>>i)&1) << (w.nbits % 8)
+		w.nbits++
+	}
+}
+
+// solidVP8L returns a lossless VP8L bitstream of a w×h image filled with c.
+// Every prefix code is a "simple" code with a single symbol, so the pixels
+// themselves take zero bits.
+func solidVP8L(w, h int, c color.NRGBA) []byte {
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(1, 1) // alpha is used
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transform
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+	for _, symbol := range []uint8{c.G, c.R, c.B, c.A} {
+		bw.write(1, 1) // simple code
+		bw.write(0, 1) // one symbol
+		bw.write(1, 1) // 8 bit symbol
+		bw.write(uint32(symbol), 8)
+	}
+	// Distance code
+	bw.write(1, 1)
+	bw.write(0, 1)
+	bw.write(0, 1) // 1 bit symbol
+	bw.write(0, 1)
+	return bw.buf
+}
+
+// webpFrame describes one frame of a synthetic animated WebP.
+type webpFrame struct {
+	rect     image.Rectangle
+	color    color.NRGBA
+	duration int
+	flags    byte
+}
+
+// animatedWebP builds an animated WebP file of the given canvas size.
+func animatedWebP(width, height int, frames []webpFrame) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10 // Animation and alpha
+	putUint24(vp8x[4:7], width-1)
+	putUint24(vp8x[7:10], height-1)
+	writeWebPChunk(&body, "VP8X", vp8x)
+	writeWebPChunk(&body, "ANIM", make([]byte, 6))
+
+	for _, f := range frames {
+		var anmf bytes.Buffer
+		header := make([]byte, 16)
+		putUint24(header[0:3], f.rect.Min.X/2)
+		putUint24(header[3:6], f.rect.Min.Y/2)
+		putUint24(header[6:9], f.rect.Dx()-1)
+		putUint24(header[9:12], f.rect.Dy()-1)
+		putUint24(header[12:15], f.duration)
+		header[15] = f.flags
+		anmf.Write(header)
+		writeWebPChunk(&anmf, "VP8L", solidVP8L(f.rect.Dx(), f.rect.Dy(), f.color))
+		writeWebPChunk(&body, "ANMF", anmf.Bytes())
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func TestWebPLoaderAnimated(t *testing.T) {
+	data := animatedWebP(8, 6, []webpFrame{
+		{rect: image.Rect(0, 0, 8, 6), color: color.NRGBA{R: 255, A: 255}, duration: 50, flags: 0x02},
+		{rect: image.Rect(2, 2, 6, 6), color: color.NRGBA{B: 255, A: 255}, duration: 120},
+	})
+
+	filename := filepath.Join(t.TempDir(), "anim.webp")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, delays, err := WebPLoader{}.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if delays[0] != 50 || delays[1] != 120 {
+		t.Errorf("delays = %v, want [50 120]", delays)
+	}
+	for i, frame := range frames {
+		if got := frame.Bounds(); got != image.Rect(0, 0, 8, 6) {
+			t.Errorf("frame %d bounds = %v, want the canvas size", i, got)
+		}
+	}
+
+	// The second frame is composited over the first one
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	for _, tc := range []struct {
+		frame, x, y int
+		want        color.RGBA
+	}{
+		{0, 3, 3, red},
+		{1, 0, 0, red},
+		{1, 3, 3, blue},
+	} {
+		if got := color.RGBAModel.Convert(frames[tc.frame].At(tc.x, tc.y)); got != tc.want {
+			t.Errorf("frame %d pixel (%d,%d) = %v, want %v", tc.frame, tc.x, tc.y, got, tc.want)
+		}
+	}
+}
+
+func TestWebPChunksTruncated(t *testing.T) {
+	data := animatedWebP(4, 4, []webpFrame{
+		{rect: image.Rect(0, 0, 4, 4), color: color.NRGBA{A: 255}, duration: 10},
+	})
+	if _, err := readWebPChunks(data[:len(data)-4]); err == nil {
+		t.Error("truncated file parsed without error")
+	}
+}
@@ -1,239 +1,574 @@
 package main
 
 import (
-	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/gif"
+	"image/png"
 	"os"
+	"strconv"
+	"strings"
 
 	"golang.org/x/image/draw"
-	"golang.org/x/image/tiff"
-	"golang.org/x/image/webp"
 
+	"./cli"
+	"./convert"
 	"./imgcolor"
+	"./sag"
 )
 
-// SAGHeader repräsentiert den Header der SAG-Datei.
-type SAGHeader struct {
-	Signature    [3]byte   // "SAG"
-	Version      byte      // Version 1.0 = 0x01
-	Width        uint16    // Breite des Bildes in Pixeln
-	Height       uint16    // Höhe des Bildes in Pixeln
-	FrameCount   uint16    // Anzahl der Frames
-	FrameDelay   uint16    // Dauer jedes Frames in Millisekunden
-	ColorPalette [768]byte // Globale Farbpalette (256 Farben, je 3 Bytes RGB)
-}
-
-// ImageLoader ist eine Schnittstelle zum Laden und Verarbeiten von animierten Bildformaten.
-type ImageLoader interface {
-	Load(filename string) ([]*image.Paletted, []int, error)
-}
-
-// GIFLoader lädt GIF-Bilder.
-type GIFLoader struct{}
-
-func (g GIFLoader) Load(filename string) ([]*image.Paletted, []int, error) {
-	file, err := os.Open(filename)
+// writeSAGFile erstellt die SAG-Datei aus dem übergebenen animierten Bild.
+func writeSAGFile(frames []*image.Paletted, delays []int, palette []color.Color, opts *sag.Options, outputFilename string) error {
+	// Datei erstellen
+	file, err := os.Create(outputFilename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	defer file.Close()
 
-	gifImage, err := gif.DecodeAll(file)
+	return sag.EncodeWithOptions(file, frames, delays, palette, opts)
+}
+
+// writeSAGStream erstellt die SAG-Datei Frame für Frame, ohne alle Frames im Speicher zu halten.
+func writeSAGStream(count int, frame convert.FrameFunc, delays []int, opts convert.Options, sagOpts *sag.Options, outputFilename string) error {
+	file, err := os.Create(outputFilename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
+	defer file.Close()
 
-	return gifImage.Image, gifImage.Delay, nil
+	return convert.EncodeStream(file, count, frame, delays, opts, sagOpts)
 }
 
-// TIFFLoader lädt TIFF-Bilder.
-type TIFFLoader struct{}
-
-func (t TIFFLoader) Load(filename string) ([]*image.Paletted, []int, error) {
+// verifySAGFile liest die SAG-Datei wieder ein und vergleicht sie mit den Frames.
+func verifySAGFile(frames []*image.Paletted, filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	defer file.Close()
 
-	img, err := tiff.Decode(file)
-	if err != nil {
-		return nil, nil, err
-	}
+	return sag.Verify(file, frames)
+}
 
-	return singleFrameToPaletted(img), []int{100}, nil // 100 ms als Standard-Delay
+// writeStamp schreibt den Stempel der Konvertierung neben die Ausgabe; ohne
+// -skip-unchanged ist der Stempel leer und es entsteht keine .stamp-Datei.
+func writeStamp(filename, stamp string) error {
+	if stamp == "" {
+		return nil
+	}
+	return convert.WriteStamp(filename, stamp)
 }
 
-// WebPLoader lädt WebP-Bilder.
-type WebPLoader struct{}
+// writePalettePreview writes a PNG with a swatch of every palette color.
+func writePalettePreview(palette []color.Color, filename string) error {
+	preview, err := convert.PalettePreview(palette)
+	if err != nil {
+		return err
+	}
 
-func (w WebPLoader) Load(filename string) ([]*image.Paletted, []int, error) {
-	file, err := os.Open(filename)
+	file, err := os.Create(filename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	defer file.Close()
 
-	img, err := webp.Decode(file)
-	if err != nil {
-		return nil, nil, err
+	return png.Encode(file, preview)
+}
+
+// parseCycle parses a palette cycling range given as "start,count,delay".
+func parseCycle(s string) (start, count, delay int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid cycle %q, want start,count,delay", s)
 	}
 
-	return singleFrameToPaletted(img), []int{100}, nil // 100 ms als Standard-Delay
+	var v [3]int
+	for i, part := range parts {
+		if v[i], err = strconv.Atoi(strings.TrimSpace(part)); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid cycle %q: %v", s, err)
+		}
+	}
+	return v[0], v[1], v[2], nil
 }
 
-// singleFrameToPaletted konvertiert ein Einzelbild in eine Paletted-Version.
-func singleFrameToPaletted(img image.Image) []*image.Paletted {
-	bounds := img.Bounds()
-	palettedImg := image.NewPaletted(bounds, nil)
-	draw.FloydSteinberg.Draw(palettedImg, bounds, img, image.Point{})
-	return []*image.Paletted{palettedImg}
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
 }
 
-// reduceColors reduziert die Farbpalette eines Bildes auf 256 Farben.
-func reduceColors(frames []*image.Paletted) ([]*image.Paletted, []color.Color) {
-	// Erstelle ein gemeinsames ColorCount-Map für alle Frames
-	colorCount := make(map[color.Color]int)
-	for _, frame := range frames {
-		imgcolor.CountColorsInImage(frame, colorCount)
+// run converts the files given on the command line. It returns errors marked
+// with their exit code instead of exiting, so main is the only place that
+// sets the exit code.
+func run() error {
+	maxError := flag.Float64("max-error", -1, "use the smallest palette of 2, 4, 8 … 256 colors whose mean squared RGB error stays at or below this value (negative = off)")
+	kmeans := flag.Int("kmeans", 0, "number of k-means iterations to refine the palette (0 = off)")
+	linear := flag.Bool("linear", false, "match and average colors in linear light instead of sRGB (same as -metric linear)")
+	metric := flag.String("metric", "rgb", "color distance used to match pixels: rgb, weighted, lab, linear")
+	dither := flag.String("dither", "none", "dithering: none, ordered (8x8 Bayer), ordered4, ordered8, fs (Floyd-Steinberg), temporal (Floyd-Steinberg carried into the next frame)")
+	ditherStrength := flag.Float64("dither-strength", 1, "scale the dithering from 0.0 (nearest color only) to 1.0 (full Floyd-Steinberg or Bayer pattern)")
+	var reserve []string
+	flag.Func("reserve", "force the color into a fixed palette slot, as index=R,G,B; repeat for more slots, the pixels only use the other slots", func(s string) error {
+		reserve = append(reserve, s)
+		return nil
+	})
+	paletteFile := flag.String("palette", "", "map the frames onto a fixed palette from a .gpl or .act file")
+	width := flag.Int("width", 0, "resize frames to this width (0 = keep, or derive from -height)")
+	height := flag.Int("height", 0, "resize frames to this height (0 = keep, or derive from -width)")
+	sizes := flag.String("sizes", "", "write one SAG file per comma-separated WxH size, e.g. 75x75,150x150, named <out-prefix>_WxH.sag; the input is decoded once")
+	outPrefix := flag.String("out-prefix", "", "file name prefix of the -sizes outputs, which replace <output.sag>")
+	resizeFilter := flag.String("resize-filter", "nearest", "resize filter: nearest, bilinear, catmull, area (averages the covered pixels, for shrinking detailed sources)")
+	keepAspect := flag.Bool("keep-aspect", false, "keep the aspect ratio when resizing and letterbox the frames")
+	background := flag.String("background", "", "composite the frames over this R,G,B color before reducing the colors")
+	brightness := flag.Int("brightness", 0, "add this value to every RGB channel before reducing the colors")
+	contrast := flag.Float64("contrast", 1, "scale the contrast by this factor before reducing the colors (1 = unchanged)")
+	saturation := flag.Float64("saturation", 1, "scale the saturation by this factor before reducing the colors (1 = unchanged)")
+	grayscale := flag.String("grayscale", "", "convert the frames to grays with rec601 (NTSC) or rec709 luma weights before reducing the colors")
+	posterize := flag.Int("posterize", 0, "snap every RGB channel to N evenly spaced levels before reducing the colors (0 = off)")
+	crop := flag.String("crop", "", "crop every frame to x,y,w,h before resizing")
+	autotrim := flag.Bool("autotrim", false, "crop the largest border of one color that all frames share, after -crop and before resizing; -verbose prints the rectangle")
+	maxFrames := flag.Int("max-frames", 0, "only convert the first N frames (0 = all)")
+	lenient := flag.Bool("lenient", false, "keep the complete frames of a damaged GIF, e.g. with a broken trailer or damaged image data, instead of failing; prints a warning")
+	stride := flag.Int("stride", 1, "keep every Kth frame, the skipped delays are added to the kept frame")
+	verbose := flag.Bool("verbose", false, "print color and compression statistics to stderr")
+	flag.BoolVar(verbose, "v", false, "shorthand for -verbose")
+	bpp := flag.Int("bpp", 8, "bits per pixel: 8, 4 (SAG version 2, at most 16 colors) or 0 to pack when the palette has at most 16 colors")
+	twoPass := flag.Bool("two-pass", false, "build the palette from a sample of every 4th pixel first, then map all pixels onto it")
+	optimizeDelta := flag.Bool("optimize-delta", false, "merge palette entries stored as the same color and order the palette by use, so more pixels keep their index between frames")
+	sortPalette := flag.String("sort-palette", "", "order the palette by luminance or hue instead of frequency")
+	palettePreview := flag.String("palette-preview", "", "write the final palette as a PNG with one labeled swatch per color")
+	pingpong := flag.Bool("pingpong", false, "append the frames in reverse, without first and last, to play forward and backward")
+	fps := flag.Int("fps", 0, "resample to a constant rate of N frames per second, dropping or repeating frames (0 = off)")
+	dedupe := flag.Bool("dedupe", false, "merge identical consecutive frames and store per-frame delays (SAG version 2)")
+	pad8 := flag.Bool("pad8", false, "pad the width to a multiple of 8 and store the real width (SAG version 2)")
+	padIndex := flag.Int("pad-index", 0, "palette index of the -pad8 padding")
+	preserveTiming := flag.Bool("preserve-timing", false, "store the exact delay of every frame instead of one delay for all (SAG version 2 if they differ)")
+	cycle := flag.String("cycle", "", "mark palette entries start,count for palette cycling by the player, rotated every delay ms (SAG version 2)")
+	title := flag.String("title", "", "store this title of at most 255 bytes in the file (SAG version 2)")
+	var meta []string
+	flag.Func("meta", "store the key=value pair in the file, e.g. author=Ada; repeat it for more pairs, at most 1024 bytes in total (SAG version 2)", func(s string) error {
+		meta = append(meta, s)
+		return nil
+	})
+	deflate := flag.Bool("z", false, "compress the frame data with DEFLATE for the smallest file (SAG version 2)")
+	paletteSize := flag.Bool("palette-size", false, "store the number of palette colors, so decoders do not pad the palette to 256 entries (SAG version 2)")
+	localPalettes := flag.Bool("local-palettes", false, "reduce the colors of every frame on its own and store a palette per frame, for animations whose colors change a lot (SAG version 2, not with -row-skip, -interleave, -palette, -max-error, -two-pass, -optimize-delta or -sort-palette)")
+	noDelta := flag.Bool("no-delta", false, "store the pixels without the identical-bytes of the delta encoding, one byte less per 8 pixels for still images (SAG version 2)")
+	littleEndian := flag.Bool("little-endian", false, "store the multi-byte fields little-endian, so little-endian players can read the header in place (SAG version 2)")
+	interleave := flag.Bool("interleave", false, "store row 0 of all frames, then row 1 of all frames and so on, for panels refreshing row by row (SAG version 2, not with -row-skip)")
+	rowSkip := flag.Bool("row-skip", false, "skip unchanged rows (SAG version 2) if that makes the file smaller")
+	verify := flag.Bool("verify", false, "decode the written SAG file again and compare it with the converted frames")
+	progress := flag.Bool("progress", false, "print the converted frame number to stderr")
+	estimate := flag.Bool("estimate", false, "only print the size of the SAG file, <output.sag> is not written")
+	skipUnchanged := flag.Bool("skip-unchanged", false, "skip the conversion if <output.sag> was already written by this gif2sag from the same input with the same options and is unchanged, and write a .stamp file next to every output for the next run")
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	// Mit -sizes ersetzt -out-prefix die Ausgabedatei
+	args := 3
+	if *sizes != "" {
+		args = 2
+	}
+	if flag.NArg() < args {
+		fmt.Println("Usage: gif2sag [options] <input> <output.sag> <format>")
+		fmt.Println("       gif2sag [options] -sizes WxH,WxH -out-prefix <name> <input> <format>")
+		fmt.Println("Supported formats: gif, tiff, webp, jpeg, png")
+		flag.PrintDefaults()
+		return cli.ErrUsage
 	}
 
-	// Extrahiere die häufigsten 256 Farben
-	palette := imgcolor.ExtractPalette(colorCount, 256)
+	inputFilename := flag.Arg(0)
+	outputFilename := flag.Arg(1)
+	format := flag.Arg(args - 1)
 
-	// Konvertiere alle Frames auf die neue Farbpalette
-	for i, frame := range frames {
-		frames[i] = applyPalette(frame, palette)
+	loader, err := convert.LoaderByName(format)
+	if err != nil {
+		return cli.Usage(err)
+	}
+	if *sizes != "" && (*outPrefix == "" || *width > 0 || *height > 0) {
+		return cli.Usage(errors.New("-sizes needs -out-prefix and cannot be combined with -width or -height"))
+	}
+	metadata, err := convert.ParseMetadata(meta)
+	if err != nil {
+		return cli.Usage(err)
+	}
+	if *padIndex < 0 || *padIndex > 255 {
+		return cli.Usage(fmt.Errorf("-pad-index %d is outside of 0 to 255", *padIndex))
+	}
+	if *ditherStrength < 0 || *ditherStrength > 1 {
+		return cli.Usage(fmt.Errorf("-dither-strength %g is outside of 0.0 to 1.0", *ditherStrength))
+	}
+	if *posterize < 0 || *posterize == 1 || *posterize > 256 {
+		return cli.Usage(fmt.Errorf("-posterize %d is outside of 2 to 256", *posterize))
+	}
+	if *interleave && *rowSkip {
+		return cli.Usage(errors.New("-interleave cannot be combined with -row-skip"))
+	}
+	if len(reserve) > 0 && (*optimizeDelta || *sortPalette != "") {
+		return cli.Usage(errors.New("-reserve cannot be combined with -optimize-delta or -sort-palette, they move the palette entries"))
+	}
+	if *localPalettes && (*rowSkip || *interleave || *paletteFile != "" || *maxError >= 0 || *twoPass || *optimizeDelta || *sortPalette != "") {
+		return cli.Usage(errors.New("-local-palettes cannot be combined with -row-skip, -interleave, -palette, -max-error, -two-pass, -optimize-delta or -sort-palette"))
 	}
 
-	return frames, palette
-}
+	// Lege die Ausgabedateien fest, mit -sizes eine je Zielgröße
+	var targets []image.Point
+	outputs := []string{outputFilename}
+	if *sizes != "" {
+		if targets, err = convert.ParseSizes(*sizes); err != nil {
+			return cli.Usage(err)
+		}
+		outputs = outputs[:0]
+		for _, size := range targets {
+			outputs = append(outputs, convert.SizedFilename(*outPrefix, size))
+		}
+	}
 
-// applyPalette wendet eine Farbpalette auf ein Bild an.
-func applyPalette(frame *image.Paletted, palette []color.Color) *image.Paletted {
-	bounds := frame.Bounds()
-	newFrame := image.NewPaletted(bounds, palette)
+	// Überspringe mit -skip-unchanged die Konvertierung, wenn alle Ausgaben unverändert aus derselben Quelle mit denselben Optionen stammen
+	var stamp string
+	if *skipUnchanged && !*estimate {
+		var settings []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "skip-unchanged" && f.Name != "quiet" && f.Name != "progress" {
+				settings = append(settings, f.Name+"="+f.Value.String())
+			}
+		})
+		settings = append(settings, "format="+format)
+		// Die Palettendatei gehört zur Quelle, ihr Inhalt kann sich unter demselben Namen ändern;
+		// ebenso das Programm, damit ein korrigierter Konverter alte Ausgaben neu schreibt
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locating gif2sag for the stamp: %w", err)
+		}
+		sources := []string{inputFilename, executable}
+		if *paletteFile != "" {
+			sources = append(sources, *paletteFile)
+		}
+		if stamp, err = convert.SourceStamp(sources, settings); err != nil {
+			return cli.Decode(fmt.Errorf("reading input: %w", err))
+		}
+		// Die Palettenvorschau ist eine weitere Ausgabe
+		if *palettePreview != "" {
+			outputs = append(outputs, *palettePreview)
+		}
+		if convert.UpToDate(stamp, outputs...) {
+			if !*quiet {
+				fmt.Println("Skipped, up to date:", strings.Join(outputs, ", "))
+			}
+			return nil
+		}
+	}
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			oldColor := frame.At(x, y)
-			index := imgcolor.NearestColorIndex(palette, oldColor)
-			newFrame.SetColorIndex(x, y, uint8(index))
+	// GIFs werden nur bis -max-frames gelesen, damit riesige Dateien den Speicher nicht füllen;
+	// mit -lenient bleiben die vollständigen Frames einer beschädigten Datei erhalten
+	if _, ok := loader.(convert.GIFLoader); ok && (*maxFrames > 0 || *lenient) {
+		gifLoader := convert.GIFLoader{MaxFrames: *maxFrames, Lenient: *lenient}
+		if !*quiet {
+			gifLoader.Warn = func(err error) {
+				fmt.Fprintln(os.Stderr, "Warning: the GIF is damaged, using the frames before the damage:", err)
+			}
 		}
+		loader = gifLoader
+	}
+	images, delays, err := loader.Load(inputFilename)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("loading image: %w", err))
 	}
 
-	return newFrame
-}
+	// Begrenze und verdünne die Frames, bevor sie weiterverarbeitet werden
+	images, delays = convert.LimitFrames(images, delays, *maxFrames)
+	images, delays = convert.StrideFrames(images, delays, *stride)
 
-// writeSAGFile erstellt die SAG-Datei aus dem übergebenen animierten Bild.
-func writeSAGFile(frames []*image.Paletted, delays []int, palette []color.Color, outputFilename string) error {
-	width := uint16(frames[0].Bounds().Dx())
-	height := uint16(frames[0].Bounds().Dy())
-	frameCount := uint16(len(frames))
-	frameDelay := uint16(delays[0] * 10) // Konvertiert 1/100s GIF-Delay in Millisekunden
-
-	// Erstellen und Initialisieren des Headers
-	var header SAGHeader
-	copy(header.Signature[:], "SAG")
-	header.Version = 0x01
-	header.Width = width
-	header.Height = height
-	header.FrameCount = frameCount
-	header.FrameDelay = frameDelay
-
-	// Speichere die Farbpalette in den Header
-	for i, c := range palette {
-		r, g, b, _ := c.RGBA()
-		header.ColorPalette[i*3] = uint8(r >> 8)
-		header.ColorPalette[i*3+1] = uint8(g >> 8)
-		header.ColorPalette[i*3+2] = uint8(b >> 8)
+	// Beschneide die Frames vor dem Skalieren
+	if *crop != "" {
+		rect, err := convert.ParseRect(*crop)
+		if err == nil {
+			images, err = convert.Crop(images, rect)
+		}
+		if err != nil {
+			return cli.Usage(fmt.Errorf("cropping image: %w", err))
+		}
 	}
 
-	// Datei erstellen
-	file, err := os.Create(outputFilename)
-	if err != nil {
-		return err
+	// Schneide einen einfarbigen Rand ab, der in allen Frames gleich ist
+	if *autotrim {
+		rect := convert.TrimRect(images)
+		if images, err = convert.Crop(images, rect); err != nil {
+			return cli.Decode(fmt.Errorf("trimming border: %w", err))
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Trimmed to %d,%d,%d,%d\n", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+		}
 	}
-	defer file.Close()
 
-	// Header in die Datei schreiben
-	if err := binary.Write(file, binary.BigEndian, header); err != nil {
-		return err
+	// Lege transparente Pixel auf die Hintergrundfarbe, damit die Palette keine Einträge dafür verschwendet
+	if *background != "" {
+		bg, err := convert.ParseColor(*background)
+		if err != nil {
+			return cli.Usage(err)
+		}
+		images = convert.Flatten(images, bg)
+	} else if convert.HasAlpha(images) && !*quiet {
+		fmt.Fprintln(os.Stderr, "Note: the frames have transparent pixels, they are reduced as if over black (see -background)")
 	}
 
-	// Frame-Daten in die Datei schreiben
-	for i, frame := range frames {
-		prevFrame := (*image.Paletted)(nil)
-		if i > 0 {
-			prevFrame = frames[i-1]
-		}
-		for y := 0; y < int(height); y++ {
-			for x := 0; x < int(width); x += 8 {
-				var identicalByte byte = 0
-				var pixelBlock []byte
-
-				for bit := 0; bit < 8; bit++ {
-					if x+bit >= int(width) {
-						break
-					}
-					currentPixel := frame.ColorIndexAt(x+bit, y)
-					if prevFrame != nil && prevFrame.ColorIndexAt(x+bit, y) == currentPixel {
-						identicalByte |= 1 << (7 - bit)
-					}
-					pixelBlock = append(pixelBlock, currentPixel)
+	// Verarbeite die dekodierten Frames für eine Zielgröße und schreibe sie nach outputFilename
+	convertFrames := func(images []image.Image, delays []int, outputFilename string) error {
+		// Skaliere die Frames vor der Farbreduktion, damit die Palette zu den finalen Pixeln passt
+		var scaler draw.Scaler
+		if *width > 0 || *height > 0 {
+			if scaler, err = convert.ScalerByName(*resizeFilter); err != nil {
+				return cli.Usage(err)
+			}
+		}
+
+		// Passe Helligkeit, Kontrast und Sättigung an das Panel an
+		var filters []convert.FrameFilter
+		if *brightness != 0 {
+			filters = append(filters, convert.Brightness(*brightness))
+		}
+		if *contrast != 1 {
+			filters = append(filters, convert.Contrast(*contrast))
+		}
+		if *saturation != 1 {
+			filters = append(filters, convert.Saturation(*saturation))
+		}
+		// Für monochrome Panels nur Graustufen erzeugen, statt sie vom Quantisierer annähern zu lassen
+		if *grayscale != "" {
+			weights, err := convert.ParseLumaWeights(*grayscale)
+			if err != nil {
+				return cli.Usage(err)
+			}
+			filters = append(filters, convert.Grayscale(weights))
+		}
+
+		// Bereite Frames für die Farbreduktion vor; beim Streaming einzeln, sonst alle auf einmal
+		prepare := func(images []image.Image) ([]image.Image, error) {
+			if scaler != nil {
+				images = convert.Resize(images, *width, *height, scaler, *keepAspect)
+			}
+			images = convert.ApplyFilters(images, filters...)
+			// Reduziere jeden Farbkanal auf wenige Stufen für einen Retro-Look
+			if *posterize > 0 {
+				return convert.Posterize(images, *posterize)
+			}
+			return images, nil
+		}
+
+		// Reduziere die Farben der Frames und extrahiere die Palette
+		opts := convert.Options{KMeansIterations: *kmeans}
+		if opts.Reserved, err = convert.ParsePaletteEntries(reserve); err != nil {
+			return cli.Usage(err)
+		}
+		if *linear {
+			*metric = "linear"
+		}
+		if opts.Metric, err = imgcolor.ParseMetric(*metric); err != nil {
+			return cli.Usage(err)
+		}
+		if opts.Dither, err = convert.ParseDither(*dither); err != nil {
+			return cli.Usage(err)
+		}
+		// Stärke 0 heißt ohne Dithering, im Options-Feld stünde 0 für volle Stärke
+		if opts.DitherStrength = *ditherStrength; *ditherStrength == 0 {
+			opts.Dither = convert.DitherNone
+		}
+		if *paletteFile != "" {
+			if opts.Palette, err = imgcolor.LoadPalette(*paletteFile); err != nil {
+				return cli.Decode(fmt.Errorf("loading palette: %w", err))
+			}
+		}
+		// Die reservierten Farben müssen neben der Palette in die 256 Einträge passen
+		if err := opts.Check(); err != nil {
+			return cli.Usage(err)
+		}
+		if *progress {
+			opts.Progress = func(done, total int) {
+				fmt.Fprintf(os.Stderr, "\rframe %d/%d", done, total)
+				if done == total {
+					fmt.Fprintln(os.Stderr)
 				}
+			}
+		}
+		sagOpts := &sag.Options{RowSkip: *rowSkip, BitsPerPixel: *bpp, FrameDelays: *dedupe || *preserveTiming, Pad8: *pad8, Interleaved: *interleave, LocalPalettes: *localPalettes, PaletteSize: *paletteSize, Deflate: *deflate, LittleEndian: *littleEndian, NoDelta: *noDelta, PadIndex: uint8(*padIndex), Title: *title, Metadata: metadata}
+		if *cycle != "" {
+			if sagOpts.CycleStart, sagOpts.CycleCount, sagOpts.CycleDelay, err = parseCycle(*cycle); err != nil {
+				return cli.Usage(err)
+			}
+		}
 
-				file.Write([]byte{identicalByte})
-				file.Write(pixelBlock)
+		// Ohne Optionen, die alle reduzierten Frames brauchen, wird jeder Frame direkt nach
+		// seiner Reduktion geschrieben, statt alle Frames gleichzeitig im Speicher zu halten
+		if !*localPalettes && *maxError < 0 && !*twoPass && !*optimizeDelta && *sortPalette == "" && *palettePreview == "" &&
+			!*pingpong && !*dedupe && *fps == 0 && !*rowSkip && *bpp != 0 && !*estimate && !*verify && !*verbose {
+			frame := func(i int) (image.Image, error) {
+				prepared, err := prepare(images[i : i+1])
+				if err != nil {
+					return nil, err
+				}
+				return prepared[0], nil
+			}
+			if err := writeSAGStream(len(images), frame, delays, opts, sagOpts, outputFilename); err != nil {
+				return cli.Write(fmt.Errorf("creating SAG file: %w", err))
 			}
+			if err := writeStamp(outputFilename, stamp); err != nil {
+				return cli.Write(fmt.Errorf("writing stamp: %w", err))
+			}
+			if !*quiet {
+				fmt.Println("Conversion completed successfully:", outputFilename)
+			}
+			return nil
 		}
-	}
 
-	return nil
-}
+		if images, err = prepare(images); err != nil {
+			return cli.Usage(err)
+		}
+		colorCount := convert.CountColors(images)
 
-func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: gif2sag <input> <output.sag> <format>")
-		fmt.Println("Supported formats: gif, tiff, webp")
-		os.Exit(1)
-	}
+		// Im Zwei-Pass-Modus die Palette aus einer Stichprobe bilden und danach alle Pixel zuordnen
+		if *twoPass && opts.Palette == nil {
+			opts.Palette = convert.BuildPalette(convert.SampleColors(images, 2), opts)
+		}
+		// Wähle mit -max-error die kleinste Palette, deren Fehler unter der Schwelle bleibt
+		var frames []*image.Paletted
+		var palette []color.Color
+		if *localPalettes {
+			// Jeder Frame bekommt seine eigene Palette, in den Header kommt die des ersten
+			frames = convert.ReduceColorsPerFrame(images, opts)
+			palette = frames[0].Palette
+		} else if *maxError >= 0 {
+			result := convert.AdaptiveQuantize(images, colorCount, opts, *maxError)
+			frames, palette = result.Frames, result.Palette
+		} else {
+			frames, palette = convert.ReduceColors(images, colorCount, opts)
+		}
 
-	inputFilename := os.Args[1]
-	outputFilename := os.Args[2]
-	format := os.Args[3]
+		// Die Ähnlichkeit zum Original vor dem Zusammenfassen von Frames messen
+		var ssim []float64
+		if *verbose {
+			ssim = convert.FrameSSIM(images, frames)
+		}
 
-	var loader ImageLoader
+		// Fasse gleich gespeicherte Palettenfarben zusammen, damit mehr Pixel ihren Index behalten
+		if *optimizeDelta {
+			frames, palette = convert.OptimizeDelta(frames, palette)
+		}
 
-	switch format {
-	case "gif":
-		loader = GIFLoader{}
-	case "tiff":
-		loader = TIFFLoader{}
-	case "webp":
-		loader = WebPLoader{}
-	default:
-		fmt.Println("Unsupported format:", format)
-		os.Exit(1)
-	}
+		// Sortiere die Palette für Paletteneffekte der Firmware, die Frames bleiben unverändert
+		if *sortPalette != "" {
+			order, err := convert.ParsePaletteOrder(*sortPalette)
+			if err != nil {
+				return cli.Usage(err)
+			}
+			frames, palette = convert.SortPalette(frames, palette, order)
+		}
 
-	frames, delays, err := loader.Load(inputFilename)
-	if err != nil {
-		fmt.Println("Error loading image:", err)
-		os.Exit(1)
-	}
+		// Die Statistik braucht zu jedem Frame noch seinen Quellframe, also vor -pingpong, -dedupe und -fps
+		var stats convert.Stats
+		if *verbose {
+			stats = convert.NewStats(colorCount, images, frames, palette)
+			stats.FrameSSIM = ssim
+		}
+
+		// Zeige die endgültige Palette als PNG, z.B. zur Abstimmung mit Grafikern
+		if *palettePreview != "" {
+			if err := writePalettePreview(palette, *palettePreview); err != nil {
+				return cli.Write(fmt.Errorf("writing palette preview: %w", err))
+			}
+			if err := writeStamp(*palettePreview, stamp); err != nil {
+				return cli.Write(fmt.Errorf("writing stamp: %w", err))
+			}
+		}
 
-	// Reduziere die Farben der Frames und extrahiere die Palette
-	frames, palette := reduceColors(frames)
+		// Hänge die Frames rückwärts an, damit die Animation vor und zurück läuft
+		if *pingpong {
+			frames, delays = convert.PingPong(frames, delays)
+		}
 
-	// Schreibe die SAG-Datei
-	if err := writeSAGFile(frames, delays, palette, outputFilename); err != nil {
-		fmt.Println("Error creating SAG file:", err)
-		os.Exit(1)
+		// Fasse gleiche aufeinanderfolgende Frames zusammen, ihre Anzeigedauer wird addiert
+		if *dedupe {
+			frames, delays = convert.DedupeFrames(frames, delays)
+		}
+
+		// Taste die Animation mit konstanter Bildrate ab, die Gesamtdauer bleibt erhalten
+		if *fps > 0 {
+			frames, delays = convert.ResampleFPS(frames, delays, *fps)
+		}
+
+		colors := len(palette)
+		if *localPalettes {
+			for _, frame := range frames {
+				colors = max(colors, len(frame.Palette))
+			}
+		}
+		if *bpp == 0 && colors <= 16 {
+			sagOpts.BitsPerPixel = 4
+		}
+
+		// Im Schätzmodus nur die Größe ausgeben, ohne eine Datei anzulegen
+		if *estimate {
+			size, err := sag.EncodedSize(frames, delays, palette, sagOpts)
+			if err != nil {
+				return cli.Write(fmt.Errorf("encoding SAG data: %w", err))
+			}
+			fmt.Println(size)
+			return nil
+		}
+
+		// Schreibe die SAG-Datei
+		if err := writeSAGFile(frames, delays, palette, sagOpts, outputFilename); err != nil {
+			return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+		}
+
+		// Prüfe, ob sich die geschriebene Datei wieder genau so dekodieren lässt
+		if *verify {
+			if err := verifySAGFile(frames, outputFilename); err != nil {
+				return cli.Write(fmt.Errorf("verify failed: %w", err))
+			}
+			if !*quiet {
+				fmt.Println("Verify OK")
+			}
+		}
+
+		// Erst nach erfolgreichem Verify gilt die Ausgabe als aktuell
+		if err := writeStamp(outputFilename, stamp); err != nil {
+			return cli.Write(fmt.Errorf("writing stamp: %w", err))
+		}
+
+		// Statistiken nur auf Wunsch ausgeben, damit die Standardausgabe ruhig bleibt
+		if *verbose {
+			if info, err := os.Stat(outputFilename); err == nil {
+				stats.FileSize = info.Size()
+			}
+			if *deflate {
+				plain := *sagOpts
+				plain.Deflate = false
+				if size, err := sag.EncodedSize(frames, delays, palette, &plain); err == nil {
+					stats.UncompressedSize = size
+				}
+			}
+			stats.Print(os.Stderr)
+		}
+
+		if !*quiet {
+			fmt.Println("Conversion completed successfully:", outputFilename)
+		}
+		return nil
 	}
 
-	fmt.Println("Conversion completed successfully:", outputFilename)
+	// Alle Zielgrößen werden aus denselben dekodierten Frames erzeugt und bleiben so synchron;
+	// ein Fehler bricht die übrigen Größen nicht ab, der Exit-Code fasst alle zusammen
+	if *sizes != "" {
+		var errs []error
+		for _, size := range targets {
+			*width, *height = size.X, size.Y
+			filename := convert.SizedFilename(*outPrefix, size)
+			if err := convertFrames(images, delays, filename); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+			}
+		}
+		return cli.Join(errs...)
+	}
+	return convertFrames(images, delays, outputFilename)
 }
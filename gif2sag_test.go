@@ -0,0 +1,131 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"./cli"
+	"./cli/clitest"
+)
+
+// TestRunExitCodes checks the exit codes of gif2sag for valid and invalid
+// arguments and for an input file that does not exist.
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	// Eine volle Palette lässt keinen Platz für reservierte Farben
+	fullPalette := filepath.Join(dir, "full.gpl")
+	gpl := "GIMP Palette\n"
+	for i := 0; i < 256; i++ {
+		gpl += strconv.Itoa(i) + " 0 0\n"
+	}
+	if err := os.WriteFile(fullPalette, []byte(gpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{"-quiet", "-width", "16", "-height", "16", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitOK},
+		{"valid sizes", []string{"-quiet", "-sizes", "8x8,16x16", "-out-prefix", filepath.Join(dir, "sized"), "imgcolor/example.gif", "gif"}, cli.ExitOK},
+		{"verbose pingpong crop", []string{"-quiet", "-verbose", "-pingpong", "-crop", "2,2,8,8", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitOK},
+		{"verbose fps", []string{"-quiet", "-verbose", "-fps", "50", "-width", "16", "-height", "16", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitOK},
+		{"missing format", []string{"-quiet", "imgcolor/example.gif", filepath.Join(dir, "out.sag")}, cli.ExitUsage},
+		{"invalid dither strength", []string{"-quiet", "-dither-strength", "2", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitUsage},
+		{"invalid pad index", []string{"-quiet", "-pad8", "-pad-index", "300", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitUsage},
+		{"reserve with full palette", []string{"-quiet", "-palette", fullPalette, "-reserve", "0=255,255,255", "imgcolor/example.gif", filepath.Join(dir, "out.sag"), "gif"}, cli.ExitUsage},
+		{"missing input", []string{"-quiet", missing, filepath.Join(dir, "out.sag"), "gif"}, cli.ExitDecode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, output := clitest.Run(t, run, test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\n%s", code, test.code, output)
+			}
+		})
+	}
+}
+
+// TestRunSizesContinuesAfterFailure checks that a -sizes batch writes the
+// remaining sizes after one of them failed and still reports the failure.
+func TestRunSizesContinuesAfterFailure(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "sized")
+	// Ein Verzeichnis an der Stelle der 4x4-Datei lässt nur diese Größe scheitern
+	if err := os.Mkdir(prefix+"_4x4.sag", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	code, output := clitest.Run(t, run, "-quiet", "-sizes", "4x4,8x8", "-out-prefix", prefix, "imgcolor/example.gif", "gif")
+	if code != cli.ExitWrite {
+		t.Errorf("exit code %d, want %d\n%s", code, cli.ExitWrite, output)
+	}
+	if _, err := os.Stat(prefix + "_8x8.sag"); err != nil {
+		t.Errorf("8x8 after failed 4x4: %v", err)
+	}
+}
+
+// TestRunEstimateTransparent checks that -estimate prints only the size, the
+// note about transparent pixels must not end up in the machine-readable output.
+func TestRunEstimateTransparent(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "transparent.gif")
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Transparent, color.White})
+	frame.Pix[0] = 1
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(file, frame, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	code, output := clitest.Run(t, run, "-estimate", input, "out.sag", "gif")
+	if code != cli.ExitOK {
+		t.Fatalf("exit code %d, want %d\n%s", code, cli.ExitOK, output)
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(output)); err != nil {
+		t.Errorf("output %q is not a size", output)
+	}
+}
+
+// TestRunSkipUnchanged checks that only -skip-unchanged writes stamps and skips
+// a conversion, and that a changed output is converted again.
+func TestRunSkipUnchanged(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.sag")
+
+	if code, out := clitest.Run(t, run, "-width", "8", "-height", "8", "imgcolor/example.gif", output, "gif"); code != cli.ExitOK {
+		t.Fatalf("exit code %d, want %d\n%s", code, cli.ExitOK, out)
+	}
+	if _, err := os.Stat(output + ".stamp"); !os.IsNotExist(err) {
+		t.Errorf("stamp without -skip-unchanged: %v", err)
+	}
+
+	skipped := func() bool {
+		code, out := clitest.Run(t, run, "-skip-unchanged", "-width", "8", "-height", "8", "imgcolor/example.gif", output, "gif")
+		if code != cli.ExitOK {
+			t.Fatalf("exit code %d, want %d\n%s", code, cli.ExitOK, out)
+		}
+		return strings.HasPrefix(out, "Skipped")
+	}
+	if skipped() {
+		t.Error("first run with -skip-unchanged skipped the conversion")
+	}
+	if !skipped() {
+		t.Error("second run with -skip-unchanged converted again")
+	}
+	if err := os.WriteFile(output, []byte("SAG"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if skipped() {
+		t.Error("changed output was skipped")
+	}
+}
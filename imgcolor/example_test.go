@@ -5,30 +5,8 @@ import (
 	"image/color"
 	"log"
 	"os"
-	"sort"
 )
 
-// sortColors sorts the colors by count descending, then by RGBA components (R, G, B, A) ascending
-func sortColors(colors []ColorCount) {
-	sort.SliceStable(colors, func(i, j int) bool {
-		if colors[i].Count == colors[j].Count {
-			r1, g1, b1, a1 := colors[i].Color.RGBA()
-			r2, g2, b2, a2 := colors[j].Color.RGBA()
-			if r1 != r2 {
-				return r1 < r2
-			}
-			if g1 != g2 {
-				return g1 < g2
-			}
-			if b1 != b2 {
-				return b1 < b2
-			}
-			return a1 < a2
-		}
-		return colors[i].Count > colors[j].Count
-	})
-}
-
 // Example demonstrates how to use the imgcolor package to analyze an example GIF file.
 func Example() {
 	// Open the example.gif file
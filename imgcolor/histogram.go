@@ -0,0 +1,67 @@
+package imgcolor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"image/color"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// HistogramEntry is the number of pixels of one 8-bit RGB color.
+type HistogramEntry struct {
+	R     uint8 `json:"r"`
+	G     uint8 `json:"g"`
+	B     uint8 `json:"b"`
+	Count int   `json:"count"`
+}
+
+// Histogram returns the colors of colorCount sorted by count, most frequent
+// first. Colors of different color models with the same 8-bit RGB value are
+// merged into one entry, so the counts still add up to the number of pixels.
+func Histogram(colorCount map[color.Color]int) []HistogramEntry {
+	colorCount = normalizeCounts(colorCount)
+	var entries []HistogramEntry
+	index := make(map[[3]uint8]int)
+	for _, c := range ExtractPalette(colorCount, -1) {
+		r, g, b, _ := c.RGBA()
+		key := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+		if i, ok := index[key]; ok {
+			entries[i].Count += colorCount[c]
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, HistogramEntry{R: key[0], G: key[1], B: key[2], Count: colorCount[c]})
+	}
+
+	// Merging may have changed the order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+	return entries
+}
+
+// WriteHistogramCSV writes the histogram as CSV with the columns r,g,b,count.
+func WriteHistogramCSV(w io.Writer, entries []HistogramEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"r", "g", "b", "count"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(int(e.R)),
+			strconv.Itoa(int(e.G)),
+			strconv.Itoa(int(e.B)),
+			strconv.Itoa(e.Count),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHistogramJSON writes the histogram as a JSON array of {r, g, b, count} objects.
+func WriteHistogramJSON(w io.Writer, entries []HistogramEntry) error {
+	if entries == nil {
+		entries = []HistogramEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
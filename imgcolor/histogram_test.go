@@ -0,0 +1,82 @@
+package imgcolor
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHistogramSumsToPixelCount(t *testing.T) {
+	file, err := os.Open("example.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	colorCount, err := CountColors(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CountColors decodes only the first frame of the GIF
+	file.Seek(0, 0)
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := Histogram(colorCount)
+	sum := 0
+	for i, e := range entries {
+		sum += e.Count
+		if i > 0 && e.Count > entries[i-1].Count {
+			t.Errorf("entry %d (%d) is more frequent than entry %d (%d)", i, e.Count, i-1, entries[i-1].Count)
+		}
+	}
+	if want := config.Width * config.Height; sum != want {
+		t.Errorf("histogram sums to %d, want %d pixels", sum, want)
+	}
+}
+
+func TestHistogramMergesColorModels(t *testing.T) {
+	colorCount := map[color.Color]int{
+		color.RGBA{R: 255, A: 255}:  3,
+		color.NRGBA{R: 255, A: 255}: 2,
+		color.RGBA{B: 255, A: 255}:  4,
+	}
+
+	entries := Histogram(colorCount)
+	want := []HistogramEntry{{R: 255, Count: 5}, {B: 255, Count: 4}}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	var csvOut bytes.Buffer
+	if err := WriteHistogramCSV(&csvOut, entries); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := csvOut.String(), "r,g,b,count\n255,0,0,5\n0,0,255,4\n"; got != want {
+		t.Errorf("CSV = %q, want %q", got, want)
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteHistogramJSON(&jsonOut, entries); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []HistogramEntry
+	if err := json.NewDecoder(strings.NewReader(jsonOut.String())).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0] != want[0] {
+		t.Errorf("JSON round trip = %+v", decoded)
+	}
+}
@@ -32,19 +32,63 @@ func CountColors(r io.Reader) (map[color.Color]int, error) {
 	return colorCount, nil
 }
 
-// CountColorsInImage counts the colors in a static image.
+// CountColorsInImage counts the colors in a static image. Every color is
+// stored as color.RGBA, so equal colors count as one, no matter which color
+// type the image uses (e.g. color.NRGBA or color.RGBA64).
 func CountColorsInImage(img image.Image, colorCount map[color.Color]int) {
 	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			colorCount[normalize(img.At(x, y))]++
+		}
+	}
+}
+
+// normalize returns c as color.RGBA, the canonical key of color counts.
+func normalize(c color.Color) color.Color {
+	if rgba, ok := c.(color.RGBA); ok {
+		return rgba
+	}
+	return color.RGBAModel.Convert(c)
+}
+
+// CountColorsOptions controls how CountColorsNormalized counts colors.
+type CountColorsOptions struct {
+	IgnoreAlpha bool // Count colors by their RGB value only, as if they were opaque
+}
+
+// CountColorsNormalized counts the colors in an image like CountColorsInImage.
+// With opts.IgnoreAlpha the key is the unpremultiplied color made opaque, so
+// colors differing only in alpha collide.
+func CountColorsNormalized(img image.Image, colorCount map[color.Color]int, opts CountColorsOptions) {
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			c := img.At(x, y)
-			colorCount[c]++
+			var key color.RGBA
+			if opts.IgnoreAlpha {
+				n := color.NRGBAModel.Convert(c).(color.NRGBA)
+				key = color.RGBA{R: n.R, G: n.G, B: n.B, A: 0xff}
+			} else {
+				key = color.RGBAModel.Convert(c).(color.RGBA)
+			}
+			colorCount[key]++
 		}
 	}
 }
 
+// normalizeCounts returns the color count with every color as color.RGBA,
+// the counts of colors of different types with the same value are added up.
+func normalizeCounts(colorCount map[color.Color]int) map[color.Color]int {
+	merged := make(map[color.Color]int, len(colorCount))
+	for c, count := range colorCount {
+		merged[normalize(c)] += count
+	}
+	return merged
+}
+
 // CountColorsInGIF counts the colors in an animated GIF.
 func CountColorsInGIF(gifImage *gif.GIF) map[color.Color]int {
 	colorCount := make(map[color.Color]int)
@@ -60,15 +104,15 @@ func CountColorsInGIF(gifImage *gif.GIF) map[color.Color]int {
 // If maxColors == -1, it returns all colors.
 func ExtractPalette(colorCount map[color.Color]int, maxColors int) []color.Color {
 	// Convert the colorCount map to a slice of ColorCount
-	colors := make([]ColorCount, 0, len(colorCount))
-	for c, count := range colorCount {
+	// The count may not come from CountColorsInImage
+	merged := normalizeCounts(colorCount)
+	colors := make([]ColorCount, 0, len(merged))
+	for c, count := range merged {
 		colors = append(colors, ColorCount{Color: c, Count: count})
 	}
 
-	// Sort colors by frequency
-	sort.Slice(colors, func(i, j int) bool {
-		return colors[i].Count > colors[j].Count
-	})
+	// Sort colors by frequency, ties are broken by their RGBA components
+	sortColors(colors)
 
 	// Determine the number of colors to return
 	if maxColors == -1 || maxColors > len(colors) {
@@ -84,20 +128,55 @@ func ExtractPalette(colorCount map[color.Color]int, maxColors int) []color.Color
 	return palette
 }
 
+// sortColors sorts the colors by count descending, then by RGBA components (R, G, B, A) ascending.
+// The tie-break makes the order independent of the map iteration order.
+func sortColors(colors []ColorCount) {
+	sort.SliceStable(colors, func(i, j int) bool {
+		if colors[i].Count == colors[j].Count {
+			r1, g1, b1, a1 := colors[i].Color.RGBA()
+			r2, g2, b2, a2 := colors[j].Color.RGBA()
+			if r1 != r2 {
+				return r1 < r2
+			}
+			if g1 != g2 {
+				return g1 < g2
+			}
+			if b1 != b2 {
+				return b1 < b2
+			}
+			return a1 < a2
+		}
+		return colors[i].Count > colors[j].Count
+	})
+}
+
+// ToColorPalette returns the colors as a color.Palette, e.g. for
+// image.NewPaletted. The palette shares the colors with the slice. Its Index
+// method compares all four 16-bit channels and can pick another entry than
+// NearestColorIndex, which the conversion uses.
+func ToColorPalette(colors []color.Color) color.Palette {
+	return color.Palette(colors)
+}
+
 // NearestColorIndex returns the index of the closest matching color in a palette.
 func NearestColorIndex(palette []color.Color, targetColor color.Color) int {
-	minDist := int(^uint(0) >> 1) // Maximum int value
-	minIndex := 0
-
-	for i, p := range palette {
-		dist := colorDistanceSquared(targetColor, p)
-		if dist < minDist {
-			minDist = dist
-			minIndex = i
-		}
-	}
+	return RGB.NearestColorIndex(palette, targetColor)
+}
 
-	return minIndex
+// NearestColorIndexWeighted returns the index of the closest matching color in
+// a palette, weighting the color components by the sensitivity of the eye.
+func NearestColorIndexWeighted(palette []color.Color, targetColor color.Color) int {
+	return Weighted.NearestColorIndex(palette, targetColor)
+}
+
+// QuantizationError returns the total squared distance between every counted
+// color and its nearest palette color, weighted by the number of pixels.
+func QuantizationError(colorCount map[color.Color]int, palette []color.Color) int {
+	total := 0
+	for c, count := range colorCount {
+		total += colorDistanceSquared(c, palette[NearestColorIndex(palette, c)]) * count
+	}
+	return total
 }
 
 // colorDistanceSquared calculates the squared distance between two colors.
@@ -0,0 +1,140 @@
+package imgcolor
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestExtractPaletteDeterministic(t *testing.T) {
+	// Many colors with the same count, so the order depends only on the tie-break
+	colorCount := make(map[color.Color]int)
+	for i := 0; i < 64; i++ {
+		colorCount[color.RGBA{R: uint8(i * 4), G: uint8(255 - i), B: uint8(i % 7), A: 255}] = 3
+	}
+	colorCount[color.RGBA{A: 255}] = 10
+
+	first := ExtractPalette(colorCount, 32)
+	for run := 0; run < 20; run++ {
+		if got := ExtractPalette(colorCount, 32); !reflect.DeepEqual(got, first) {
+			t.Fatalf("run %d: palette differs:\n%v\nwant\n%v", run, got, first)
+		}
+	}
+
+	if first[0] != (color.RGBA{A: 255}) {
+		t.Errorf("palette[0] = %v, want the most frequent color", first[0])
+	}
+	if first[1] != (color.RGBA{R: 0, G: 255, B: 0, A: 255}) {
+		t.Errorf("palette[1] = %v, want the lowest RGBA among equal counts", first[1])
+	}
+}
+
+func TestCountColorsNormalized(t *testing.T) {
+	// The same opaque red stored as RGBA, NRGBA and RGBA64
+	rgba := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	rgba.Set(0, 0, color.RGBA{R: 255, A: 255})
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	nrgba.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	rgba64 := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	rgba64.Set(0, 0, color.RGBA64{R: 0xffff, A: 0xffff})
+
+	colorCount := make(map[color.Color]int)
+	for _, img := range []image.Image{rgba, nrgba, rgba64} {
+		CountColorsNormalized(img, colorCount, CountColorsOptions{})
+	}
+	if len(colorCount) != 1 || colorCount[color.RGBA{R: 255, A: 255}] != 3 {
+		t.Errorf("colorCount = %v, want one red with count 3", colorCount)
+	}
+}
+
+func TestCountColorsInImageMixedTypes(t *testing.T) {
+	// Half-transparent red, premultiplied in RGBA and unpremultiplied in NRGBA
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgba.SetRGBA(0, 0, color.RGBA{R: 128, A: 128})
+	rgba.SetRGBA(1, 0, color.RGBA{R: 128, A: 128})
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 128})
+
+	colorCount := make(map[color.Color]int)
+	CountColorsInImage(rgba, colorCount)
+	CountColorsInImage(nrgba, colorCount)
+	if len(colorCount) != 1 || colorCount[color.RGBA{R: 128, A: 128}] != 3 {
+		t.Errorf("colorCount = %v, want one color with count 3", colorCount)
+	}
+
+	// Counts built elsewhere are merged when the palette is extracted
+	mixed := map[color.Color]int{
+		color.NRGBA{R: 255, A: 128}: 2,
+		color.RGBA{R: 128, A: 128}:  2,
+		color.RGBA{G: 255, A: 255}:  3,
+	}
+	if got := ExtractPalette(mixed, 1); len(got) != 1 || got[0] != color.Color(color.RGBA{R: 128, A: 128}) {
+		t.Errorf("ExtractPalette = %v, want the merged red first", got)
+	}
+}
+
+func TestCountColorsIgnoreAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{G: 200, A: 255})
+	img.Set(1, 0, color.NRGBA{G: 200, A: 100})
+
+	withAlpha := make(map[color.Color]int)
+	CountColorsNormalized(img, withAlpha, CountColorsOptions{})
+	if len(withAlpha) != 2 {
+		t.Errorf("got %d colors with alpha, want 2", len(withAlpha))
+	}
+
+	ignored := make(map[color.Color]int)
+	CountColorsNormalized(img, ignored, CountColorsOptions{IgnoreAlpha: true})
+	if len(ignored) != 1 || ignored[color.RGBA{G: 200, A: 255}] != 2 {
+		t.Errorf("colorCount ignoring alpha = %v, want one green with count 2", ignored)
+	}
+}
+
+func BenchmarkNearestColorIndex(b *testing.B) {
+	// A full palette of 256 colors and targets that fall between its entries
+	palette := make([]color.Color, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i%16) * 17, G: uint8(i/16) * 17, B: uint8(i * 7), A: 255}
+	}
+	targets := make([]color.Color, 1024)
+	for i := range targets {
+		targets[i] = color.RGBA{R: uint8(i * 13), G: uint8(i * 29), B: uint8(i * 3), A: 255}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		NearestColorIndex(palette, targets[n%len(targets)])
+	}
+}
+
+func TestNearestColorIndexAllocs(t *testing.T) {
+	// The lookup runs once per pixel, it must not allocate
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	var target color.Color = color.RGBA{R: 200, B: 40, A: 255}
+	if allocs := testing.AllocsPerRun(100, func() { NearestColorIndex(palette, target) }); allocs != 0 {
+		t.Errorf("NearestColorIndex allocates %v times per call, want 0", allocs)
+	}
+}
+
+func TestToColorPalette(t *testing.T) {
+	colorCount := make(map[color.Color]int)
+	for i := 0; i < 200; i++ {
+		colorCount[color.RGBA{R: uint8(i * 37), G: uint8(i * 11), B: uint8(255 - i), A: 255}] = i%5 + 1
+	}
+	palette := ExtractPalette(colorCount, 16)
+	cp := ToColorPalette(palette)
+	if len(cp) != len(palette) {
+		t.Fatalf("%d colors, want %d", len(cp), len(palette))
+	}
+	// Every entry is found at its own index, like with NearestColorIndex
+	for i, c := range palette {
+		if cp[i] != c {
+			t.Errorf("entry %d = %v, want %v", i, cp[i], c)
+		}
+		if got, want := cp.Index(c), NearestColorIndex(palette, c); got != i || want != i {
+			t.Errorf("Index(%v) = %d and NearestColorIndex = %d, want %d", c, got, want, i)
+		}
+	}
+}
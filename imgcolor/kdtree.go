@@ -0,0 +1,97 @@
+package imgcolor
+
+import (
+	"image/color"
+	"sort"
+)
+
+// PaletteKDTree indexes the 8-bit RGB points of a palette in a k-d tree, so
+// the nearest entry under the RGB metric is found in about O(log n) instead
+// of comparing against every entry.
+type PaletteKDTree struct {
+	nodes []kdNode // Nodes in build order, nodes[0] is the root
+}
+
+// kdNode is a palette entry that splits its subtree along one axis.
+type kdNode struct {
+	point       [3]int // 8-bit R, G, B
+	index       int    // Index in the palette
+	axis        int    // 0 = R, 1 = G, 2 = B
+	left, right int    // Child nodes, -1 for none
+}
+
+// NewPaletteKDTree builds the k-d tree for a palette. The palette must not be
+// changed afterwards.
+func NewPaletteKDTree(palette []color.Color) *PaletteKDTree {
+	points := make([]kdNode, len(palette))
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		points[i] = kdNode{point: [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}, index: i}
+	}
+	t := &PaletteKDTree{nodes: make([]kdNode, 0, len(palette))}
+	t.build(points, 0)
+	return t
+}
+
+// build adds the points as a subtree split along axis and returns its root
+// node, or -1 if there are no points.
+func (t *PaletteKDTree) build(points []kdNode, axis int) int {
+	if len(points) == 0 {
+		return -1
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].point[axis] != points[j].point[axis] {
+			return points[i].point[axis] < points[j].point[axis]
+		}
+		return points[i].index < points[j].index
+	})
+	median := len(points) / 2
+
+	n := len(t.nodes)
+	node := points[median]
+	node.axis = axis
+	t.nodes = append(t.nodes, node)
+	next := (axis + 1) % 3
+	left := t.build(points[:median], next)
+	right := t.build(points[median+1:], next)
+	t.nodes[n].left, t.nodes[n].right = left, right
+	return n
+}
+
+// Nearest returns the palette index of the entry closest to c under the RGB
+// metric. Like RGB.NearestColorIndex, ties go to the lowest index. It returns
+// 0 for an empty palette.
+func (t *PaletteKDTree) Nearest(c color.Color) int {
+	if len(t.nodes) == 0 {
+		return 0
+	}
+	r, g, b, _ := c.RGBA()
+	target := [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+	best, bestDist := -1, int(^uint(0)>>1)
+	t.search(0, target, &best, &bestDist)
+	return best
+}
+
+// search descends into the subtree at node, updating the best index and its
+// squared distance.
+func (t *PaletteKDTree) search(node int, target [3]int, best, bestDist *int) {
+	if node < 0 {
+		return
+	}
+	n := &t.nodes[node]
+	dr, dg, db := target[0]-n.point[0], target[1]-n.point[1], target[2]-n.point[2]
+	if dist := dr*dr + dg*dg + db*db; dist < *bestDist || dist == *bestDist && n.index < *best {
+		*best, *bestDist = n.index, dist
+	}
+
+	diff := target[n.axis] - n.point[n.axis]
+	near, far := n.left, n.right
+	if diff >= 0 {
+		near, far = n.right, n.left
+	}
+	t.search(near, target, best, bestDist)
+	// Equal distances on the far side may still win the tie-break
+	if diff*diff <= *bestDist {
+		t.search(far, target, best, bestDist)
+	}
+}
@@ -0,0 +1,43 @@
+package imgcolor
+
+import (
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestPaletteKDTreeMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 2, 16, 200, 256} {
+		palette := make([]color.Color, size)
+		for i := range palette {
+			// Few distinct levels, so duplicates and ties are common
+			palette[i] = color.RGBA{R: uint8(rng.Intn(8) * 36), G: uint8(rng.Intn(8) * 36), B: uint8(rng.Intn(4) * 85), A: 255}
+		}
+		tree := NewPaletteKDTree(palette)
+
+		for q := 0; q < 5000; q++ {
+			target := color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			if got, want := tree.Nearest(target), RGB.NearestColorIndex(palette, target); got != want {
+				t.Fatalf("%d colors: Nearest(%v) = %d, linear scan = %d", size, target, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkPaletteKDTreeNearest(b *testing.B) {
+	palette := make([]color.Color, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i%16) * 17, G: uint8(i/16) * 17, B: uint8(i * 7), A: 255}
+	}
+	tree := NewPaletteKDTree(palette)
+	targets := make([]color.Color, 1024)
+	for i := range targets {
+		targets[i] = color.RGBA{R: uint8(i * 13), G: uint8(i * 29), B: uint8(i * 3), A: 255}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tree.Nearest(targets[n%len(targets)])
+	}
+}
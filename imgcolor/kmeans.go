@@ -0,0 +1,67 @@
+package imgcolor
+
+import "image/color"
+
+// RefinePaletteKMeans improves an initial palette with k-means iterations.
+// Every color is assigned to its nearest palette entry, then each entry is moved
+// to the (count-weighted) centroid of its assigned colors. Entries without any
+// assigned colors are kept as they are. The input palette is not modified.
+func RefinePaletteKMeans(colorCount map[color.Color]int, palette []color.Color, iterations int) []color.Color {
+	return RGB.RefinePaletteKMeans(colorCount, palette, iterations)
+}
+
+// RefinePaletteKMeans is like the package-level RefinePaletteKMeans, but assigns
+// the colors under the metric. For Linear the centroids are averaged in linear light.
+func (m Metric) RefinePaletteKMeans(colorCount map[color.Color]int, palette []color.Color, iterations int) []color.Color {
+	refined := make([]color.Color, len(palette))
+	copy(refined, palette)
+
+	type centroid struct {
+		r, g, b, n int
+	}
+
+	// component converts an 8-bit sRGB value into the space the centroids are averaged in
+	component := func(v uint32) int { return int(v >> 8) }
+	toSRGB := func(v int) uint8 { return uint8(v) }
+	if m == Linear {
+		component = func(v uint32) int { return int(linearize(uint8(v >> 8))) }
+		toSRGB = func(v int) uint8 { return delinearize(uint16(v)) }
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		sums := make([]centroid, len(refined))
+		for c, count := range colorCount {
+			index := m.NearestColorIndex(refined, c)
+			r, g, b, _ := c.RGBA()
+			sums[index].r += component(r) * count
+			sums[index].g += component(g) * count
+			sums[index].b += component(b) * count
+			sums[index].n += count
+		}
+
+		changed := false
+		for i, s := range sums {
+			if s.n == 0 {
+				continue
+			}
+			// Round to the nearest integer centroid
+			c := color.RGBA{
+				R: toSRGB((s.r + s.n/2) / s.n),
+				G: toSRGB((s.g + s.n/2) / s.n),
+				B: toSRGB((s.b + s.n/2) / s.n),
+				A: 0xff,
+			}
+			if c != refined[i] {
+				refined[i] = c
+				changed = true
+			}
+		}
+
+		// Stop early once the palette has converged
+		if !changed {
+			break
+		}
+	}
+
+	return refined
+}
@@ -0,0 +1,55 @@
+package imgcolor
+
+import (
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestRefinePaletteKMeans(t *testing.T) {
+	file, err := os.Open("example.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	colorCount, err := CountColors(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, maxColors := range []int{2, 8, 16} {
+		palette := ExtractPalette(colorCount, maxColors)
+		refined := RefinePaletteKMeans(colorCount, palette, 5)
+
+		if len(refined) != len(palette) {
+			t.Fatalf("maxColors %d: refined palette has %d colors, want %d", maxColors, len(refined), len(palette))
+		}
+
+		before, after := QuantizationError(colorCount, palette), QuantizationError(colorCount, refined)
+		if after > before {
+			t.Errorf("maxColors %d: error after refinement %d > before %d", maxColors, after, before)
+		}
+	}
+}
+
+func TestRefinePaletteKMeansCentroid(t *testing.T) {
+	colorCount := map[color.Color]int{
+		color.RGBA{R: 10, A: 255}:  1,
+		color.RGBA{R: 30, A: 255}:  1,
+		color.RGBA{B: 200, A: 255}: 2,
+	}
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{B: 255, A: 255}}
+
+	refined := RefinePaletteKMeans(colorCount, palette, 10)
+
+	want := []color.Color{color.RGBA{R: 20, A: 255}, color.RGBA{B: 200, A: 255}}
+	for i := range want {
+		if refined[i] != want[i] {
+			t.Errorf("refined[%d] = %v, want %v", i, refined[i], want[i])
+		}
+	}
+	if palette[0] != (color.RGBA{A: 255}) {
+		t.Errorf("input palette was modified: %v", palette[0])
+	}
+}
@@ -0,0 +1,168 @@
+package imgcolor
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Metric selects how the distance between two colors is measured.
+type Metric int
+
+const (
+	// RGB measures the Euclidean distance of the sRGB components.
+	RGB Metric = iota
+	// Linear measures the Euclidean distance in linear light, which does not
+	// over-weight differences between dark colors like sRGB does.
+	Linear
+	// Weighted weights the squared sRGB differences by the sensitivity of the
+	// eye (2/4/3 for R/G/B). It is cheaper than Lab and better than plain RGB.
+	Weighted
+	// Lab measures the Euclidean distance (ΔE 1976) in the CIE L*a*b* color space.
+	Lab
+)
+
+// metricNames maps the names accepted by ParseMetric to the metrics.
+var metricNames = map[string]Metric{
+	"rgb":      RGB,
+	"linear":   Linear,
+	"weighted": Weighted,
+	"lab":      Lab,
+}
+
+// ParseMetric returns the metric with the given name: rgb, linear, weighted or lab.
+func ParseMetric(name string) (Metric, error) {
+	m, ok := metricNames[name]
+	if !ok {
+		return RGB, fmt.Errorf("unknown color metric %q", name)
+	}
+	return m, nil
+}
+
+// String returns the name of the metric as accepted by ParseMetric.
+func (m Metric) String() string {
+	for name, metric := range metricNames {
+		if metric == m {
+			return name
+		}
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// linearTable maps 8-bit sRGB values to 16-bit linear light values.
+var linearTable = func() (table [256]uint16) {
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		table[i] = uint16(math.Round(v * 0xffff))
+	}
+	return table
+}()
+
+// linearize converts an 8-bit sRGB value to a 16-bit linear light value.
+func linearize(v uint8) uint16 {
+	return linearTable[v]
+}
+
+// delinearize converts a 16-bit linear light value back to an 8-bit sRGB value.
+func delinearize(v uint16) uint8 {
+	l := float64(v) / 0xffff
+	if l <= 0.0031308 {
+		l *= 12.92
+	} else {
+		l = 1.055*math.Pow(l, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(l * 255))
+}
+
+// Distance returns the squared distance between two colors under the metric.
+func (m Metric) Distance(c1, c2 color.Color) int {
+	switch m {
+	case Linear:
+		return colorDistanceLinear(c1, c2)
+	case Weighted:
+		return colorDistanceWeighted(c1, c2)
+	case Lab:
+		return colorDistanceLab(c1, c2)
+	}
+	return colorDistanceSquared(c1, c2)
+}
+
+// colorDistanceLinear calculates the squared distance between two colors in linear light.
+func colorDistanceLinear(c1, c2 color.Color) int {
+	r1, g1, b1, _ := c1.RGBA()
+	r2, g2, b2, _ := c2.RGBA()
+
+	rd := int(linearize(uint8(r1>>8))) - int(linearize(uint8(r2>>8)))
+	gd := int(linearize(uint8(g1>>8))) - int(linearize(uint8(g2>>8)))
+	bd := int(linearize(uint8(b1>>8))) - int(linearize(uint8(b2>>8)))
+
+	return rd*rd + gd*gd + bd*bd
+}
+
+// colorDistanceWeighted calculates the squared distance between two colors with
+// the R/G/B differences weighted 2/4/3, using integer math only.
+func colorDistanceWeighted(c1, c2 color.Color) int {
+	r1, g1, b1, _ := c1.RGBA()
+	r2, g2, b2, _ := c2.RGBA()
+
+	rd := int(r1>>8) - int(r2>>8)
+	gd := int(g1>>8) - int(g2>>8)
+	bd := int(b1>>8) - int(b2>>8)
+
+	return 2*rd*rd + 4*gd*gd + 3*bd*bd
+}
+
+// colorDistanceLab calculates the squared ΔE 1976 distance between two colors,
+// scaled by 100 so that small differences survive the conversion to int.
+func colorDistanceLab(c1, c2 color.Color) int {
+	l1, a1, b1 := toLab(c1)
+	l2, a2, b2 := toLab(c2)
+
+	ld, ad, bd := l1-l2, a1-a2, b1-b2
+
+	return int(math.Round((ld*ld + ad*ad + bd*bd) * 100))
+}
+
+// toLab converts a color to CIE L*a*b* under the D65 white point.
+func toLab(c color.Color) (l, a, b float64) {
+	r, g, bl, _ := c.RGBA()
+	rl := float64(linearize(uint8(r>>8))) / 0xffff
+	gl := float64(linearize(uint8(g>>8))) / 0xffff
+	bll := float64(linearize(uint8(bl>>8))) / 0xffff
+
+	// Linear sRGB to XYZ, normalized by the D65 white point
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bll) / 0.95047
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bll
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bll) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// NearestColorIndex returns the index of the closest matching color in a palette under the metric.
+func (m Metric) NearestColorIndex(palette []color.Color, targetColor color.Color) int {
+	minDist := int(^uint(0) >> 1) // Maximum int value
+	minIndex := 0
+
+	for i, p := range palette {
+		dist := m.Distance(targetColor, p)
+		if dist < minDist {
+			minDist = dist
+			minIndex = i
+		}
+	}
+
+	return minIndex
+}
@@ -0,0 +1,88 @@
+package imgcolor
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestLinearizeRoundTrip(t *testing.T) {
+	for v := 0; v < 256; v++ {
+		if got := delinearize(linearize(uint8(v))); got != uint8(v) {
+			t.Errorf("delinearize(linearize(%d)) = %d", v, got)
+		}
+	}
+	if linearize(0) != 0 || linearize(255) != 0xffff {
+		t.Errorf("linearize range = %d..%d, want 0..65535", linearize(0), linearize(255))
+	}
+}
+
+func TestLinearMetricMidTone(t *testing.T) {
+	palette := []color.Color{color.Gray{Y: 100}, color.Gray{Y: 230}}
+	midTone := color.Gray{Y: 170}
+
+	// In sRGB 170 is closer to 230, in linear light it is closer to 100
+	if got := RGB.NearestColorIndex(palette, midTone); got != 1 {
+		t.Errorf("RGB maps the mid-tone to %d, want 1", got)
+	}
+	if got := Linear.NearestColorIndex(palette, midTone); got != 0 {
+		t.Errorf("Linear maps the mid-tone to %d, want 0", got)
+	}
+}
+
+func TestRefinePaletteKMeansLinear(t *testing.T) {
+	colorCount := map[color.Color]int{
+		color.Gray{Y: 0}:   1,
+		color.Gray{Y: 255}: 1,
+	}
+	palette := []color.Color{color.Gray{Y: 128}}
+
+	// The linear average of black and white is 50% light, i.e. sRGB 188
+	refined := Linear.RefinePaletteKMeans(colorCount, palette, 1)
+	if want := (color.RGBA{R: 188, G: 188, B: 188, A: 255}); refined[0] != want {
+		t.Errorf("linear centroid = %v, want %v", refined[0], want)
+	}
+	refined = RGB.RefinePaletteKMeans(colorCount, palette, 1)
+	if want := (color.RGBA{R: 128, G: 128, B: 128, A: 255}); refined[0] != want {
+		t.Errorf("sRGB centroid = %v, want %v", refined[0], want)
+	}
+}
+
+func TestWeightedMetricGreenish(t *testing.T) {
+	// Plain RGB prefers the entry with the smaller (green) difference, the
+	// weighted metric penalizes green differences more and picks the blue one
+	target := color.RGBA{G: 100, A: 255}
+	palette := []color.Color{
+		color.RGBA{G: 130, A: 255},
+		color.RGBA{G: 100, B: 33, A: 255},
+	}
+
+	if got := NearestColorIndex(palette, target); got != 0 {
+		t.Errorf("RGB maps the greenish target to %d, want 0", got)
+	}
+	if got := NearestColorIndexWeighted(palette, target); got != 1 {
+		t.Errorf("weighted maps the greenish target to %d, want 1", got)
+	}
+}
+
+func TestLabMetric(t *testing.T) {
+	if d := Lab.Distance(color.White, color.White); d != 0 {
+		t.Errorf("distance of white to itself = %d, want 0", d)
+	}
+
+	// L* of white is 100 and of black 0, so ΔE² is 10000 (scaled by 100)
+	if d := Lab.Distance(color.White, color.Black); d < 999000 || d > 1001000 {
+		t.Errorf("distance of white to black = %d, want about 1000000", d)
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	for _, m := range []Metric{RGB, Linear, Weighted, Lab} {
+		got, err := ParseMetric(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMetric(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseMetric("hsv"); err == nil {
+		t.Error("ParseMetric(\"hsv\") succeeded, want error")
+	}
+}
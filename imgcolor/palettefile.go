@@ -0,0 +1,113 @@
+package imgcolor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxPaletteColors is the maximum number of colors a loaded palette may have.
+const MaxPaletteColors = 256
+
+// LoadPalette loads a palette from a GIMP (.gpl) or Photoshop (.act) palette file.
+func LoadPalette(filename string) ([]color.Color, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var palette []color.Color
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpl":
+		palette, err = ReadGPL(file)
+	case ".act":
+		palette, err = ReadACT(file)
+	default:
+		return nil, fmt.Errorf("%s: unknown palette format, want .gpl or .act", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return palette, nil
+}
+
+// ReadGPL reads a GIMP palette. Every color line holds the decimal R, G and B
+// values, optionally followed by a name.
+func ReadGPL(r io.Reader) ([]color.Color, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Palette" {
+		return nil, errors.New("missing \"GIMP Palette\" header")
+	}
+
+	var palette []color.Color
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.Contains(text, ":") {
+			continue // Comments and header fields like "Name:" or "Columns:"
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: want R G B values", line)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			rgb[i] = uint8(v)
+		}
+		palette = append(palette, color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xff})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return checkPaletteSize(palette)
+}
+
+// ReadACT reads a Photoshop color table: 256 RGB triplets, optionally followed
+// by the number of used colors and the transparent index as 16 bit big endian values.
+func ReadACT(r io.Reader) ([]color.Color, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 768 && len(data) != 772 {
+		return nil, fmt.Errorf("invalid size %d, want 768 or 772 bytes", len(data))
+	}
+
+	count := 256
+	if len(data) == 772 {
+		count = int(binary.BigEndian.Uint16(data[768:770]))
+		if count == 0 || count > 256 {
+			return nil, fmt.Errorf("invalid color count %d", count)
+		}
+	}
+
+	palette := make([]color.Color, count)
+	for i := range palette {
+		palette[i] = color.RGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xff}
+	}
+	return palette, nil
+}
+
+// checkPaletteSize returns an error if the palette is empty or has more than MaxPaletteColors colors.
+func checkPaletteSize(palette []color.Color) ([]color.Color, error) {
+	if len(palette) == 0 {
+		return nil, errors.New("palette has no colors")
+	}
+	if len(palette) > MaxPaletteColors {
+		return nil, fmt.Errorf("palette has %d colors, at most %d are supported", len(palette), MaxPaletteColors)
+	}
+	return palette, nil
+}
@@ -0,0 +1,70 @@
+package imgcolor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// actData returns a Photoshop color table holding colors, with the count trailer.
+func actData(colors ...color.RGBA) []byte {
+	data := make([]byte, 772)
+	for i, c := range colors {
+		data[i*3], data[i*3+1], data[i*3+2] = c.R, c.G, c.B
+	}
+	binary.BigEndian.PutUint16(data[768:], uint16(len(colors)))
+	binary.BigEndian.PutUint16(data[770:], 0xffff)
+	return data
+}
+
+func TestReadACT(t *testing.T) {
+	want := []color.RGBA{{R: 255, A: 255}, {G: 128, A: 255}, {R: 1, G: 2, B: 3, A: 255}}
+	palette, err := ReadACT(bytes.NewReader(actData(want...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(palette) != len(want) {
+		t.Fatalf("got %d colors, want %d", len(palette), len(want))
+	}
+	for i := range want {
+		if palette[i] != want[i] {
+			t.Errorf("color %d = %v, want %v", i, palette[i], want[i])
+		}
+	}
+
+	if _, err := ReadACT(bytes.NewReader(make([]byte, 100))); err == nil {
+		t.Error("short ACT data read without error")
+	}
+}
+
+func TestReadGPL(t *testing.T) {
+	gpl := `GIMP Palette
+Name: Brand
+Columns: 4
+# brand colors
+255   0   0	Red
+  0 128 255	Sky blue
+`
+	palette, err := ReadGPL(strings.NewReader(gpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []color.Color{color.RGBA{R: 255, A: 255}, color.RGBA{G: 128, B: 255, A: 255}}
+	if len(palette) != len(want) || palette[0] != want[0] || palette[1] != want[1] {
+		t.Errorf("palette = %v, want %v", palette, want)
+	}
+
+	var tooMany strings.Builder
+	tooMany.WriteString("GIMP Palette\n")
+	for i := 0; i < 257; i++ {
+		tooMany.WriteString("1 2 3\n")
+	}
+	if _, err := ReadGPL(strings.NewReader(tooMany.String())); err == nil {
+		t.Error("palette with 257 colors read without error")
+	}
+	if _, err := ReadGPL(strings.NewReader("JASC-PAL\n")); err == nil {
+		t.Error("palette without header read without error")
+	}
+}
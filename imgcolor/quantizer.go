@@ -0,0 +1,32 @@
+package imgcolor
+
+import "image/color"
+
+// Quantizer derives a palette of at most maxColors colors from a color count.
+type Quantizer interface {
+	Quantize(colorCount map[color.Color]int, maxColors int) []color.Color
+}
+
+// FrequencyQuantizer picks the most frequent colors, like ExtractPalette.
+type FrequencyQuantizer struct{}
+
+func (FrequencyQuantizer) Quantize(colorCount map[color.Color]int, maxColors int) []color.Color {
+	return ExtractPalette(colorCount, maxColors)
+}
+
+// KMeansQuantizer refines the palette of another quantizer with k-means
+// iterations under Metric.
+type KMeansQuantizer struct {
+	Initial    Quantizer // Quantizer of the initial palette, FrequencyQuantizer if nil
+	Iterations int       // Number of k-means iterations
+	Metric     Metric    // Color distance assigning the colors to the palette entries
+}
+
+func (q KMeansQuantizer) Quantize(colorCount map[color.Color]int, maxColors int) []color.Color {
+	initial := q.Initial
+	if initial == nil {
+		initial = FrequencyQuantizer{}
+	}
+	palette := initial.Quantize(colorCount, maxColors)
+	return q.Metric.RefinePaletteKMeans(colorCount, palette, q.Iterations)
+}
@@ -0,0 +1,38 @@
+package imgcolor
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestFrequencyQuantizerMatchesExtractPalette(t *testing.T) {
+	colorCount := make(map[color.Color]int)
+	for i := 0; i < 300; i++ {
+		colorCount[color.RGBA{uint8(i), uint8(i * 7), uint8(i * 13), 255}] = i % 17
+	}
+
+	var q Quantizer = FrequencyQuantizer{}
+	for _, maxColors := range []int{1, 16, 256, -1} {
+		got := q.Quantize(colorCount, maxColors)
+		want := ExtractPalette(colorCount, maxColors)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Quantize(%d) differs from ExtractPalette", maxColors)
+		}
+	}
+}
+
+func TestKMeansQuantizer(t *testing.T) {
+	colorCount := map[color.Color]int{
+		color.RGBA{R: 100, A: 255}: 10,
+		color.RGBA{R: 110, A: 255}: 10,
+		color.RGBA{B: 200, A: 255}: 5,
+	}
+
+	var q Quantizer = KMeansQuantizer{Iterations: 3}
+	got := q.Quantize(colorCount, 2)
+	want := RefinePaletteKMeans(colorCount, ExtractPalette(colorCount, 2), 3)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("KMeansQuantizer = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package imgcolor
+
+import (
+	"image"
+)
+
+// ssimWindow is the edge length of the square windows SSIM is averaged over.
+const ssimWindow = 8
+
+// SSIM constants for 8-bit values, C1 = (0.01*255)² and C2 = (0.03*255)².
+const (
+	ssimC1 = 6.5025
+	ssimC2 = 58.5225
+)
+
+// SSIM returns the structural similarity of the grayscale versions of a and b,
+// 1 for identical images. It is the mean over non-overlapping 8×8 windows
+// (smaller images use a single window). Both images are compared from their
+// top-left corner over the size they have in common.
+func SSIM(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	width, height := min(ab.Dx(), bb.Dx()), min(ab.Dy(), bb.Dy())
+	if width == 0 || height == 0 {
+		return 1
+	}
+
+	ga, gb := grayValues(a, width, height), grayValues(b, width, height)
+	winW, winH := min(ssimWindow, width), min(ssimWindow, height)
+
+	var sum float64
+	windows := 0
+	for y := 0; y+winH <= height; y += winH {
+		for x := 0; x+winW <= width; x += winW {
+			sum += windowSSIM(ga, gb, width, x, y, winW, winH)
+			windows++
+		}
+	}
+	return sum / float64(windows)
+}
+
+// grayValues returns the Rec. 601 luma of the first width×height pixels of img.
+func grayValues(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	gray := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			gray[y*width+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+	return gray
+}
+
+// windowSSIM computes the SSIM of one window of two grayscale images.
+func windowSSIM(a, b []float64, stride, x0, y0, w, h int) float64 {
+	n := float64(w * h)
+	var meanA, meanB float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			meanA += a[y*stride+x]
+			meanB += b[y*stride+x]
+		}
+	}
+	meanA /= n
+	meanB /= n
+
+	var varA, varB, cov float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			da, db := a[y*stride+x]-meanA, b[y*stride+x]-meanB
+			varA += da * da
+			varB += db * db
+			cov += da * db
+		}
+	}
+	varA /= n
+	varB /= n
+	cov /= n
+
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
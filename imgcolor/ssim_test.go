@@ -0,0 +1,34 @@
+package imgcolor
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSSIM(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 20; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 12), uint8(y * 20), uint8(x * y), 255})
+		}
+	}
+
+	if s := SSIM(img, img); math.Abs(s-1) > 1e-9 {
+		t.Errorf("SSIM of an image with itself = %v, want 1", s)
+	}
+
+	// Inverting the image destroys its structure
+	inverted := image.NewRGBA(img.Bounds())
+	for i, v := range img.Pix {
+		if i%4 == 3 {
+			inverted.Pix[i] = v
+		} else {
+			inverted.Pix[i] = 255 - v
+		}
+	}
+	if s := SSIM(img, inverted); s > 0.5 {
+		t.Errorf("SSIM with the inverted image = %v, want well below 1", s)
+	}
+}
@@ -29,7 +29,7 @@ def read_and_display_line(file, header, prev_line, y):
     current_line = [None] * header.width
     for x in range(0, header.width, 8):
         identical_byte = ord(file.read(1))  # Read the identical-byte
-        pixel_block = file.read(8)          # Read the next 8 pixels
+        pixel_block = file.read(min(8, header.width - x))  # Read the next 8 pixels, fewer at the end of a row
         process_pixel_block(x, y, identical_byte, pixel_block, prev_line, current_line, header.color_palette)
     return current_line
 
@@ -42,7 +42,7 @@ def process_pixel_block(x, y, identical_byte, pixel_block, prev_line, current_li
     should be reused from the previous line or updated with new data. The updated pixel is displayed
     on the Hub75 matrix.
     """
-    for bit in range(8):
+    for bit in range(len(pixel_block)):
         if not identical_byte & (1 << (7 - bit)):
             # Use the new pixel data
             color_index = pixel_block[bit]
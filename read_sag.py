@@ -23,7 +23,7 @@ def read_frames(file, header):
             row = []
             for x in range(0, header.width, 8):
                 identical_byte = ord(file.read(1))
-                pixel_block = file.read(8)
+                pixel_block = file.read(min(8, header.width - x))  # The last block of a row may be shorter
                 row.extend(process_pixel_block(x, y, identical_byte, pixel_block, prev_frame, header.color_palette, frame_index))
             frame.append(row)
         frames.append(frame)
@@ -32,13 +32,9 @@ def read_frames(file, header):
 
 def process_pixel_block(x, y, identical_byte, pixel_block, prev_frame, palette, frame_index):
     pixels = []
-    for bit in range(8):
-        if x + bit >= len(pixel_block):
-            break
-
-        if identical_byte & (1 << (7 - bit)):
-            if prev_frame:
-                r, g, b = prev_frame[y][x + bit]
+    for bit in range(len(pixel_block)):
+        if identical_byte & (1 << (7 - bit)) and prev_frame:
+            r, g, b = prev_frame[y][x + bit]
         else:
             color_index = pixel_block[bit]
             r, g, b = palette[color_index]
@@ -0,0 +1,64 @@
+package sag
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// Animation is a decoded SAG file. It implements image.Image as a view of
+// the frame selected by Current, so it can be passed to any code expecting
+// an image, e.g. draw.Draw.
+type Animation struct {
+	Frames  []*image.Paletted // Frames, all of the same size
+	Delays  []int             // Delay of every frame in milliseconds
+	Current int               // Index of the frame shown by the image.Image methods
+}
+
+// DecodeAnimation reads a SAG file from r as an Animation.
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	frames, delays, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Animation{Frames: frames, Delays: delays}, nil
+}
+
+// Frame returns frame i.
+func (a *Animation) Frame(i int) *image.Paletted {
+	return a.Frames[i]
+}
+
+// Len returns the number of frames.
+func (a *Animation) Len() int {
+	return len(a.Frames)
+}
+
+// Duration returns the total duration of one loop in milliseconds.
+func (a *Animation) Duration() int {
+	total := 0
+	for _, d := range a.Delays {
+		total += d
+	}
+	return total
+}
+
+// ColorModel returns the palette of the current frame.
+func (a *Animation) ColorModel() color.Model {
+	return a.Frames[a.Current].ColorModel()
+}
+
+// Bounds returns the bounds of the current frame.
+func (a *Animation) Bounds() image.Rectangle {
+	return a.Frames[a.Current].Bounds()
+}
+
+// At returns the color of a pixel of the current frame.
+func (a *Animation) At(x, y int) color.Color {
+	return a.Frames[a.Current].At(x, y)
+}
+
+// ColorIndexAt returns the palette index of a pixel of the current frame.
+func (a *Animation) ColorIndexAt(x, y int) uint8 {
+	return a.Frames[a.Current].ColorIndexAt(x, y)
+}
@@ -0,0 +1,48 @@
+package sag
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestAnimation(t *testing.T) {
+	frames, palette := testFrames(10, 4, 3)
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{40, 60, 100}, palette, &Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := DecodeAnimation(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if anim.Len() != 3 || anim.Duration() != 200 {
+		t.Errorf("got %d frames lasting %d ms, want 3 lasting 200 ms", anim.Len(), anim.Duration())
+	}
+
+	var img image.Image = anim.Frame(0)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 10; x++ {
+			if got, want := img.At(x, y), palette[(x+y)%len(palette)]; got != want {
+				t.Fatalf("Frame(0).At(%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// The animation itself draws as its current frame
+	anim.Current = 2
+	dst := image.NewRGBA(anim.Bounds())
+	draw.Draw(dst, dst.Bounds(), anim, image.Point{}, draw.Src)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 10; x++ {
+			r1, g1, b1, _ := dst.At(x, y).RGBA()
+			r2, g2, b2, _ := frames[2].At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("pixel (%d, %d) of the drawn current frame differs", x, y)
+			}
+		}
+	}
+}
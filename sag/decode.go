@@ -0,0 +1,309 @@
+package sag
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Errors returned when reading a SAG file, wrapped with more details; test
+// for them with errors.Is.
+var (
+	ErrBadSignature       = errors.New("sag: not a SAG file, the signature is missing")
+	ErrUnsupportedVersion = errors.New("sag: unsupported SAG version")
+	ErrTruncated          = errors.New("sag: file is truncated")
+)
+
+// truncated wraps the end-of-file errors of a read in ErrTruncated, together
+// with io.ErrUnexpectedEOF. Other errors are returned as they are.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// Decode reads a SAG file from r and returns the frames and their delays in milliseconds.
+func Decode(r io.Reader) ([]*image.Paletted, []int, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	frames, delays, err := readFrames(r, header, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return frames, delays, nil
+}
+
+// DecodeStrict is Decode with a strict Decoder: a short file, a pixel index
+// beyond the stored palette size or data after the last frame is an error.
+func DecodeStrict(r io.Reader) ([]*image.Paletted, []int, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return readFrames(r, header, true)
+}
+
+// DecodeFile reads the SAG file with the given name like Decode, or like
+// DecodeStrict with strict set.
+func DecodeFile(name string, strict bool) ([]*image.Paletted, []int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	if strict {
+		return DecodeStrict(file)
+	}
+	return Decode(file)
+}
+
+// readFrames reads the frames and delays following the header.
+func readFrames(r io.Reader, header Header, strict bool) ([]*image.Paletted, []int, error) {
+	d, err := newDecoder(r, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.Strict = strict
+
+	frames := make([]*image.Paletted, 0, header.FrameCount)
+	delays := make([]int, 0, header.FrameCount)
+	for {
+		frame, err := d.ReadFrame()
+		if err == io.EOF {
+			return frames, delays, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		frames = append(frames, frame.Image)
+		delays = append(delays, frame.Delay)
+	}
+}
+
+// cropWidth returns a copy of the frame with only its first width columns.
+func cropWidth(frame *image.Paletted, width int) *image.Paletted {
+	height := frame.Bounds().Dy()
+	cropped := image.NewPaletted(image.Rect(0, 0, width, height), frame.Palette)
+	for y := 0; y < height; y++ {
+		copy(cropped.Pix[y*cropped.Stride:], frame.Pix[y*frame.Stride:y*frame.Stride+width])
+	}
+	return cropped
+}
+
+// readRows reads the rows of a frame. If changed is not nil, it is the row
+// bitmap of a row-skip frame and only the rows marked in it are read.
+func readRows(r io.Reader, frame *image.Paletted, changed []byte, flags uint16, strict bool) error {
+	for y := 0; y < frame.Bounds().Dy(); y++ {
+		if changed != nil && changed[y/8]&(1<<(7-uint(y%8))) == 0 {
+			continue
+		}
+		if err := readRow(r, frame, y, flags, strict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRow reads row y of a frame. In strict mode a short pixel block or an
+// index beyond the palette is an error; otherwise the block is read as far as
+// it goes and the palette of the frame is padded with black up to the index.
+func readRow(r io.Reader, frame *image.Paletted, y int, flags uint16, strict bool) error {
+	width := frame.Bounds().Dx()
+	for x := 0; x < width; x += 8 {
+		if flags&FlagNoDelta == 0 {
+			if err := skipIdenticalByte(r); err != nil && strict {
+				return err
+			}
+		}
+
+		pixelBlock, err := readPixelBlock(r, width, x, flags, strict)
+		if err != nil {
+			return err
+		}
+		if flags&FlagPacked4 != 0 {
+			pixelBlock = unpack4(pixelBlock, min(8, width-x))
+		}
+		for _, index := range pixelBlock {
+			if int(index) < len(frame.Palette) {
+				continue
+			}
+			if strict {
+				return fmt.Errorf("sag: pixel index %d outside the palette of %d colors", index, len(frame.Palette))
+			}
+			for len(frame.Palette) <= int(index) {
+				frame.Palette = append(frame.Palette, color.RGBA{A: 0xff})
+			}
+		}
+
+		applyPixelBlock(frame, pixelBlock, x, y, width)
+	}
+	return nil
+}
+
+// ReadFrameAt reads frame n of the SAG file in r without reading the frames
+// before it. header is the header at the start of r. Since every version 1
+// frame holds all of its pixels and has the same size, the frame is found by
+// its offset alone, or its rows by their offsets with FlagInterleaved.
+// Row-skip frames depend on the previous frame, so files with FlagRowSkip can
+// only be decoded sequentially, as can those with FlagDeflate or
+// FlagLocalPalettes, whose frames differ in size.
+func ReadFrameAt(r io.ReaderAt, header Header, n int) (*image.Paletted, error) {
+	if n < 0 || n >= int(header.FrameCount) {
+		return nil, fmt.Errorf("sag: frame %d out of range, the file has %d frames", n, header.FrameCount)
+	}
+	if header.Flags&FlagRowSkip != 0 {
+		return nil, errors.New("sag: frames of row-skip files cannot be read at random")
+	}
+	if header.Flags&FlagDeflate != 0 {
+		return nil, errors.New("sag: frames of compressed files cannot be read at random")
+	}
+	if header.Flags&FlagLocalPalettes != 0 {
+		return nil, errors.New("sag: frames with local palettes cannot be read at random")
+	}
+	if unknown := header.Flags &^ knownFlags; unknown != 0 {
+		return nil, fmt.Errorf("sag: unsupported flags %#04x", unknown)
+	}
+
+	width, height := int(header.Width), int(header.Height)
+	frame := image.NewPaletted(image.Rect(0, 0, width, height), header.Palette())
+	if header.Flags&FlagInterleaved != 0 {
+		if err := readInterleavedRows(r, header, frame, n); err != nil {
+			return nil, truncated(err)
+		}
+	} else {
+		frameSize := int64(height) * int64(rowSize(width, header.Flags))
+		if header.Flags&FlagFrameDelays != 0 {
+			frameSize += 2
+		}
+		offset := int64(headerSize(header)) + int64(n)*frameSize
+
+		section := io.NewSectionReader(r, offset, frameSize)
+		if header.Flags&FlagFrameDelays != 0 {
+			section.Seek(2, io.SeekStart)
+		}
+		if err := readRows(section, frame, nil, header.Flags, false); err != nil {
+			return nil, truncated(err)
+		}
+	}
+	if header.Flags&FlagPadded != 0 {
+		frame = cropWidth(frame, int(header.RealWidth))
+	}
+	return frame, nil
+}
+
+// readInterleavedRows reads the rows of frame n of a FlagInterleaved file,
+// each one from its own offset.
+func readInterleavedRows(r io.ReaderAt, header Header, frame *image.Paletted, n int) error {
+	size := int64(rowSize(int(header.Width), header.Flags))
+	start := int64(headerSize(header))
+	if header.Flags&FlagFrameDelays != 0 {
+		start += 2 * int64(header.FrameCount)
+	}
+	for y := 0; y < int(header.Height); y++ {
+		offset := start + (int64(y)*int64(header.FrameCount)+int64(n))*size
+		if err := readRow(io.NewSectionReader(r, offset, size), frame, y, header.Flags, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Palette returns the palette of the header as decoded frames use it, with all
+// 256 entries or only the used ones with FlagColors. Its Index method finds
+// the nearest palette color, e.g. for drawing onto decoded frames.
+func (h Header) Palette() color.Palette {
+	size := 256
+	if h.Flags&FlagColors != 0 {
+		size = int(h.Colors)
+	}
+	return paletteFromBytes(h.ColorPalette[:size*3])
+}
+
+// readLocalPalette reads the palette in front of a frame of a
+// FlagLocalPalettes file.
+func readLocalPalette(r io.Reader) (color.Palette, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 3*(int(count[0])+1))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return paletteFromBytes(data), nil
+}
+
+// paletteFromBytes creates an opaque color palette from 3 bytes RGB per color.
+func paletteFromBytes(data []byte) color.Palette {
+	palette := make([]color.Color, len(data)/3)
+	for i := range palette {
+		palette[i] = color.RGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xff}
+	}
+	return palette
+}
+
+// readFrameMode reads the mode byte that starts a frame of a FlagRowSkip file.
+func readFrameMode(r io.Reader) (byte, error) {
+	var mode [1]byte
+	if _, err := io.ReadFull(r, mode[:]); err != nil {
+		return 0, err
+	}
+	if mode[0] != FrameFull && mode[0] != FrameRowSkip {
+		return 0, fmt.Errorf("sag: unknown frame mode %#02x", mode[0])
+	}
+	return mode[0], nil
+}
+
+// skipIdenticalByte skips the identical byte in the SAG data.
+func skipIdenticalByte(r io.Reader) error {
+	_, err := io.ReadFull(r, make([]byte, 1))
+	return err
+}
+
+// readPixelBlock reads the next 8 pixels from the SAG data. In strict mode
+// the block must be complete, otherwise a block cut short by the end of the
+// data keeps index 0 for its missing pixels.
+func readPixelBlock(r io.Reader, width, x int, flags uint16, strict bool) ([]byte, error) {
+	pixelBlock := make([]byte, 8)
+	if x+8 > width {
+		pixelBlock = make([]byte, width-x)
+	}
+	if flags&FlagPacked4 != 0 {
+		pixelBlock = pixelBlock[:(len(pixelBlock)+1)/2]
+	}
+	// Readers like the DEFLATE decompressor, stdin or an HTTP body may return
+	// less than asked for before the end of the data, so the block is filled
+	// with more reads
+	n, err := io.ReadFull(r, pixelBlock)
+	if err == io.ErrUnexpectedEOF && n > 0 && !strict {
+		err = nil
+	}
+	return pixelBlock, err
+}
+
+// unpack4 unpacks n 4-bit indices stored two per byte, high nibble first.
+func unpack4(packed []byte, n int) []byte {
+	indices := make([]byte, n)
+	for i := range indices {
+		indices[i] = packed[i/2] >> (4 * uint(1-i%2)) & 0x0f
+	}
+	return indices
+}
+
+// applyPixelBlock applies a block of pixels to a frame.
+func applyPixelBlock(frame *image.Paletted, pixelBlock []byte, x, y, width int) {
+	for bit := 0; bit < len(pixelBlock); bit++ {
+		if x+bit < width {
+			frame.SetColorIndex(x+bit, y, pixelBlock[bit])
+		}
+	}
+}
@@ -0,0 +1,708 @@
+package sag
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"unicode/utf8"
+)
+
+// Encode writes the frames as a SAG file to w. All frames must have the same
+// size and use the given palette; delays are in milliseconds.
+func Encode(w io.Writer, frames []*image.Paletted, delays []int, palette []color.Color) error {
+	return EncodeWithOptions(w, frames, delays, palette, nil)
+}
+
+// EncodeWithOptions is like Encode with optional format features. A nil opts
+// is the same as empty options, as Encode uses them. With opts.RowSkip the frames are
+// measured first and row skipping is only used if it saves space over the
+// pixel-level delta alone. If a stored delay (every delay with
+// opts.FrameDelays, otherwise the first) exceeds MaxValue milliseconds and
+// opts.TimeBase is not set, the smallest time base that fits is used.
+func EncodeWithOptions(w io.Writer, frames []*image.Paletted, delays []int, palette []color.Color, opts *Options) error {
+	if len(frames) == 0 {
+		return errors.New("sag: no frames to encode")
+	}
+	if len(delays) != len(frames) {
+		return fmt.Errorf("sag: %d delays for %d frames", len(delays), len(frames))
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	bounds := frames[0].Bounds()
+	if opts.RowSkip {
+		o := *opts
+		// With the interleaved layout or local palettes RowSkip stays set, so NewEncoder reports the conflict
+		o.RowSkip = o.Interleaved || o.LocalPalettes || rowSkipSaves(frames, rowSize(bounds.Dx(), flagsOf(opts)), transparentIndices(palette, opts))
+		opts = &o
+	}
+
+	enc, err := NewEncoderDelays(w, bounds.Dx(), bounds.Dy(), delays, palette, opts)
+	if err != nil {
+		return err
+	}
+
+	for i, frame := range frames {
+		if err := enc.WriteFrameDelay(frame, delays[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewEncoderDelays is NewEncoder for one frame per delay, for encoders that
+// know all delays before the frames. Like EncodeWithOptions it stores
+// opts.FrameDelays only if the delays differ, and picks a time base for the
+// stored delays if one exceeds MaxValue milliseconds and opts.TimeBase is not
+// set.
+func NewEncoderDelays(w io.Writer, width, height int, delays []int, palette []color.Color, opts *Options) (*Encoder, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	o := *opts
+	o.FrameDelays = o.FrameDelays && !equalDelays(delays)
+	delay := 0
+	if len(delays) > 0 {
+		delay = delays[0]
+	}
+	if o.TimeBase == 0 {
+		// Without per-frame delays only the first one is stored
+		stored := delays
+		if !o.FrameDelays {
+			stored = []int{delay}
+		}
+		o.TimeBase = pickTimeBase(stored)
+	}
+	return NewEncoder(w, width, height, len(delays), delay, palette, &o)
+}
+
+// equalDelays reports whether all delays are the same.
+func equalDelays(delays []int) bool {
+	for _, d := range delays {
+		if d != delays[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// pickTimeBase returns the smallest time base in milliseconds that stores
+// all delays in a uint16, preferring one that divides all of them, so they
+// are kept exactly. It is 1 if all delays fit as milliseconds.
+func pickTimeBase(delays []int) int {
+	longest, divisor := 0, 0
+	for _, d := range delays {
+		longest = max(longest, d)
+		divisor = gcd(divisor, d)
+	}
+	if longest <= MaxValue {
+		return 1
+	}
+
+	base := (longest + MaxValue - 1) / MaxValue
+	for d := base; d <= min(divisor, MaxValue); d++ {
+		if divisor%d == 0 {
+			return d
+		}
+	}
+	return base
+}
+
+// gcd returns the greatest common divisor of a and b, ignoring negative values.
+func gcd(a, b int) int {
+	if b < 0 {
+		return a
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// scaleDelay returns delay in milliseconds as a number of timeBase units,
+// rounded to the nearest one, or an error if that does not fit a uint16.
+func scaleDelay(delay, timeBase int) (int, error) {
+	units := (delay + timeBase/2) / timeBase
+	if delay < 0 || units > MaxValue {
+		return 0, fmt.Errorf("sag: frame delay of %d ms is outside 0 to %d ms", delay, MaxValue*timeBase)
+	}
+	return units, nil
+}
+
+// Encoder writes a SAG file frame by frame. Since the identical-bytes only
+// compare against the previous frame, it is the only frame kept in memory.
+type Encoder struct {
+	w         io.Writer
+	header    Header
+	prevFrame *image.Paletted
+	written   int
+	padIndex  uint8
+	timeBase  int // Milliseconds per stored delay unit
+
+	transparent []bool // Transparent palette entries with SkipTransparent, nil otherwise
+
+	// Frames and delays held back until the last frame with FlagInterleaved
+	pending       []*image.Paletted
+	pendingDelays []int
+
+	deflate *flate.Writer // Compressor e.w writes to with FlagDeflate, closed after the last frame
+	buf     *bufio.Writer // Buffer in front of the writer given to NewEncoder, flushed after the last frame
+}
+
+// NewEncoder writes the SAG header for frameCount frames of width×height pixels
+// to w and returns an Encoder for the frame data. delay is in milliseconds.
+// opts may be nil; with opts.RowSkip every frame skips its unchanged rows when
+// that is smaller. The output is buffered, w holds the whole file once the
+// last frame is written.
+func NewEncoder(w io.Writer, width, height, frameCount, delay int, palette []color.Color, opts *Options) (*Encoder, error) {
+	timeBase := 1
+	if opts != nil && opts.TimeBase > 1 {
+		if opts.TimeBase > MaxValue {
+			return nil, fmt.Errorf("sag: time base of %d ms exceeds the maximum of %d ms", opts.TimeBase, MaxValue)
+		}
+		timeBase = opts.TimeBase
+	}
+	units, err := scaleDelay(delay, timeBase)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimits(width, height, frameCount, units, len(palette)); err != nil {
+		return nil, err
+	}
+	if opts != nil {
+		switch opts.BitsPerPixel {
+		case 0, 8:
+		case 4:
+			if len(palette) > 16 {
+				return nil, fmt.Errorf("sag: 4 bits per pixel need at most 16 colors, the palette has %d", len(palette))
+			}
+		default:
+			return nil, fmt.Errorf("sag: unsupported %d bits per pixel, want 4 or 8", opts.BitsPerPixel)
+		}
+		if len(opts.Title) > MaxTitleLength {
+			return nil, fmt.Errorf("sag: title of %d bytes exceeds the maximum of %d", len(opts.Title), MaxTitleLength)
+		}
+		if !utf8.ValidString(opts.Title) {
+			return nil, errors.New("sag: title is not valid UTF-8")
+		}
+		if err := checkCycle(opts, len(palette)); err != nil {
+			return nil, err
+		}
+		if err := checkMetadata(opts.Metadata); err != nil {
+			return nil, err
+		}
+		if opts.PaletteSize && len(palette) == 0 {
+			return nil, errors.New("sag: the palette size of an empty palette cannot be stored")
+		}
+		if opts.PaletteSize && opts.Pad8 && int(opts.PadIndex) >= len(palette) {
+			return nil, fmt.Errorf("sag: padding index %d outside the palette of %d colors", opts.PadIndex, len(palette))
+		}
+		if opts.Interleaved && opts.RowSkip {
+			return nil, errors.New("sag: row skipping cannot be combined with the interleaved layout")
+		}
+		if opts.LocalPalettes && (opts.RowSkip || opts.Interleaved) {
+			return nil, errors.New("sag: local palettes cannot be combined with row skipping or the interleaved layout")
+		}
+	}
+
+	// Create and initialize the header
+	var header Header
+	copy(header.Signature[:], "SAG")
+	header.Version = Version
+	if header.Flags = flagsOf(opts); header.Flags != 0 {
+		header.Version = Version2
+	}
+	header.Width = uint16(width)
+	if opts != nil && opts.Pad8 && width%8 != 0 {
+		if width+7 > MaxValue {
+			return nil, fmt.Errorf("sag: width of %d pixels exceeds the maximum of %d when padded", width, MaxValue)
+		}
+		header.Version = Version2
+		header.Flags |= FlagPadded
+		header.Width = uint16((width + 7) / 8 * 8)
+		header.RealWidth = uint16(width)
+	}
+	header.Height = uint16(height)
+	header.FrameCount = uint16(frameCount)
+	header.FrameDelay = uint16(units)
+	if timeBase > 1 {
+		header.Version = Version2
+		header.Flags |= FlagTimeBase
+		header.TimeBase = uint16(timeBase)
+	}
+	if opts != nil {
+		header.Title = opts.Title
+		header.CycleStart = uint16(opts.CycleStart)
+		header.CycleCount = uint16(opts.CycleCount)
+		header.CycleDelay = uint16(opts.CycleDelay)
+		if opts.PaletteSize {
+			header.Colors = uint16(len(palette))
+		}
+		header.Metadata = opts.Metadata
+	}
+
+	// Store the color palette in the header
+	copy(header.ColorPalette[:], paletteBytes(palette))
+
+	// The frame data is written in pieces of a few bytes, the buffer turns
+	// them into large writes to files and network connections
+	buf := bufio.NewWriter(w)
+
+	// Write the header
+	if err := writeHeader(buf, header); err != nil {
+		return nil, err
+	}
+
+	enc := &Encoder{w: buf, buf: buf, header: header, timeBase: timeBase, transparent: transparentIndices(palette, opts)}
+	if opts != nil {
+		enc.padIndex = opts.PadIndex
+	}
+	if header.Flags&FlagDeflate != 0 {
+		fw, err := flate.NewWriter(buf, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		enc.w, enc.deflate = fw, fw
+	}
+	if frameCount == 0 {
+		// There is no last frame to complete the file
+		if err := enc.finish(); err != nil {
+			return nil, err
+		}
+	}
+	return enc, nil
+}
+
+// transparentIndices marks the palette entries with an alpha of 0 if
+// opts.SkipTransparent is set, otherwise it returns nil.
+func transparentIndices(palette []color.Color, opts *Options) []bool {
+	if opts == nil || !opts.SkipTransparent {
+		return nil
+	}
+	transparent := make([]bool, 256)
+	// Entries beyond 256 cannot be indexed, the encoder reports the palette size
+	for i, c := range palette[:min(len(palette), 256)] {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent[i] = true
+		}
+	}
+	return transparent
+}
+
+// paletteBytes returns the palette as 3 bytes RGB per color.
+func paletteBytes(palette []color.Color) []byte {
+	data := make([]byte, 0, 3*len(palette))
+	for _, c := range palette {
+		rgb := storedRGB(c)
+		data = append(data, rgb[:]...)
+	}
+	return data
+}
+
+// storedRGB returns the color as it is stored in a palette.
+func storedRGB(c color.Color) [3]byte {
+	r, g, b, _ := c.RGBA()
+	return [3]byte{to8Bit(r), to8Bit(g), to8Bit(b)}
+}
+
+// sameIndex reports whether a pixel with index a in the previous frame and b
+// in the current one is unchanged: the indices are equal or both transparent.
+func sameIndex(a, b uint8, transparent []bool) bool {
+	return a == b || transparent != nil && transparent[a] && transparent[b]
+}
+
+// flagsOf returns the header flags for the options.
+func flagsOf(opts *Options) uint16 {
+	var flags uint16
+	if opts == nil {
+		return flags
+	}
+	if opts.RowSkip {
+		flags |= FlagRowSkip
+	}
+	if opts.BitsPerPixel == 4 {
+		flags |= FlagPacked4
+	}
+	if opts.FrameDelays {
+		flags |= FlagFrameDelays
+	}
+	if opts.Title != "" {
+		flags |= FlagTitle
+	}
+	if opts.CycleCount > 0 {
+		flags |= FlagCycle
+	}
+	if opts.Interleaved {
+		flags |= FlagInterleaved
+	}
+	if opts.PaletteSize {
+		flags |= FlagColors
+	}
+	if opts.Deflate {
+		flags |= FlagDeflate
+	}
+	if opts.LocalPalettes {
+		flags |= FlagLocalPalettes
+	}
+	if opts.LittleEndian {
+		flags |= FlagLittleEndian
+	}
+	if opts.NoDelta {
+		flags |= FlagNoDelta
+	}
+	if len(opts.Metadata) > 0 {
+		flags |= FlagMetadata
+	}
+	return flags
+}
+
+// checkMetadata returns an error if the metadata cannot be stored: an empty
+// or repeated key, a key or value that is too long or not UTF-8, or more than
+// MaxMetadataSize bytes in total.
+func checkMetadata(metadata []MetaEntry) error {
+	if len(metadata) > 255 {
+		return fmt.Errorf("sag: %d metadata entries exceed the maximum of 255", len(metadata))
+	}
+	seen := make(map[string]bool, len(metadata))
+	for _, entry := range metadata {
+		if entry.Key == "" {
+			return errors.New("sag: empty metadata key")
+		}
+		if seen[entry.Key] {
+			return fmt.Errorf("sag: metadata key %q repeated", entry.Key)
+		}
+		seen[entry.Key] = true
+		if len(entry.Key) > 255 || len(entry.Value) > 255 {
+			return fmt.Errorf("sag: metadata %q exceeds 255 bytes in key or value", entry.Key)
+		}
+		if !utf8.ValidString(entry.Key) || !utf8.ValidString(entry.Value) {
+			return fmt.Errorf("sag: metadata %q is not valid UTF-8", entry.Key)
+		}
+	}
+	if size := metadataSize(metadata); size > MaxMetadataSize {
+		return fmt.Errorf("sag: metadata of %d bytes exceed the maximum of %d", size, MaxMetadataSize)
+	}
+	return nil
+}
+
+// checkCycle returns an error if the palette cycling range of the options
+// does not lie within the palette or has no valid delay.
+func checkCycle(opts *Options, colors int) error {
+	if opts.CycleCount == 0 && opts.CycleStart == 0 && opts.CycleDelay == 0 {
+		return nil
+	}
+	switch {
+	case opts.CycleCount < 2:
+		return fmt.Errorf("sag: palette cycling range of %d entries, want at least 2", opts.CycleCount)
+	case opts.CycleStart < 0 || opts.CycleStart+opts.CycleCount > colors:
+		return fmt.Errorf("sag: palette cycling range %d to %d outside the palette of %d colors", opts.CycleStart, opts.CycleStart+opts.CycleCount-1, colors)
+	case opts.CycleDelay < 1 || opts.CycleDelay > MaxValue:
+		return fmt.Errorf("sag: palette cycling delay of %d ms is outside 1 to %d ms", opts.CycleDelay, MaxValue)
+	}
+	return nil
+}
+
+// MaxValue is the largest width, height, frame count and delay of a SAG
+// file, they are stored as uint16.
+const MaxValue = 0xffff
+
+// checkLimits returns an error if a header field does not fit into its uint16
+// or the palette into the 256 header entries, instead of silently truncating it.
+func checkLimits(width, height, frameCount, delay, colors int) error {
+	switch {
+	case width < 0 || width > MaxValue:
+		return fmt.Errorf("sag: width of %d pixels exceeds the maximum of %d, resize or crop the frames", width, MaxValue)
+	case height < 0 || height > MaxValue:
+		return fmt.Errorf("sag: height of %d pixels exceeds the maximum of %d, resize or crop the frames", height, MaxValue)
+	case frameCount < 0 || frameCount > MaxValue:
+		return fmt.Errorf("sag: %d frames exceed the maximum of %d, limit or skip frames", frameCount, MaxValue)
+	case delay < 0 || delay > MaxValue:
+		return fmt.Errorf("sag: frame delay of %d ms is outside 0 to %d ms", delay, MaxValue)
+	case colors > 256:
+		return fmt.Errorf("sag: palette has %d colors, at most 256 fit into the header", colors)
+	}
+	return nil
+}
+
+// to8Bit rounds a 16-bit color channel to the nearest 8-bit value instead of
+// truncating it. Scaling by 255/65535 (rather than adding 0x80 and shifting)
+// keeps colors that came from 8-bit sources, stored as v*0x101, unchanged.
+func to8Bit(v uint32) uint8 {
+	return uint8((v*0xff + 0x7fff) / 0xffff)
+}
+
+// WriteFrame writes the next frame with the delay given to NewEncoder. It must
+// use the palette passed to NewEncoder, unless FlagLocalPalettes stores the
+// palette of the frame with it.
+func (e *Encoder) WriteFrame(frame *image.Paletted) error {
+	return e.WriteFrameDelay(frame, int(e.header.FrameDelay)*e.timeBase)
+}
+
+// WriteFrameDelay writes the next frame, shown for delay milliseconds. Without
+// FlagFrameDelays all frames share the delay given to NewEncoder and delay is
+// ignored; with FlagTimeBase it is rounded to the time base. With
+// FlagInterleaved nothing is written until the last frame.
+func (e *Encoder) WriteFrameDelay(frame *image.Paletted, delay int) error {
+	if e.written == int(e.header.FrameCount) {
+		return errors.New("sag: more frames written than announced in the header")
+	}
+	if e.header.Flags&FlagFrameDelays != 0 {
+		units, err := scaleDelay(delay, e.timeBase)
+		if err != nil {
+			return err
+		}
+		delay = units
+	}
+	if e.header.Flags&FlagInterleaved != 0 {
+		e.pending = append(e.pending, frame)
+		e.pendingDelays = append(e.pendingDelays, delay)
+		if e.written++; e.written == int(e.header.FrameCount) {
+			e.writeInterleaved()
+			return e.finish()
+		}
+		return nil
+	}
+	if e.header.Flags&FlagFrameDelays != 0 {
+		e.w.Write(e.header.byteOrder().AppendUint16(nil, uint16(delay)))
+	}
+	if e.header.Flags&FlagLocalPalettes != 0 {
+		if err := e.writeLocalPalette(frame.Palette); err != nil {
+			return err
+		}
+	}
+
+	width, height := int(e.header.Width), int(e.header.Height)
+	changed := allRows(height)
+	if e.header.Flags&FlagRowSkip != 0 {
+		mode := byte(FrameFull)
+		if c := changedRows(e.prevFrame, frame, width, height, e.transparent); rowSkipPays(c, rowSize(width, e.header.Flags)) {
+			mode, changed = FrameRowSkip, c
+		}
+		e.w.Write([]byte{mode})
+		if mode == FrameRowSkip {
+			e.w.Write(rowBitmap(changed))
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		if changed[y] {
+			e.writeRow(e.prevFrame, frame, y)
+		}
+	}
+
+	e.prevFrame = frame
+	if e.written++; e.written == int(e.header.FrameCount) {
+		return e.finish()
+	}
+	return nil
+}
+
+// writeLocalPalette writes the palette of a frame with FlagLocalPalettes.
+func (e *Encoder) writeLocalPalette(palette color.Palette) error {
+	switch {
+	case len(palette) < 1 || len(palette) > 256:
+		return fmt.Errorf("sag: local palette of %d colors, want 1 to 256", len(palette))
+	case e.header.Flags&FlagPacked4 != 0 && len(palette) > 16:
+		return fmt.Errorf("sag: 4 bits per pixel need at most 16 colors, the local palette has %d", len(palette))
+	case e.header.Flags&FlagPadded != 0 && int(e.padIndex) >= len(palette):
+		return fmt.Errorf("sag: padding index %d outside the local palette of %d colors", e.padIndex, len(palette))
+	}
+	e.w.Write([]byte{byte(len(palette) - 1)})
+	e.w.Write(paletteBytes(palette))
+	return nil
+}
+
+// finish completes the file after the last frame by closing the DEFLATE
+// stream of FlagDeflate and flushing the buffer. An error of any earlier
+// write shows up here.
+func (e *Encoder) finish() error {
+	if e.deflate != nil {
+		if err := e.deflate.Close(); err != nil {
+			return err
+		}
+	}
+	return e.buf.Flush()
+}
+
+// writeInterleaved writes the pending frames row by row across all frames,
+// preceded by their delays with FlagFrameDelays.
+func (e *Encoder) writeInterleaved() {
+	if e.header.Flags&FlagFrameDelays != 0 {
+		for _, delay := range e.pendingDelays {
+			e.w.Write(e.header.byteOrder().AppendUint16(nil, uint16(delay)))
+		}
+	}
+	for y := 0; y < int(e.header.Height); y++ {
+		var prev *image.Paletted
+		for _, frame := range e.pending {
+			e.writeRow(prev, frame, y)
+			prev = frame
+		}
+	}
+	e.prevFrame = e.pending[len(e.pending)-1]
+	e.pending, e.pendingDelays = nil, nil
+}
+
+// writeRow writes row y of the frame as blocks of an identical-byte and up to
+// 8 pixels, or of the pixels alone with FlagNoDelta. prevFrame is the frame
+// before it, nil for the first one.
+func (e *Encoder) writeRow(prevFrame, frame *image.Paletted, y int) {
+	width := int(e.header.Width)
+	realWidth := width
+	if e.header.Flags&FlagPadded != 0 {
+		realWidth = int(e.header.RealWidth)
+	}
+
+	for x := 0; x < width; x += 8 {
+		var identicalByte byte = 0
+		var pixelBlock []byte
+
+		for bit := 0; bit < 8; bit++ {
+			if x+bit >= width {
+				break
+			}
+			if x+bit >= realWidth {
+				// Padding never changes
+				if prevFrame != nil {
+					identicalByte |= 1 << (7 - bit)
+				}
+				pixelBlock = append(pixelBlock, e.padIndex)
+				continue
+			}
+			currentPixel := frame.ColorIndexAt(x+bit, y)
+			if prevFrame != nil && e.unchanged(prevFrame, frame, x+bit, y) {
+				identicalByte |= 1 << (7 - bit)
+			}
+			pixelBlock = append(pixelBlock, currentPixel)
+		}
+
+		if e.header.Flags&FlagPacked4 != 0 {
+			pixelBlock = pack4(pixelBlock)
+		}
+		if e.header.Flags&FlagNoDelta == 0 {
+			e.w.Write([]byte{identicalByte})
+		}
+		e.w.Write(pixelBlock)
+	}
+}
+
+// unchanged reports whether pixel (x, y) is the same in the previous frame
+// and the frame: by index as for sameIndex, or by its stored color with
+// FlagLocalPalettes, where the indices refer to different palettes.
+func (e *Encoder) unchanged(prevFrame, frame *image.Paletted, x, y int) bool {
+	a, b := prevFrame.ColorIndexAt(x, y), frame.ColorIndexAt(x, y)
+	if e.header.Flags&FlagLocalPalettes == 0 {
+		return sameIndex(a, b, e.transparent)
+	}
+	if int(a) >= len(prevFrame.Palette) || int(b) >= len(frame.Palette) {
+		return false
+	}
+	return storedRGB(prevFrame.Palette[a]) == storedRGB(frame.Palette[b])
+}
+
+// pack4 packs 4-bit indices into bytes, two per byte with the first in the high nibble.
+func pack4(indices []byte) []byte {
+	packed := make([]byte, (len(indices)+1)/2)
+	for i, index := range indices {
+		packed[i/2] |= (index & 0x0f) << (4 * uint(1-i%2))
+	}
+	return packed
+}
+
+// allRows returns a row selection with all height rows set.
+func allRows(height int) []bool {
+	rows := make([]bool, height)
+	for y := range rows {
+		rows[y] = true
+	}
+	return rows
+}
+
+// changedRows reports for each of the height rows whether frame differs from
+// prev in the first width pixels, with transparent as for sameIndex. Without a
+// previous frame all rows count as changed.
+func changedRows(prev, frame *image.Paletted, width, height int, transparent []bool) []bool {
+	changed := make([]bool, height)
+	for y := range changed {
+		if prev == nil {
+			changed[y] = true
+			continue
+		}
+		for x := 0; x < width; x++ {
+			if !sameIndex(prev.ColorIndexAt(x, y), frame.ColorIndexAt(x, y), transparent) {
+				changed[y] = true
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// rowSkipPays reports whether the rows skipped save more bytes than the row
+// bitmap costs. rowSize is the stored size of one row.
+func rowSkipPays(changed []bool, rowSize int) bool {
+	saved := 0
+	for _, c := range changed {
+		if !c {
+			saved += rowSize
+		}
+	}
+	return saved > (len(changed)+7)/8
+}
+
+// rowSkipSaves reports whether row skipping makes the frames smaller, despite
+// the mode byte of every frame and the row bitmaps.
+func rowSkipSaves(frames []*image.Paletted, rowSize int, transparent []bool) bool {
+	bounds := frames[0].Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	saved := -len(frames) // one mode byte per frame
+	var prev *image.Paletted
+	for _, frame := range frames {
+		changed := changedRows(prev, frame, width, height, transparent)
+		if rowSkipPays(changed, rowSize) {
+			saved -= (len(changed) + 7) / 8
+			for _, c := range changed {
+				if !c {
+					saved += rowSize
+				}
+			}
+		}
+		prev = frame
+	}
+	return saved > 0
+}
+
+// rowBitmap packs the changed rows into bytes, most significant bit first.
+func rowBitmap(changed []bool) []byte {
+	bitmap := make([]byte, (len(changed)+7)/8)
+	for y, c := range changed {
+		if c {
+			bitmap[y/8] |= 1 << (7 - uint(y%8))
+		}
+	}
+	return bitmap
+}
+
+// EncodedSize returns the number of bytes EncodeWithOptions would write for
+// the frames, without writing them anywhere. opts may be nil.
+func EncodedSize(frames []*image.Paletted, delays []int, palette []color.Color, opts *Options) (int64, error) {
+	var cw countingWriter
+	err := EncodeWithOptions(&cw, frames, delays, palette, opts)
+	return cw.n, err
+}
+
+// countingWriter is an io.Writer that only counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
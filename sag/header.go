@@ -0,0 +1,249 @@
+package sag
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSizeV1 is the size of a version 1 header, which ends after the palette.
+const headerSizeV1 = 12 + 768
+
+// headerSize returns the number of bytes the header takes in the file.
+func headerSize(header Header) int {
+	size := headerSizeV1
+	if header.Version >= Version2 {
+		size += 2 // Flags
+	}
+	if header.Flags&FlagPadded != 0 {
+		size += 2 // RealWidth
+	}
+	if header.Flags&FlagTitle != 0 {
+		size += 1 + len(header.Title)
+	}
+	if header.Flags&FlagCycle != 0 {
+		size += 6 // CycleStart, CycleCount, CycleDelay
+	}
+	if header.Flags&FlagColors != 0 {
+		size += 2 // Colors
+	}
+	if header.Flags&FlagTimeBase != 0 {
+		size += 2 // TimeBase
+	}
+	if header.Flags&FlagMetadata != 0 {
+		size += metadataSize(header.Metadata)
+	}
+	return size
+}
+
+// byteOrder is the byte order of the multi-byte fields of a file.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// byteOrder returns the byte order of all multi-byte fields but Flags:
+// little-endian with FlagLittleEndian, otherwise big-endian.
+func (h Header) byteOrder() byteOrder {
+	if h.Flags&FlagLittleEndian != 0 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// writeHeader writes the version 1 fields of the header, followed by the
+// optional fields its version and flags call for.
+func writeHeader(w io.Writer, header Header) error {
+	order := header.byteOrder()
+	data := make([]byte, 0, headerSize(header))
+	data = append(data, header.Signature[:]...)
+	data = append(data, header.Version)
+	data = order.AppendUint16(data, header.Width)
+	data = order.AppendUint16(data, header.Height)
+	data = order.AppendUint16(data, header.FrameCount)
+	data = order.AppendUint16(data, header.FrameDelay)
+	data = append(data, header.ColorPalette[:]...)
+
+	if header.Version >= Version2 {
+		data = binary.BigEndian.AppendUint16(data, header.Flags)
+	}
+	if header.Flags&FlagPadded != 0 {
+		data = order.AppendUint16(data, header.RealWidth)
+	}
+	if header.Flags&FlagTitle != 0 {
+		data = append(data, byte(len(header.Title)))
+		data = append(data, header.Title...)
+	}
+	if header.Flags&FlagCycle != 0 {
+		data = order.AppendUint16(data, header.CycleStart)
+		data = order.AppendUint16(data, header.CycleCount)
+		data = order.AppendUint16(data, header.CycleDelay)
+	}
+	if header.Flags&FlagColors != 0 {
+		data = order.AppendUint16(data, header.Colors)
+	}
+	if header.Flags&FlagTimeBase != 0 {
+		data = order.AppendUint16(data, header.TimeBase)
+	}
+	if header.Flags&FlagMetadata != 0 {
+		data = append(data, byte(len(header.Metadata)))
+		for _, entry := range header.Metadata {
+			data = append(data, byte(len(entry.Key)))
+			data = append(data, entry.Key...)
+			data = append(data, byte(len(entry.Value)))
+			data = append(data, entry.Value...)
+		}
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadHeader reads and validates only the header of a SAG file, e.g. to list
+// the dimensions and frame counts of many files. It reads exactly the bytes of
+// the header from r and none of the frame data.
+func ReadHeader(r io.Reader) (Header, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return header, err
+	}
+	if unknown := header.Flags &^ knownFlags; unknown != 0 {
+		return header, fmt.Errorf("sag: unsupported flags %#04x", unknown)
+	}
+	return header, nil
+}
+
+// readHeader reads the SAG header, including the optional fields of version 2 files.
+func readHeader(r io.Reader) (Header, error) {
+	var header Header
+	data := make([]byte, headerSizeV1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return header, truncated(err)
+	}
+	copy(header.Signature[:], data[0:3])
+	header.Version = data[3]
+	copy(header.ColorPalette[:], data[12:])
+
+	if string(header.Signature[:]) != "SAG" {
+		return header, ErrBadSignature
+	}
+	// Later versions may change the layout, reading them as an older one would
+	// produce garbage
+	if header.Version < Version || header.Version > Version2 {
+		return header, fmt.Errorf("%w %d, upgrade the tool", ErrUnsupportedVersion, header.Version)
+	}
+
+	if header.Version >= Version2 {
+		if err := binary.Read(r, binary.BigEndian, &header.Flags); err != nil {
+			return header, truncated(err)
+		}
+	}
+
+	// The flags tell the byte order of the fields in front of the palette
+	order := header.byteOrder()
+	header.Width = order.Uint16(data[4:])
+	header.Height = order.Uint16(data[6:])
+	header.FrameCount = order.Uint16(data[8:])
+	header.FrameDelay = order.Uint16(data[10:])
+
+	if header.Flags&FlagPadded != 0 {
+		if err := binary.Read(r, order, &header.RealWidth); err != nil {
+			return header, truncated(err)
+		}
+	}
+	if header.Flags&FlagTitle != 0 {
+		title, err := readShortString(r)
+		if err != nil {
+			return header, truncated(err)
+		}
+		header.Title = title
+	}
+	if header.Flags&FlagCycle != 0 {
+		cycle := []*uint16{&header.CycleStart, &header.CycleCount, &header.CycleDelay}
+		for _, field := range cycle {
+			if err := binary.Read(r, order, field); err != nil {
+				return header, truncated(err)
+			}
+		}
+	}
+	if header.Flags&FlagColors != 0 {
+		if err := binary.Read(r, order, &header.Colors); err != nil {
+			return header, truncated(err)
+		}
+		if header.Colors < 1 || header.Colors > 256 {
+			return header, fmt.Errorf("sag: palette size of %d colors is outside 1 to 256", header.Colors)
+		}
+	}
+	if header.Flags&FlagTimeBase != 0 {
+		if err := binary.Read(r, order, &header.TimeBase); err != nil {
+			return header, truncated(err)
+		}
+		if header.TimeBase == 0 {
+			return header, errors.New("sag: time base of 0 ms")
+		}
+	}
+	if header.Flags&FlagMetadata != 0 {
+		metadata, err := readMetadata(r)
+		if err != nil {
+			return header, truncated(err)
+		}
+		header.Metadata = metadata
+	}
+	return header, nil
+}
+
+// metadataSize returns the number of bytes the metadata take in the header.
+func metadataSize(metadata []MetaEntry) int {
+	size := 1 // Number of pairs
+	for _, entry := range metadata {
+		size += 2 + len(entry.Key) + len(entry.Value)
+	}
+	return size
+}
+
+// readMetadata reads the key/value pairs of FlagMetadata.
+func readMetadata(r io.Reader) ([]MetaEntry, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+	metadata := make([]MetaEntry, count[0])
+	for i := range metadata {
+		var err error
+		if metadata[i].Key, err = readShortString(r); err != nil {
+			return nil, err
+		}
+		if metadata[i].Value, err = readShortString(r); err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// readShortString reads a string prefixed by its length as a single byte.
+func readShortString(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	data := make([]byte, length[0])
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// rowSize returns the number of bytes of a stored row of the given width.
+func rowSize(width int, flags uint16) int {
+	identical := (width + 7) / 8 // One identical-byte per block
+	if flags&FlagNoDelta != 0 {
+		identical = 0
+	}
+	if flags&FlagPacked4 != 0 {
+		// Every full block packs into 4 bytes, the last one into (n+1)/2
+		rest := width % 8
+		return identical + width/8*4 + (rest+1)/2
+	}
+	return identical + width
+}
@@ -0,0 +1,96 @@
+package sag
+
+import (
+	"fmt"
+	"io"
+)
+
+// Info describes a SAG file for tools, with the field names of its JSON
+// encoding fixed, so scripts can rely on them.
+type Info struct {
+	Version    int      `json:"version"`
+	Width      int      `json:"width"` // Without padding
+	Height     int      `json:"height"`
+	FrameCount int      `json:"frame_count"`
+	FrameDelay int      `json:"frame_delay"` // Delay of the header in milliseconds
+	TimeBase   int      `json:"time_base"`   // Milliseconds per stored delay unit
+	Flags      uint16   `json:"flags"`
+	Title      string   `json:"title"`
+	CycleStart int      `json:"cycle_start"`
+	CycleCount int      `json:"cycle_count"` // 0 without palette cycling
+	CycleDelay int      `json:"cycle_delay"`
+	Palette    []string `json:"palette"` // Colors of the header as "#rrggbb"
+	Delays     []int    `json:"delays"`  // Delay of every frame in milliseconds
+
+	Metadata map[string]string `json:"metadata,omitempty"` // Key/value pairs of FlagMetadata
+
+	UsedIndices int   `json:"used_indices"` // Number of distinct palette indices of all frames
+	FileSize    int64 `json:"file_size"`    // Size of the file in bytes
+	RawSize     int64 `json:"raw_size"`     // Size without any encoding: 768 palette bytes and one byte per pixel
+}
+
+// Inspect reads the SAG file in r to its end and describes it.
+func Inspect(r io.Reader) (Info, error) {
+	cr := &countingReader{r: r}
+	d, err := NewDecoder(cr)
+	if err != nil {
+		return Info{}, err
+	}
+	header := d.Header()
+
+	info := Info{
+		Version:    int(header.Version),
+		Width:      int(header.Width),
+		Height:     int(header.Height),
+		FrameCount: int(header.FrameCount),
+		FrameDelay: int(header.FrameDelay) * header.DelayUnit(),
+		TimeBase:   header.DelayUnit(),
+		Flags:      header.Flags,
+		Title:      header.Title,
+		CycleStart: int(header.CycleStart),
+		CycleCount: int(header.CycleCount),
+		CycleDelay: int(header.CycleDelay),
+		Delays:     []int{},
+	}
+	if header.Flags&FlagPadded != 0 {
+		info.Width = int(header.RealWidth)
+	}
+	if len(header.Metadata) > 0 {
+		info.Metadata = make(map[string]string, len(header.Metadata))
+		for _, entry := range header.Metadata {
+			info.Metadata[entry.Key] = entry.Value
+		}
+	}
+	for _, c := range header.Palette() {
+		rgb := storedRGB(c)
+		info.Palette = append(info.Palette, fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]))
+	}
+
+	var used [256]bool
+	for {
+		frame, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Info{}, err
+		}
+		info.Delays = append(info.Delays, frame.Delay)
+		for _, index := range frame.Image.Pix {
+			used[index] = true
+		}
+	}
+	for _, u := range used {
+		if u {
+			info.UsedIndices++
+		}
+	}
+
+	// Data after the last frame still belongs to the file
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return Info{}, err
+	}
+	info.FileSize = cr.n
+	info.RawSize = 768 + int64(info.Width)*int64(info.Height)*int64(info.FrameCount)
+	return info, nil
+}
@@ -0,0 +1,70 @@
+package sag
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInspectJSON(t *testing.T) {
+	frames, palette := testFrames(10, 4, 3)
+	for _, frame := range frames {
+		// Only indices 0 and 2 are used
+		for i := range frame.Pix {
+			frame.Pix[i] &= 2
+		}
+	}
+	opts := &Options{FrameDelays: true, PaletteSize: true, Pad8: true, Title: "known"}
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100, 40, 250}, palette, opts); err != nil {
+		t.Fatal(err)
+	}
+	fileSize := int64(buf.Len())
+
+	info, err := Inspect(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Info
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Info{
+		Version:     2,
+		Width:       10,
+		Height:      4,
+		FrameCount:  3,
+		FrameDelay:  100,
+		TimeBase:    1,
+		Flags:       FlagFrameDelays | FlagPadded | FlagTitle | FlagColors,
+		Title:       "known",
+		Palette:     []string{"#000000", "#ff0000", "#00ff00", "#0000ff"},
+		Delays:      []int{100, 40, 250},
+		UsedIndices: 2,
+		FileSize:    fileSize,
+		RawSize:     768 + 10*4*3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Info = %+v\nwant %+v", got, want)
+	}
+
+	// The field names are part of the schema
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"version", "width", "height", "frame_count", "frame_delay", "time_base", "flags", "title", "cycle_start", "cycle_count", "cycle_delay", "palette", "delays", "used_indices", "file_size", "raw_size"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("JSON has no field %q", name)
+		}
+	}
+	if len(fields) != 16 {
+		t.Errorf("JSON has %d fields, want 16", len(fields))
+	}
+}
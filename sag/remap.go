@@ -0,0 +1,40 @@
+package sag
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// RemapPalette copies the SAG file in r to w with the palette entries in
+// colors replaced, e.g. to swap the background color of a finished file. The
+// header is rewritten and the frame data copied byte by byte, so the frames
+// keep exactly their indices.
+func RemapPalette(w io.Writer, r io.Reader, colors map[int]color.Color) error {
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if header.Flags&FlagLocalPalettes != 0 {
+		return errors.New("sag: the frames use local palettes, the palette of the header is not used")
+	}
+
+	size := 256
+	if header.Flags&FlagColors != 0 {
+		size = int(header.Colors)
+	}
+	for index, c := range colors {
+		if index < 0 || index >= size {
+			return fmt.Errorf("sag: palette index %d outside the palette of %d colors", index, size)
+		}
+		rgb := storedRGB(c)
+		copy(header.ColorPalette[index*3:], rgb[:])
+	}
+
+	if err := writeHeader(w, header); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
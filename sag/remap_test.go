@@ -0,0 +1,46 @@
+package sag
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestRemapPalette(t *testing.T) {
+	frames, palette := testFrames(12, 4, 3)
+	var src bytes.Buffer
+	if err := EncodeWithOptions(&src, frames, []int{50, 50, 50}, palette, &Options{RowSkip: true, PaletteSize: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	var dst bytes.Buffer
+	if err := RemapPalette(&dst, bytes.NewReader(src.Bytes()), map[int]color.Color{0: white}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first palette entry of the header differs
+	offset := 12
+	want := append([]byte(nil), src.Bytes()...)
+	copy(want[offset:], []byte{255, 255, 255})
+	if !bytes.Equal(dst.Bytes(), want) {
+		t.Error("remapped file differs in more than the palette entry")
+	}
+
+	decoded, _, err := Decode(&dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(decoded[i].Pix, frame.Pix) {
+			t.Errorf("frame %d indices changed", i)
+		}
+		if decoded[i].Palette[0] != white || decoded[i].Palette[1] != palette[1] {
+			t.Errorf("frame %d palette = %v, want white followed by %v", i, decoded[i].Palette[:2], palette[1])
+		}
+	}
+
+	if err := RemapPalette(&dst, bytes.NewReader(src.Bytes()), map[int]color.Color{4: white}); err == nil {
+		t.Error("remapped index 4 of a 4-color palette")
+	}
+}
@@ -0,0 +1,240 @@
+// Package sag implements the SAG animation format played by the pico75player.
+//
+// A SAG file starts with a fixed-size header holding the dimensions, the frame
+// count, the frame delay and a global 256-color palette. It is followed by the
+// frame data: every row is split into blocks of 8 pixels, each block is prefixed
+// by an identical-byte whose bits mark the pixels that did not change since the
+// previous frame, followed by the palette indices of the block.
+//
+// Version 2 files add a uint16 Flags field after the palette, every flag
+// enables one optional feature:
+//
+// FlagRowSkip prefixes every frame with a mode byte. FrameFull frames are
+// stored as in version 1. FrameRowSkip frames start with a bitmap of
+// (height+7)/8 bytes, one bit per row with the most significant bit first,
+// where a set bit marks a changed row. Only the changed rows follow, the
+// others are copied from the previous frame.
+//
+// FlagPacked4 stores two 4-bit palette indices per byte, the first pixel in
+// the high nibble. A block of n pixels takes (n+1)/2 bytes, the low nibble of
+// the last byte is 0 for an odd n. The palette has at most 16 colors.
+//
+// FlagFrameDelays prefixes every frame with its own delay in milliseconds as
+// a uint16, in front of the mode byte of FlagRowSkip. FrameDelay in the header
+// holds the delay of the first frame.
+//
+// FlagPadded marks frames whose width was padded to a multiple of 8. Width
+// holds the padded width and a uint16 RealWidth follows the Flags field, the
+// columns from RealWidth on are padding and are cropped by the decoder.
+//
+// FlagTitle stores a UTF-8 title of at most MaxTitleLength bytes after the
+// other header fields, prefixed by its length as a single byte.
+//
+// FlagCycle marks CycleCount palette entries from CycleStart on as a range
+// the player rotates by one entry every CycleDelay milliseconds, for palette
+// cycling effects without extra frames. The three uint16 fields follow the
+// title. The frame data is unchanged.
+//
+// FlagInterleaved stores the rows across frames instead of frame by frame:
+// row 0 of every frame, then row 1 of every frame and so on, so a panel can
+// refresh one row of the whole animation at a time. Every row is encoded as
+// in the frame-major layout, against the same row of the previous frame. With
+// FlagFrameDelays the delays of all frames come first, in frame order. The
+// flag cannot be combined with FlagRowSkip.
+//
+// FlagColors stores the number of used palette entries as a uint16 after the
+// cycling range. The decoder returns a palette of exactly that many colors
+// instead of 256, and pixel indices beyond it are an error. The unused
+// entries of the palette field are 0.
+//
+// FlagDeflate compresses all frame data following the header as a single raw
+// DEFLATE stream (RFC 1951). The frames inside it are encoded as without the
+// flag, so the delta encoding is applied first. Frames of compressed files
+// can only be decoded sequentially.
+//
+// FlagLocalPalettes gives every frame a palette of its own, stored after the
+// delay of FlagFrameDelays as the number of colors minus 1 in a single byte,
+// followed by 3 bytes RGB per color. The pixels of a frame index its own
+// palette and a pixel counts as unchanged in the identical-byte if its color
+// is the same as in the previous frame, whatever its index. The palette in
+// the header is not used for the frames. The flag cannot be combined with
+// FlagRowSkip or FlagInterleaved.
+//
+// FlagTimeBase stores a uint16 TimeBase after the palette size of FlagColors.
+// All frame delays, the one in the header and those of FlagFrameDelays, are
+// then counted in units of TimeBase milliseconds instead of milliseconds, so
+// pauses longer than MaxValue milliseconds fit. CycleDelay stays in
+// milliseconds.
+//
+// FlagNoDelta leaves out the identical-bytes: every row holds only the
+// palette indices of its pixels, which saves one byte per 8 pixels where the
+// delta encoding has nothing to compare, as for a still image. Frames do not
+// refer to the previous one, except for rows skipped with FlagRowSkip.
+//
+// FlagMetadata stores key/value pairs of text, e.g. the author or the source
+// of the animation, after TimeBase: the number of pairs in a single byte,
+// followed by the key and the value of every pair, each prefixed by its
+// length as a single byte like the title. Keys are unique and not empty, and
+// the whole block takes at most MaxMetadataSize bytes.
+//
+// All multi-byte fields are big-endian, unless FlagLittleEndian is set: then
+// every uint16 except the Flags field itself is stored little-endian, the
+// fixed fields in front of the palette as well as the optional header fields
+// and the frame delays, so little-endian players can read them in place. The
+// Flags field stays big-endian, so the byte order is known before the other
+// fields are interpreted.
+package sag
+
+// Format versions.
+const (
+	Version  = 0x01 // Version written by Encode
+	Version2 = 0x02 // Version with the Flags field after the palette
+)
+
+// Flags of version 2 files.
+const (
+	FlagRowSkip       uint16 = 1 << iota // Frames start with a mode byte and may skip unchanged rows
+	FlagPacked4                          // Pixel blocks hold two 4-bit indices per byte
+	FlagFrameDelays                      // Frames start with their own delay
+	FlagPadded                           // Width is padded to a multiple of 8, RealWidth follows Flags
+	FlagTitle                            // A length-prefixed title follows the other header fields
+	FlagCycle                            // A palette cycling range follows the title
+	FlagInterleaved                      // Rows are stored across frames instead of frame by frame
+	FlagColors                           // The number of used palette entries follows the cycling range
+	FlagDeflate                          // The frame data is compressed with DEFLATE
+	FlagLocalPalettes                    // Every frame starts with its own palette
+	FlagTimeBase                         // Delays are stored in units of TimeBase milliseconds
+	FlagLittleEndian                     // Multi-byte fields other than Flags are little-endian
+	FlagNoDelta                          // Rows hold no identical-bytes, only the pixel indices
+	FlagMetadata                         // Key/value pairs follow the other header fields
+)
+
+// MaxTitleLength is the maximum length of a title in bytes.
+const MaxTitleLength = 255
+
+// MaxMetadataSize is the maximum size of the metadata block in bytes,
+// including the count and the length bytes.
+const MaxMetadataSize = 1024
+
+// knownFlags are the flags this package can decode.
+const knownFlags = FlagRowSkip | FlagPacked4 | FlagFrameDelays | FlagPadded | FlagTitle | FlagCycle | FlagInterleaved | FlagColors | FlagDeflate | FlagLocalPalettes | FlagTimeBase | FlagLittleEndian | FlagNoDelta | FlagMetadata
+
+// Frame modes of files with FlagRowSkip.
+const (
+	FrameFull    = 0x00 // All rows are stored
+	FrameRowSkip = 0x01 // A row bitmap is followed by the changed rows only
+)
+
+// Options controls optional format features of the encoder. Each of them
+// writes a version 2 file, which the pico75player firmware cannot read yet.
+type Options struct {
+	// RowSkip allows frames to skip the rows that did not change since the
+	// previous frame. EncodeWithOptions only uses it if it makes the file smaller.
+	RowSkip bool
+
+	// BitsPerPixel is 8 (or 0) for one byte per pixel, or 4 to pack two
+	// pixels into a byte, which needs a palette of at most 16 colors.
+	BitsPerPixel int
+
+	// FrameDelays stores the delay of every frame instead of one delay for
+	// all of them. EncodeWithOptions only uses it if the delays differ.
+	FrameDelays bool
+
+	// Pad8 pads the width to the next multiple of 8 with PadIndex, so every
+	// row consists of full blocks. The real width is stored in the header.
+	Pad8     bool
+	PadIndex uint8
+
+	// Title is a human-readable name of the animation, e.g. for a slideshow.
+	// It must be valid UTF-8 of at most MaxTitleLength bytes.
+	Title string
+
+	// CycleCount > 0 marks the palette entries CycleStart to
+	// CycleStart+CycleCount-1 for palette cycling, rotated by one entry every
+	// CycleDelay milliseconds. The range must have at least 2 entries.
+	CycleStart int
+	CycleCount int
+	CycleDelay int
+
+	// SkipTransparent treats the palette entries with an alpha of 0 as
+	// transparent: a pixel that is transparent in the previous and the current
+	// frame counts as unchanged, even if its index differs. The identical-bytes
+	// and row skipping then leave transparent regions alone; a skipped row
+	// keeps the transparent indices of the previous frame.
+	SkipTransparent bool
+
+	// Interleaved stores row 0 of all frames, then row 1 of all frames and
+	// so on. The Encoder keeps all frames until the last one is written, and
+	// the Decoder reads all of them on the first ReadFrame. It cannot be
+	// combined with RowSkip.
+	Interleaved bool
+
+	// PaletteSize stores the number of palette colors, so a 16-color
+	// animation decodes with a 16-color palette instead of one padded with
+	// black to 256 entries.
+	PaletteSize bool
+
+	// Deflate compresses the frame data with DEFLATE for the smallest files
+	// on flash. The compressed stream is completed with the last frame.
+	Deflate bool
+
+	// LocalPalettes stores the palette of every frame with it, so each frame
+	// can use up to 256 colors of its own, e.g. for a day to night fade. The
+	// palette passed to the encoder only goes into the header. It cannot be
+	// combined with RowSkip or Interleaved.
+	LocalPalettes bool
+
+	// TimeBase is the number of milliseconds per stored delay unit, 0 or 1
+	// for milliseconds. Delays are rounded to the nearest multiple of it.
+	// EncodeWithOptions picks the smallest time base that fits if a delay
+	// exceeds MaxValue milliseconds.
+	TimeBase int
+
+	// LittleEndian stores the multi-byte fields little-endian, for players
+	// that map the header into memory on a little-endian CPU.
+	LittleEndian bool
+
+	// NoDelta stores the rows without identical-bytes, for still images and
+	// other frames that have too little in common with the previous one.
+	NoDelta bool
+
+	// Metadata are key/value pairs stored in the header in the given order,
+	// e.g. author, source or creation date. The keys must be unique and not
+	// empty, keys and values valid UTF-8 of at most 255 bytes each.
+	Metadata []MetaEntry
+}
+
+// MetaEntry is a key/value pair of the metadata of FlagMetadata.
+type MetaEntry struct {
+	Key   string
+	Value string
+}
+
+// Header represents the header of a SAG file.
+type Header struct {
+	Signature    [3]byte     // "SAG"
+	Version      byte        // Version 1.0 = 0x01
+	Width        uint16      // Width of the image in pixels
+	Height       uint16      // Height of the image in pixels
+	FrameCount   uint16      // Number of frames
+	FrameDelay   uint16      // Duration of every frame in milliseconds
+	ColorPalette [768]byte   // Global color palette (256 colors, 3 bytes RGB each)
+	Flags        uint16      // Optional features, only stored in version 2 files
+	RealWidth    uint16      // Width without padding, only stored with FlagPadded
+	Title        string      // Title of the animation, only stored with FlagTitle
+	CycleStart   uint16      // First palette index of the cycling range, only stored with FlagCycle
+	CycleCount   uint16      // Number of palette entries in the cycling range
+	CycleDelay   uint16      // Milliseconds per rotation step of the cycling range
+	Colors       uint16      // Number of used palette entries, only stored with FlagColors
+	TimeBase     uint16      // Milliseconds per delay unit, only stored with FlagTimeBase
+	Metadata     []MetaEntry // Key/value pairs, only stored with FlagMetadata
+}
+
+// DelayUnit returns the number of milliseconds per stored delay unit:
+// TimeBase with FlagTimeBase, otherwise 1.
+func (h Header) DelayUnit() int {
+	if h.Flags&FlagTimeBase != 0 {
+		return int(h.TimeBase)
+	}
+	return 1
+}
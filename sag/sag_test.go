@@ -0,0 +1,1375 @@
+package sag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testFrames returns count frames of w×h pixels with a simple moving pattern.
+func testFrames(w, h, count int) ([]*image.Paletted, []color.Color) {
+	palette := []color.Color{
+		color.RGBA{A: 255},
+		color.RGBA{R: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+	}
+	frames := make([]*image.Paletted, count)
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				frame.SetColorIndex(x, y, uint8((x+y+i)%len(palette)))
+			}
+		}
+		frames[i] = frame
+	}
+	return frames, palette
+}
+
+func TestEncodedSize(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {8, 8}, {75, 20}} {
+		frames, palette := testFrames(size.X, size.Y, 3)
+		delays := []int{10, 10, 10}
+
+		estimate, err := EncodedSize(frames, delays, palette, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filename := filepath.Join(t.TempDir(), "out.sag")
+		file, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(file, frames, delays, palette); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate != info.Size() {
+			t.Errorf("%v: estimate %d != file size %d", size, estimate, info.Size())
+		}
+	}
+}
+
+func TestDelayRoundTrip(t *testing.T) {
+	for _, delay := range []int{10, 15, 40, 1000} {
+		frames, palette := testFrames(4, 4, 2)
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, frames, []int{delay, delay}, palette); err != nil {
+			t.Fatal(err)
+		}
+		_, delays, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, got := range delays {
+			if got != delay {
+				t.Errorf("delay %d: frame %d has delay %d after round trip", delay, i, got)
+			}
+		}
+	}
+}
+
+// checkRoundTrip encodes the frames, decodes them again and compares every pixel.
+func checkRoundTrip(t *testing.T, frames []*image.Paletted, delays []int, palette []color.Color) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedDelays, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != len(frames) {
+		t.Fatalf("decoded %d frames, want %d", len(decoded), len(frames))
+	}
+	for i, frame := range frames {
+		if decodedDelays[i] != delays[0] {
+			t.Errorf("frame %d: delay %d, want %d", i, decodedDelays[i], delays[0])
+		}
+		if got, want := decoded[i].Bounds(), frame.Bounds(); got != want {
+			t.Fatalf("frame %d: bounds %v, want %v", i, got, want)
+		}
+		bounds := frame.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if got, want := decoded[i].ColorIndexAt(x, y), frame.ColorIndexAt(x, y); got != want {
+					t.Fatalf("frame %d pixel (%d,%d): index %d, want %d", i, x, y, got, want)
+				}
+				r1, g1, b1, _ := decoded[i].At(x, y).RGBA()
+				r2, g2, b2, _ := frame.At(x, y).RGBA()
+				if r1>>8 != r2>>8 || g1>>8 != g2>>8 || b1>>8 != b2>>8 {
+					t.Fatalf("frame %d pixel (%d,%d): color %v, want %v", i, x, y, decoded[i].At(x, y), frame.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		frames        int
+	}{
+		{"1x1", 1, 1, 1},
+		{"1x1 animated", 1, 1, 3},
+		{"single frame", 16, 16, 1},
+		{"width 7", 7, 3, 2},
+		{"width 9", 9, 2, 2},
+		{"width 75", 75, 4, 3},
+		{"multiple of 8", 64, 8, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, palette := testFrames(tt.width, tt.height, tt.frames)
+			delays := make([]int, tt.frames)
+			for i := range delays {
+				delays[i] = 100
+			}
+			checkRoundTrip(t, frames, delays, palette)
+		})
+	}
+}
+
+// FuzzSAGRoundTrip encodes random small frame sets with random palettes and
+// checks that decoding them yields the same frames again.
+func FuzzSAGRoundTrip(f *testing.F) {
+	f.Add(uint8(1), uint8(1), uint8(1), []byte{0, 0, 0, 0})
+	f.Add(uint8(75), uint8(2), uint8(3), []byte("pico75player"))
+	f.Add(uint8(8), uint8(8), uint8(2), []byte{255, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+
+	f.Fuzz(func(t *testing.T, width, height, frameCount uint8, data []byte) {
+		if width == 0 || height == 0 || frameCount == 0 || len(data) == 0 {
+			t.Skip()
+		}
+		w, h, n := int(width)%80+1, int(height)%16+1, int(frameCount)%4+1
+
+		// The first byte selects the palette size, the rest seeds the colors and pixels
+		palette := make([]color.Color, int(data[0])+1)
+		for i := range palette {
+			b := data[(i*3)%len(data)]
+			palette[i] = color.RGBA{R: b, G: b ^ uint8(i), B: uint8(i) * 7, A: 255}
+		}
+
+		frames := make([]*image.Paletted, n)
+		for i := range frames {
+			frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+			for j := range frame.Pix {
+				frame.Pix[j] = uint8(int(data[(i*len(frame.Pix)+j)%len(data)]) % len(palette))
+			}
+			frames[i] = frame
+		}
+
+		delays := make([]int, n)
+		for i := range delays {
+			delays[i] = int(data[len(data)-1]) * 4
+		}
+
+		checkRoundTrip(t, frames, delays, palette)
+	})
+}
+
+func TestRoundTripWidth75(t *testing.T) {
+	// Every column gets its own index, so dropped or shifted columns show up
+	palette := make([]color.Color, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i), G: uint8(255 - i), B: uint8(i * 3), A: 255}
+	}
+	frames := make([]*image.Paletted, 2)
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, 75, 3), palette)
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 75; x++ {
+				frame.SetColorIndex(x, y, uint8(x+y*75+i))
+			}
+		}
+		frames[i] = frame
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{50, 50}, palette); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 blocks per row: 9 full blocks and a partial block of 3 pixels
+	if want := 12 + 768 + 2*3*(10+75); buf.Len() != want {
+		t.Errorf("encoded size = %d, want %d", buf.Len(), want)
+	}
+
+	checkRoundTrip(t, frames, []int{50, 50}, palette)
+}
+
+func TestPalette16BitRounding(t *testing.T) {
+	const gray = 0x12f0
+	palette := []color.Color{color.Gray16{Y: gray}, color.Gray16{Y: 0xffff}}
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 1, 1), palette)}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, _, _ := decoded[0].Palette[0].RGBA()
+	truncated := uint32(gray>>8) * 0x101
+	if diff(r, gray) >= diff(truncated, gray) {
+		t.Errorf("round trip gave %#04x, not closer to %#04x than truncation %#04x", r, gray, truncated)
+	}
+	if r, _, _, _ := decoded[0].Palette[1].RGBA(); r != 0xffff {
+		t.Errorf("white round-tripped to %#04x", r)
+	}
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestRowSkipBottomQuarter(t *testing.T) {
+	const w, h = 16, 16
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}}
+	frames := make([]*image.Paletted, 4)
+	delays := make([]int, len(frames))
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				index := (x + y) % 2
+				if y >= h*3/4 {
+					index = (x + y + i) % 3
+				}
+				frame.SetColorIndex(x, y, uint8(index))
+			}
+		}
+		frames[i] = frame
+		delays[i] = 100
+	}
+
+	var plain, skipped bytes.Buffer
+	if err := Encode(&plain, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeWithOptions(&skipped, frames, delays, palette, &Options{RowSkip: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := skipped.Bytes()[3]; v != Version2 {
+		t.Fatalf("version = %#02x, want %#02x", v, Version2)
+	}
+	rowSize := (w+7)/8 + w
+	frameSize := h * rowSize
+	want := 12 + 768 + 2 + (1 + frameSize) + 3*(1+(h+7)/8+h/4*rowSize)
+	if skipped.Len() != want {
+		t.Errorf("row-skip size = %d, want %d", skipped.Len(), want)
+	}
+	if skipped.Len() >= plain.Len() {
+		t.Errorf("row-skip size %d is not smaller than %d", skipped.Len(), plain.Len())
+	}
+
+	decoded, _, err := Decode(&skipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range frames {
+		if !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+			t.Errorf("frame %d differs after decoding", i)
+		}
+	}
+}
+
+func TestRowSkipFallsBackToVersion1(t *testing.T) {
+	// Every row changes in every frame, so row skipping cannot save anything
+	frames, palette := testFrames(9, 4, 3)
+	delays := []int{100, 100, 100}
+
+	var plain, skipped bytes.Buffer
+	if err := Encode(&plain, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeWithOptions(&skipped, frames, delays, palette, &Options{RowSkip: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain.Bytes(), skipped.Bytes()) {
+		t.Error("row-skip output differs from version 1 output although it cannot save space")
+	}
+}
+
+func TestReadFrameAt(t *testing.T) {
+	frames, palette := testFrames(75, 6, 5)
+	delays := []int{100, 100, 100, 100, 100}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	decoded, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame, err := ReadFrameAt(bytes.NewReader(data), header, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Pix, decoded[3].Pix) {
+		t.Error("ReadFrameAt(3) differs from the sequentially decoded frame 3")
+	}
+
+	if _, err := ReadFrameAt(bytes.NewReader(data), header, 5); err == nil {
+		t.Error("ReadFrameAt(5) of 5 frames succeeded, want error")
+	}
+}
+
+func TestEncodeOversized(t *testing.T) {
+	palette := []color.Color{color.RGBA{A: 255}}
+
+	wide := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 70000, 1), palette)}
+	var buf bytes.Buffer
+	err := Encode(&buf, wide, []int{100}, palette)
+	if err == nil || !strings.Contains(err.Error(), "width of 70000") {
+		t.Errorf("70000 pixels wide: err = %v, want width error", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written for an oversized image, want none", buf.Len())
+	}
+
+	// All frames share one tiny image, only the count matters
+	frame := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	many := make([]*image.Paletted, 70000)
+	for i := range many {
+		many[i] = frame
+	}
+	delays := make([]int, len(many))
+	err = Encode(&buf, many, delays, palette)
+	if err == nil || !strings.Contains(err.Error(), "70000 frames") {
+		t.Errorf("70000 frames: err = %v, want frame count error", err)
+	}
+
+	if err := EncodeWithOptions(&buf, []*image.Paletted{frame}, []int{70000}, palette, &Options{TimeBase: 1}); err == nil {
+		t.Error("delay of 70000 ms in milliseconds succeeded, want error")
+	}
+
+	// Without options the delay gets a time base, like with empty options
+	buf.Reset()
+	if err := Encode(&buf, []*image.Paletted{frame}, []int{70000}, palette); err != nil {
+		t.Fatalf("delay of 70000 ms: %v", err)
+	}
+	if _, delays, err := Decode(&buf); err != nil || delays[0] != 70000 {
+		t.Errorf("decoded delays %v, %v, want [70000]", delays, err)
+	}
+}
+
+func TestPacked4RoundTrip(t *testing.T) {
+	const w, h = 13, 6
+	palette := make([]color.Color, 16)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i * 16), G: uint8(255 - i*16), A: 255}
+	}
+	frames := make([]*image.Paletted, 3)
+	delays := make([]int, len(frames))
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for j := range frames[i].Pix {
+			frames[i].Pix[j] = uint8((j*7 + i) % 16)
+		}
+		delays[i] = 100
+	}
+
+	var plain, packed bytes.Buffer
+	if err := Encode(&plain, frames, delays, palette); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeWithOptions(&packed, frames, delays, palette, &Options{BitsPerPixel: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 blocks per row: 8 pixels in 4 bytes, 5 pixels in 3 bytes
+	plainData := plain.Len() - 12 - 768
+	packedData := packed.Len() - 12 - 768 - 2
+	if want := len(frames) * h * (2 + 4 + 3); packedData != want {
+		t.Errorf("packed frame data = %d bytes, want %d", packedData, want)
+	}
+	if ratio := float64(packedData) / float64(plainData); ratio > 0.6 {
+		t.Errorf("packed frame data is %.2f of the 8-bit size, want about half", ratio)
+	}
+
+	decoded, _, err := Decode(&packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range frames {
+		if !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+			t.Errorf("frame %d differs after decoding", i)
+		}
+	}
+}
+
+func TestPacked4TooManyColors(t *testing.T) {
+	palette := make([]color.Color, 17)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i)}
+	}
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 2, 2), palette)}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100}, palette, &Options{BitsPerPixel: 4}); err == nil {
+		t.Error("4 bits per pixel with 17 colors succeeded, want error")
+	}
+}
+
+func TestFrameDelays(t *testing.T) {
+	frames, palette := testFrames(9, 3, 3)
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{30, 250, 1000}, palette, &Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	_, delays, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 3 || delays[0] != 30 || delays[1] != 250 || delays[2] != 1000 {
+		t.Errorf("delays = %v, want [30 250 1000]", delays)
+	}
+
+	header, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ReadFrameAt(bytes.NewReader(data), header, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Pix, frames[2].Pix) {
+		t.Error("ReadFrameAt(2) differs with per-frame delays")
+	}
+
+	// Equal delays need no per-frame delays
+	var plain, same bytes.Buffer
+	Encode(&plain, frames, []int{80, 80, 80}, palette)
+	EncodeWithOptions(&same, frames, []int{80, 80, 80}, palette, &Options{FrameDelays: true})
+	if !bytes.Equal(plain.Bytes(), same.Bytes()) {
+		t.Error("equal delays were stored per frame")
+	}
+}
+
+func TestPad8Width75(t *testing.T) {
+	frames, palette := testFrames(75, 4, 3)
+	delays := []int{100, 100, 100}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, delays, palette, &Options{Pad8: true, PadIndex: 2}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	header, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Width != 80 || header.RealWidth != 75 || header.Flags&FlagPadded == 0 {
+		t.Fatalf("header width %d, real width %d, flags %#04x, want 80, 75 and FlagPadded", header.Width, header.RealWidth, header.Flags)
+	}
+	if want := headerSizeV1 + 4 + 3*4*(10+80); len(data) != want {
+		t.Errorf("file size = %d, want %d", len(data), want)
+	}
+
+	// The padding columns hold the pad index
+	firstRow := data[headerSizeV1+4:]
+	lastBlock := firstRow[9*9 : 9*9+9]
+	for i, index := range lastBlock[1+3:] {
+		if index != 2 {
+			t.Errorf("padding pixel %d = %d, want 2", i, index)
+		}
+	}
+
+	decoded, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range frames {
+		if decoded[i].Bounds().Dx() != 75 || !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+			t.Errorf("frame %d differs after decoding", i)
+		}
+	}
+
+	frame, err := ReadFrameAt(bytes.NewReader(data), header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Pix, frames[1].Pix) {
+		t.Error("ReadFrameAt(1) differs from the padded frame")
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	frames, palette := testFrames(75, 6, 4)
+	delays := []int{100, 40, 40, 250}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, delays, palette, &Options{RowSkip: true, FrameDelays: true, Pad8: true}); err != nil {
+		t.Fatal(err)
+	}
+	wantFrames, wantDelays, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, errc := DecodeStream(bytes.NewReader(buf.Bytes()))
+	i := 0
+	for frame := range stream {
+		if i >= len(wantFrames) {
+			t.Fatalf("stream yields more than %d frames", len(wantFrames))
+		}
+		if frame.Delay != wantDelays[i] {
+			t.Errorf("frame %d: delay %d, want %d", i, frame.Delay, wantDelays[i])
+		}
+		if frame.Image.Bounds() != wantFrames[i].Bounds() || !bytes.Equal(frame.Image.Pix, wantFrames[i].Pix) {
+			t.Errorf("frame %d differs from Decode", i)
+		}
+		i++
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if i != len(wantFrames) {
+		t.Errorf("stream yields %d frames, want %d", i, len(wantFrames))
+	}
+}
+
+func TestDecodeStreamTruncated(t *testing.T) {
+	frames, palette := testFrames(8, 4, 3)
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100, 100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut off the last frame of 4 rows of 9 bytes
+	stream, errc := DecodeStream(bytes.NewReader(buf.Bytes()[:buf.Len()-36]))
+	n := 0
+	for range stream {
+		n++
+	}
+	if err := <-errc; err == nil {
+		t.Error("truncated file decoded without error")
+	}
+	if n != 2 {
+		t.Errorf("stream yields %d frames before the error, want 2", n)
+	}
+}
+
+func TestTitleRoundTrip(t *testing.T) {
+	const title = "Grüße aus Köln ☕"
+	frames, palette := testFrames(8, 4, 2)
+	delays := []int{100, 100}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, delays, palette, &Options{Title: title}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != Version2 || header.Flags != FlagTitle {
+		t.Errorf("version %d flags %#x, want version 2 with FlagTitle", header.Version, header.Flags)
+	}
+	if header.Title != title {
+		t.Errorf("title %q, want %q", header.Title, title)
+	}
+
+	decoded, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range frames {
+		if !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+			t.Errorf("frame %d differs after the title", i)
+		}
+	}
+}
+
+func TestTitleInvalid(t *testing.T) {
+	frames, palette := testFrames(8, 4, 1)
+	for _, title := range []string{strings.Repeat("ä", 128), "\xff"} {
+		if err := EncodeWithOptions(io.Discard, frames, []int{100}, palette, &Options{Title: title}); err == nil {
+			t.Errorf("title of %d bytes accepted", len(title))
+		}
+	}
+}
+
+func TestDecodeFutureVersion(t *testing.T) {
+	frames, palette := testFrames(8, 4, 1)
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[3] = 99
+
+	_, _, err := Decode(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "unsupported SAG version 99") {
+		t.Errorf("Decode of version 99 returned %v, want an unsupported version error", err)
+	}
+}
+
+func TestCycleRoundTrip(t *testing.T) {
+	frames, palette := testFrames(8, 4, 2)
+	opts := &Options{Title: "Wasser", CycleStart: 1, CycleCount: 3, CycleDelay: 120}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100, 100}, palette, opts); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := dec.Header()
+	if header.Flags != FlagTitle|FlagCycle {
+		t.Errorf("flags %#x, want FlagTitle|FlagCycle", header.Flags)
+	}
+	if header.CycleStart != 1 || header.CycleCount != 3 || header.CycleDelay != 120 || header.Title != "Wasser" {
+		t.Errorf("cycle %d,%d,%d title %q, want 1,3,120 and Wasser", header.CycleStart, header.CycleCount, header.CycleDelay, header.Title)
+	}
+	if err := Verify(bytes.NewReader(buf.Bytes()), frames); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCycleInvalid(t *testing.T) {
+	frames, palette := testFrames(8, 4, 1)
+	for _, opts := range []Options{
+		{CycleStart: 0, CycleCount: 1, CycleDelay: 100},
+		{CycleStart: 2, CycleCount: len(palette), CycleDelay: 100},
+		{CycleStart: 0, CycleCount: 2},
+	} {
+		if err := EncodeWithOptions(io.Discard, frames, []int{100}, palette, &opts); err == nil {
+			t.Errorf("cycle %d,%d,%d accepted", opts.CycleStart, opts.CycleCount, opts.CycleDelay)
+		}
+	}
+}
+
+func TestDecodeSentinelErrors(t *testing.T) {
+	frames, palette := testFrames(8, 4, 2)
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100, 100}, palette, &Options{Title: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	modified := func(f func([]byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"signature", modified(func(b []byte) []byte { b[0] = 'G'; return b }), ErrBadSignature},
+		{"version", modified(func(b []byte) []byte { b[3] = 7; return b }), ErrUnsupportedVersion},
+		{"empty", nil, ErrTruncated},
+		{"header", valid[:100], ErrTruncated},
+		{"title", valid[:headerSizeV1+4], ErrTruncated},
+		{"frame", valid[:len(valid)-9], ErrTruncated},
+	}
+	for _, tt := range tests {
+		_, _, err := Decode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Decode returned %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, _, err := Decode(bytes.NewReader(valid[:len(valid)-9])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated frame: %v does not wrap io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestSkipTransparent(t *testing.T) {
+	const w, h = 16, 8
+	// Two transparent entries, as left over from the local color tables of a GIF
+	palette := []color.Color{color.RGBA{}, color.NRGBA{R: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	frames := make([]*image.Paletted, 2)
+	delays := []int{100, 100}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				index := 2 + (x+y)%2
+				if y >= h/2 {
+					// Only the transparent index differs between the frames
+					index = i
+				}
+				frame.SetColorIndex(x, y, uint8(index))
+			}
+		}
+		frames[i] = frame
+	}
+
+	var plain, skipped bytes.Buffer
+	if err := EncodeWithOptions(&plain, frames, delays, palette, &Options{RowSkip: true}); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{RowSkip: true, SkipTransparent: true}
+	if err := EncodeWithOptions(&skipped, frames, delays, palette, opts); err != nil {
+		t.Fatal(err)
+	}
+	if skipped.Len() >= plain.Len() {
+		t.Errorf("size with SkipTransparent = %d, want less than %d", skipped.Len(), plain.Len())
+	}
+	if err := VerifyRoundTrip(frames, delays, palette, opts); err != nil {
+		t.Errorf("VerifyRoundTrip: %v", err)
+	}
+
+	// Without row skipping, the identical-bytes of the last row mark all pixels unchanged
+	var v1 bytes.Buffer
+	if err := EncodeWithOptions(&v1, frames, delays, palette, &Options{SkipTransparent: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := v1.Bytes()
+	for _, block := range [][]byte{data[len(data)-18 : len(data)-9], data[len(data)-9:]} {
+		if block[0] != 0xff {
+			t.Errorf("identical-byte = %08b, want all pixels unchanged", block[0])
+		}
+	}
+}
+
+func TestSkipTransparentOversizedPalette(t *testing.T) {
+	palette := make([]color.Color, 300)
+	for i := range palette {
+		palette[i] = color.RGBA{}
+	}
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 8, 1), palette)}
+	err := EncodeWithOptions(io.Discard, frames, []int{100}, palette, &Options{RowSkip: true, SkipTransparent: true})
+	if err == nil || !strings.Contains(err.Error(), "at most 256") {
+		t.Errorf("error = %v, want the palette size error", err)
+	}
+}
+
+func TestInterleavedRoundTrip(t *testing.T) {
+	const w, h = 13, 5
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	frames := make([]*image.Paletted, 3)
+	delays := []int{100, 40, 250}
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for j := range frames[i].Pix {
+			frames[i].Pix[j] = uint8((j*j + i) % len(palette))
+		}
+	}
+
+	decode := func(opts *Options) ([]*image.Paletted, []int, []byte) {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		data := append([]byte(nil), buf.Bytes()...)
+		decoded, decodedDelays, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded, decodedDelays, data
+	}
+	frameMajor, frameMajorDelays, frameMajorData := decode(&Options{FrameDelays: true, Pad8: true})
+	interleaved, interleavedDelays, interleavedData := decode(&Options{FrameDelays: true, Pad8: true, Interleaved: true})
+
+	if len(interleavedData) != len(frameMajorData) || bytes.Equal(interleavedData, frameMajorData) {
+		t.Errorf("interleaved file of %d bytes, want the same size as %d but another order", len(interleavedData), len(frameMajorData))
+	}
+	for i := range frames {
+		if !bytes.Equal(interleaved[i].Pix, frames[i].Pix) || !bytes.Equal(frameMajor[i].Pix, frames[i].Pix) {
+			t.Errorf("frame %d differs after decoding", i)
+		}
+		if interleavedDelays[i] != delays[i] || frameMajorDelays[i] != delays[i] {
+			t.Errorf("frame %d delay = %d and %d, want %d", i, interleavedDelays[i], frameMajorDelays[i], delays[i])
+		}
+	}
+
+	// Random access finds the rows of a frame by their offsets
+	header, err := readHeader(bytes.NewReader(interleavedData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ReadFrameAt(bytes.NewReader(interleavedData), header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Pix, frames[1].Pix) {
+		t.Error("ReadFrameAt(1) differs from frame 1")
+	}
+}
+
+func TestInterleavedRowSkip(t *testing.T) {
+	palette := []color.Color{color.Black, color.White}
+	if _, err := NewEncoder(io.Discard, 8, 8, 2, 100, palette, &Options{Interleaved: true, RowSkip: true}); err == nil {
+		t.Error("row skipping with the interleaved layout was accepted")
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	palette := []color.Color{color.Black, color.White}
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 8, 2), palette), image.NewPaletted(image.Rect(0, 0, 8, 2), palette)}
+	frames[1].Pix[3] = 1
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100, 100}, palette, &Options{PaletteSize: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Index 5 of the last pixel does not exist in a palette of 2 colors
+	badIndex := append([]byte(nil), data...)
+	badIndex[len(badIndex)-1] = 5
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"short file", data[:len(data)-3]},
+		{"index beyond the palette size", badIndex},
+		{"trailing data", append(append([]byte(nil), data...), 0, 0)},
+	} {
+		if _, _, err := DecodeStrict(bytes.NewReader(tc.data)); err == nil {
+			t.Errorf("%s: strict decoding succeeded, want an error", tc.name)
+		}
+		decoded, _, err := Decode(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Errorf("%s: lenient decoding failed: %v", tc.name, err)
+			continue
+		}
+		if len(decoded) != len(frames) {
+			t.Errorf("%s: lenient decoding returned %d frames, want %d", tc.name, len(decoded), len(frames))
+		}
+	}
+
+	// The lenient decoder pads the palette up to the index
+	decoded, _, _ := Decode(bytes.NewReader(badIndex))
+	if got := decoded[1].At(7, 1); got != color.Color(color.RGBA{A: 0xff}) {
+		t.Errorf("pixel beyond the palette = %v, want opaque black", got)
+	}
+	if _, _, err := DecodeStrict(bytes.NewReader(data)); err != nil {
+		t.Errorf("strict decoding of a valid file: %v", err)
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	frames, palette := testFrames(8, 2, 2)
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "trailing.sag")
+	if err := os.WriteFile(name, append(buf.Bytes(), 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded, _, err := DecodeFile(name, false); err != nil || len(decoded) != 2 {
+		t.Errorf("lenient: %d frames, %v, want 2 frames", len(decoded), err)
+	}
+	if _, _, err := DecodeFile(name, true); err == nil {
+		t.Error("strict: trailing data accepted, want an error")
+	}
+	if _, _, err := DecodeFile(filepath.Join(dir, "missing.sag"), false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	const w, h = 32, 16
+	palette := []color.Color{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}}
+	frames := make([]*image.Paletted, 6)
+	delays := []int{100, 100, 50, 50, 200, 100}
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				frames[i].SetColorIndex(x, y, uint8((x/4+y/4+i)%len(palette)))
+			}
+		}
+	}
+
+	for _, opts := range []*Options{
+		{Deflate: true},
+		{Deflate: true, RowSkip: true, FrameDelays: true},
+		{Deflate: true, Interleaved: true, BitsPerPixel: 4},
+	} {
+		var plain, compressed bytes.Buffer
+		uncompressed := *opts
+		uncompressed.Deflate = false
+		if err := EncodeWithOptions(&plain, frames, delays, palette, &uncompressed); err != nil {
+			t.Fatal(err)
+		}
+		if err := EncodeWithOptions(&compressed, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		if compressed.Len() >= plain.Len() {
+			t.Errorf("%+v: compressed size %d, want less than %d", *opts, compressed.Len(), plain.Len())
+		}
+		data := compressed.Bytes()
+
+		decoded, decodedDelays, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%+v: %v", *opts, err)
+		}
+		for i := range frames {
+			if !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+				t.Errorf("%+v: frame %d differs after decoding", *opts, i)
+			}
+			if opts.FrameDelays && decodedDelays[i] != delays[i] {
+				t.Errorf("%+v: frame %d delay = %d, want %d", *opts, i, decodedDelays[i], delays[i])
+			}
+		}
+
+		// Half of the compressed stream ends within an early frame
+		cut := headerSizeV1 + 2 + (len(data)-headerSizeV1-2)/2
+		if _, _, err := Decode(bytes.NewReader(data[:cut])); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%+v: decoding a truncated stream: %v, want ErrTruncated", *opts, err)
+		}
+	}
+}
+
+func TestLocalPalettesRoundTrip(t *testing.T) {
+	day := color.Palette{color.RGBA{R: 255, G: 255, A: 255}, color.RGBA{R: 135, G: 206, B: 235, A: 255}}
+	night := color.Palette{color.RGBA{B: 40, A: 255}, color.RGBA{R: 10, G: 10, B: 10, A: 255}, color.RGBA{R: 200, G: 200, B: 200, A: 255}}
+	// The last frame stores the night colors in another order
+	reordered := color.Palette{night[2], night[0], night[1]}
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 8, 2), day),
+		image.NewPaletted(image.Rect(0, 0, 8, 2), night),
+		image.NewPaletted(image.Rect(0, 0, 8, 2), reordered),
+	}
+	for i := range frames[0].Pix {
+		frames[0].Pix[i] = uint8(i % 2)
+		frames[1].Pix[i] = uint8(i % 3)
+		frames[2].Pix[i] = []uint8{1, 2, 0}[i%3]
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{100, 100, 100}, day, &Options{LocalPalettes: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	decoded, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(decoded[i].Pix, frame.Pix) {
+			t.Errorf("frame %d: indices %v, want %v", i, decoded[i].Pix, frame.Pix)
+		}
+		if !reflect.DeepEqual(decoded[i].Palette, frame.Palette) {
+			t.Errorf("frame %d: palette %v, want %v", i, decoded[i].Palette, frame.Palette)
+		}
+	}
+
+	// Every row of a frame is 9 bytes, the palette in front of it 1+3n
+	offset := headerSizeV1 + 2
+	for _, frame := range frames[:2] {
+		offset += 1 + 3*len(frame.Palette) + 2*9
+	}
+	offset += 1 + 3*len(reordered)
+	if got := data[offset]; got != 0xff {
+		t.Errorf("identical-byte of the reordered frame = %#02x, want 0xff", got)
+	}
+
+	if _, err := ReadFrameAt(bytes.NewReader(data), Header{FrameCount: 3, Flags: FlagLocalPalettes}, 1); err == nil {
+		t.Error("ReadFrameAt read a frame with a local palette")
+	}
+	for _, opts := range []*Options{{LocalPalettes: true, RowSkip: true}, {LocalPalettes: true, Interleaved: true}} {
+		if err := EncodeWithOptions(io.Discard, frames, []int{100, 100, 100}, day, opts); err == nil {
+			t.Errorf("%+v: no error", *opts)
+		}
+	}
+}
+
+func TestDecodeShortReads(t *testing.T) {
+	frames, palette := testFrames(13, 5, 4)
+	delays := []int{10, 20, 30, 40}
+	for _, opts := range []*Options{
+		nil,
+		{RowSkip: true, FrameDelays: true},
+		{BitsPerPixel: 4, Pad8: true, Title: "short reads"},
+		{Interleaved: true, PaletteSize: true},
+		{Deflate: true},
+	} {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		// A reader returning one byte per Read must not shift the pixels
+		decoded, _, err := DecodeStrict(iotest.OneByteReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		for i, frame := range frames {
+			if !bytes.Equal(decoded[i].Pix, frame.Pix) {
+				t.Errorf("%+v: frame %d differs after decoding", opts, i)
+			}
+		}
+		if err := Validate(iotest.HalfReader(bytes.NewReader(buf.Bytes())), Limits{MaxFileSize: int64(buf.Len())}); err != nil {
+			t.Errorf("%+v: validating: %v", opts, err)
+		}
+
+		// The end of the data within a block is still reported
+		short := iotest.OneByteReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+		if _, _, err := DecodeStrict(short); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%+v: decoding a short file: %v, want ErrTruncated", opts, err)
+		}
+	}
+}
+
+func TestTimeBase(t *testing.T) {
+	frames, palette := testFrames(9, 3, 3)
+
+	// A pause of 66 s does not fit into a uint16 of milliseconds
+	delays := []int{100, 66000, 250}
+	if err := EncodeWithOptions(io.Discard, frames, delays, palette, &Options{FrameDelays: true, TimeBase: 1}); err == nil {
+		t.Error("a delay of 66000 ms was stored in milliseconds")
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, delays, palette, &Options{FrameDelays: true}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 ms is the smallest base that fits and divides all delays
+	if header.Flags&FlagTimeBase == 0 || header.TimeBase != 2 || header.FrameDelay != 50 {
+		t.Errorf("time base %d, first delay %d with flags %#04x, want 2 ms and 50 steps", header.TimeBase, header.FrameDelay, header.Flags)
+	}
+	_, decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, delays) {
+		t.Errorf("delays = %v, want %v", decoded, delays)
+	}
+
+	// A given time base rounds the delays, also with equal ones in the header
+	for _, opts := range []*Options{{TimeBase: 1000}, {TimeBase: 1000, FrameDelays: true, Interleaved: true}} {
+		buf.Reset()
+		if err := EncodeWithOptions(&buf, frames, []int{120400, 120400, 120400}, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, decoded, err = Decode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{120000, 120000, 120000}; !reflect.DeepEqual(decoded, want) {
+			t.Errorf("%+v: delays = %v, want %v", *opts, decoded, want)
+		}
+	}
+
+	// Without per-frame delays only the first one is stored and picks the time base
+	buf.Reset()
+	if err := EncodeWithOptions(&buf, frames, []int{100, 66000, 250}, palette, nil); err != nil {
+		t.Fatal(err)
+	}
+	if header, err = readHeader(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != Version || header.FrameDelay != 100 {
+		t.Errorf("version %d with a delay of %d, want version %d and 100 ms", header.Version, header.FrameDelay, Version)
+	}
+
+	if err := EncodeWithOptions(io.Discard, nil, nil, palette, nil); err == nil {
+		t.Error("encoding no frames succeeded, want an error")
+	}
+
+	if got := pickTimeBase([]int{70001, 10}); got != 2 {
+		t.Errorf("time base for 70001 ms = %d, want 2 (rounding)", got)
+	}
+	if got := pickTimeBase([]int{100, 65535}); got != 1 {
+		t.Errorf("time base for 65535 ms = %d, want 1", got)
+	}
+}
+
+func TestFrameCountOverflow(t *testing.T) {
+	// 1×1 frames share one image, so the slice costs little memory
+	frame, palette := testFrames(1, 1, 1)
+	frames := make([]*image.Paletted, MaxValue+1)
+	delays := make([]int, len(frames))
+	for i := range frames {
+		frames[i], delays[i] = frame[0], 10
+	}
+
+	for _, opts := range []*Options{nil, {RowSkip: true, FrameDelays: true}, {Interleaved: true}} {
+		var buf bytes.Buffer
+		err := EncodeWithOptions(&buf, frames, delays, palette, opts)
+		if err == nil || !strings.Contains(err.Error(), "65536 frames exceed the maximum of 65535") {
+			t.Errorf("%+v: %v, want an error naming the frame count", opts, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: %d bytes written despite the error", opts, buf.Len())
+		}
+	}
+
+	if _, err := EncodedSize(frames[:MaxValue], delays[:MaxValue], palette, nil); err != nil {
+		t.Errorf("%d frames: %v", MaxValue, err)
+	}
+}
+
+func TestLittleEndianRoundTrip(t *testing.T) {
+	frames, palette := testFrames(300, 3, 3)
+	delays := []int{100, 66000, 250}
+
+	decode := func(littleEndian bool) ([]*image.Paletted, []int, Header, []byte) {
+		opts := &Options{FrameDelays: true, Pad8: true, Title: "endian", CycleStart: 1, CycleCount: 2, CycleDelay: 40, PaletteSize: true, LittleEndian: littleEndian}
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		header, err := readHeader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, decodedDelays, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded, decodedDelays, header, data
+	}
+
+	bigFrames, bigDelays, bigHeader, bigData := decode(false)
+	littleFrames, littleDelays, littleHeader, littleData := decode(true)
+	if littleHeader.Flags != bigHeader.Flags|FlagLittleEndian {
+		t.Errorf("flags %#04x, want %#04x", littleHeader.Flags, bigHeader.Flags|FlagLittleEndian)
+	}
+	littleHeader.Flags = bigHeader.Flags
+	if !reflect.DeepEqual(littleHeader, bigHeader) {
+		t.Errorf("little-endian header %+v, want %+v", littleHeader, bigHeader)
+	}
+	if !reflect.DeepEqual(littleFrames, bigFrames) || !reflect.DeepEqual(littleDelays, bigDelays) {
+		t.Error("little-endian frames or delays differ from the big-endian ones")
+	}
+	if !reflect.DeepEqual(bigDelays, delays) {
+		t.Errorf("delays = %v, want %v", bigDelays, delays)
+	}
+
+	// The padded width of 304 is 0x0130, the Flags field stays big-endian
+	if got := bigData[4:6]; !bytes.Equal(got, []byte{0x01, 0x30}) {
+		t.Errorf("big-endian width bytes % x", got)
+	}
+	if got := littleData[4:6]; !bytes.Equal(got, []byte{0x30, 0x01}) {
+		t.Errorf("little-endian width bytes % x", got)
+	}
+	if flags := binary.BigEndian.Uint16(littleData[headerSizeV1:]); flags != bigHeader.Flags|FlagLittleEndian {
+		t.Errorf("stored flags %#04x, want them big-endian", flags)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	frames, palette := testFrames(21, 7, 4)
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{40, 40, 40, 40}, palette, &Options{Title: "list me"}); err != nil {
+		t.Fatal(err)
+	}
+	size := headerSize(Header{Version: Version2, Flags: FlagTitle, Title: "list me"})
+
+	// Any read past the header fails
+	errFrameData := errors.New("frame data read")
+	r := io.MultiReader(bytes.NewReader(buf.Bytes()[:size]), iotest.ErrReader(errFrameData))
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader read past the header: %v", err)
+	}
+	if header.Width != 21 || header.Height != 7 || header.FrameCount != 4 || header.Title != "list me" {
+		t.Errorf("header %dx%d, %d frames, title %q, want 21x7, 4 frames, \"list me\"", header.Width, header.Height, header.FrameCount, header.Title)
+	}
+
+	// Unknown flags are rejected like by the decoder
+	data := append([]byte(nil), buf.Bytes()[:size]...)
+	data[headerSizeV1] |= 0x80
+	if _, err := ReadHeader(bytes.NewReader(data)); err == nil {
+		t.Error("unknown flags accepted")
+	}
+}
+
+func TestHeaderPalette(t *testing.T) {
+	frames, palette := testFrames(8, 2, 1)
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{40}, palette, &Options{PaletteSize: true}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := header.Palette()
+	if len(decoded) != len(palette) {
+		t.Fatalf("%d colors, want %d", len(decoded), len(palette))
+	}
+	for i, c := range palette {
+		if got := decoded.Index(c); got != i {
+			t.Errorf("Index(%v) = %d, want %d", c, got, i)
+		}
+	}
+}
+
+func TestNoDelta(t *testing.T) {
+	still, palette := testFrames(21, 9, 1)
+	encode := func(opts *Options) []byte {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, still, []int{0}, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	withDelta := encode(nil)
+	noDelta := encode(&Options{NoDelta: true})
+
+	// 3 blocks per row, so 27 identical-bytes less, minus the 2 bytes of Flags
+	if want := len(withDelta) - 27 + 2; len(noDelta) != want {
+		t.Errorf("no-delta file of %d bytes, want %d (with delta bytes %d)", len(noDelta), want, len(withDelta))
+	}
+	for _, data := range [][]byte{withDelta, noDelta} {
+		frames, _, err := DecodeStrict(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frames[0].Pix, still[0].Pix) {
+			t.Error("decoded still image differs")
+		}
+	}
+
+	// Animations decode as well, also at random, packed and with row skipping
+	frames, palette := testFrames(21, 9, 4)
+	delays := []int{40, 40, 40, 40}
+	for _, opts := range []*Options{{NoDelta: true}, {NoDelta: true, BitsPerPixel: 4, RowSkip: true}, {NoDelta: true, Interleaved: true}} {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		decoded, _, err := DecodeStrict(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", *opts, err)
+		}
+		for i := range frames {
+			if !bytes.Equal(decoded[i].Pix, frames[i].Pix) {
+				t.Errorf("%+v: frame %d differs", *opts, i)
+			}
+		}
+		if opts.RowSkip {
+			continue
+		}
+		header, _ := ReadHeader(bytes.NewReader(buf.Bytes()))
+		frame, err := ReadFrameAt(bytes.NewReader(buf.Bytes()), header, 2)
+		if err != nil || !bytes.Equal(frame.Pix, frames[2].Pix) {
+			t.Errorf("%+v: ReadFrameAt(2) = %v, want frame 2", *opts, err)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	frames, palette := testFrames(9, 3, 2)
+	metadata := []MetaEntry{{Key: "author", Value: "Ada"}, {Key: "query", Value: "a=b=c"}, {Key: "tool", Value: "gif2sag"}}
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, []int{40, 40}, palette, &Options{Title: "meta", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(header.Metadata, metadata) || header.Title != "meta" {
+		t.Errorf("metadata %v, title %q, want %v and \"meta\"", header.Metadata, header.Title, metadata)
+	}
+	decoded, _, err := DecodeStrict(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded[1].Pix, frames[1].Pix) {
+		t.Error("frame 1 differs after the metadata")
+	}
+
+	long := strings.Repeat("x", 250)
+	for _, invalid := range [][]MetaEntry{
+		{{Key: "", Value: "v"}},
+		{{Key: "a", Value: "1"}, {Key: "a", Value: "2"}},
+		{{Key: "a", Value: strings.Repeat("x", 256)}},
+		{{Key: "a", Value: long}, {Key: "b", Value: long}, {Key: "c", Value: long}, {Key: "d", Value: long}, {Key: "e", Value: long}},
+	} {
+		if err := EncodeWithOptions(io.Discard, frames, []int{40, 40}, palette, &Options{Metadata: invalid}); err == nil {
+			t.Errorf("metadata of %d entries accepted", len(invalid))
+		}
+	}
+}
+
+// countingWrites counts the Write calls it gets.
+type countingWrites struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWrites) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+func TestEncoderBuffersWrites(t *testing.T) {
+	frames, palette := testFrames(64, 64, 8)
+	delays := []int{40, 40, 40, 40, 40, 40, 40, 40}
+	for _, opts := range []*Options{nil, {Deflate: true}, {Interleaved: true}} {
+		var cw countingWrites
+		if err := EncodeWithOptions(&cw, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		// The pieces of a few bytes arrive in writes of the buffer size
+		if max := cw.Len()/4096 + 1; cw.writes > max {
+			t.Errorf("%+v: %d writes for %d bytes, want at most %d", opts, cw.writes, cw.Len(), max)
+		}
+		decoded, _, err := DecodeStrict(&cw.Buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != len(frames) || !bytes.Equal(decoded[7].Pix, frames[7].Pix) {
+			t.Errorf("%+v: the buffered file does not decode to the frames", opts)
+		}
+	}
+
+	// A file without frames still gets its header
+	var buf bytes.Buffer
+	if _, err := NewEncoder(&buf, 4, 4, 0, 40, palette, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != headerSizeV1 {
+		t.Errorf("header of %d bytes written for no frames, want %d", buf.Len(), headerSizeV1)
+	}
+}
@@ -0,0 +1,231 @@
+package sag
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Frame is a decoded frame together with its delay in milliseconds.
+type Frame struct {
+	Image *image.Paletted
+	Delay int
+}
+
+// Decoder reads a SAG file frame by frame. Since row-skip frames copy the rows
+// of the previous frame, it is the only frame kept in memory. Files with
+// FlagInterleaved are read as a whole on the first ReadFrame.
+//
+// By default the Decoder is lenient like the firmware: a pixel block cut
+// short is read as far as it goes, an index beyond the palette size of
+// FlagColors pads the palette with black and data after the last frame is
+// ignored. Set Strict before the first ReadFrame to make all of these errors.
+type Decoder struct {
+	Strict bool // Fail on any inconsistency instead of decoding on a best-effort basis
+
+	r       io.Reader
+	header  Header
+	palette color.Palette
+	prev    *image.Paletted // Previous frame before cropping the padding
+	read    int
+
+	interleaved []Frame // Frames of a FlagInterleaved file not returned yet
+}
+
+// NewDecoder reads the SAG header from r and returns a Decoder for the frames.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(r, header)
+}
+
+// newDecoder returns a Decoder for the frames following an already read header.
+func newDecoder(r io.Reader, header Header) (*Decoder, error) {
+	if unknown := header.Flags &^ knownFlags; unknown != 0 {
+		return nil, fmt.Errorf("sag: unsupported flags %#04x", unknown)
+	}
+	if header.Flags&FlagDeflate != 0 {
+		r = flate.NewReader(r)
+	}
+	return &Decoder{r: r, header: header, palette: header.Palette()}, nil
+}
+
+// Header returns the header of the file.
+func (d *Decoder) Header() Header {
+	return d.header
+}
+
+// ReadFrame reads the next frame. It returns io.EOF after the last frame.
+func (d *Decoder) ReadFrame() (Frame, error) {
+	if d.read == int(d.header.FrameCount) {
+		if d.Strict {
+			// A Read may return no data before the end, ReadFull only stops there
+			n, err := io.ReadFull(d.r, make([]byte, 1))
+			if n > 0 {
+				return Frame{}, errors.New("sag: trailing data after the last frame")
+			}
+			if err != io.EOF {
+				// A compressed stream may be cut short after the last frame
+				return Frame{}, truncated(err)
+			}
+		}
+		return Frame{}, io.EOF
+	}
+
+	frame, err := d.readFrame()
+	if err != nil {
+		// The file may end within a frame
+		return Frame{}, fmt.Errorf("%w (frame %d)", truncated(err), d.read)
+	}
+	return frame, nil
+}
+
+// readFrame reads the next frame.
+func (d *Decoder) readFrame() (Frame, error) {
+	if d.header.Flags&FlagInterleaved != 0 {
+		return d.readInterleavedFrame()
+	}
+
+	delay := int(d.header.FrameDelay) * d.header.DelayUnit()
+	if d.header.Flags&FlagFrameDelays != 0 {
+		var buf [2]byte
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			return Frame{}, err
+		}
+		delay = int(d.header.byteOrder().Uint16(buf[:])) * d.header.DelayUnit()
+	}
+	palette := d.palette
+	if d.header.Flags&FlagLocalPalettes != 0 {
+		var err error
+		if palette, err = readLocalPalette(d.r); err != nil {
+			return Frame{}, err
+		}
+	}
+
+	width, height := int(d.header.Width), int(d.header.Height)
+	frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+
+	// Frames may skip the rows that did not change
+	var changed []byte
+	if d.header.Flags&FlagRowSkip != 0 {
+		mode, err := readFrameMode(d.r)
+		if err != nil {
+			return Frame{}, err
+		}
+		if mode == FrameRowSkip {
+			if d.prev == nil {
+				return Frame{}, errors.New("sag: first frame skips rows")
+			}
+			changed = make([]byte, (height+7)/8)
+			if _, err := io.ReadFull(d.r, changed); err != nil {
+				return Frame{}, err
+			}
+			copy(frame.Pix, d.prev.Pix)
+		}
+	}
+
+	if err := readRows(d.r, frame, changed, d.header.Flags, d.Strict); err != nil {
+		return Frame{}, err
+	}
+	// A palette padded for an index beyond it also covers the rows copied by the next frame
+	if d.header.Flags&FlagLocalPalettes == 0 && len(frame.Palette) > len(d.palette) {
+		d.palette = frame.Palette
+	}
+	d.prev = frame
+	d.read++
+
+	// Padded frames are cropped after decoding, row skipping copies whole rows
+	if d.header.Flags&FlagPadded != 0 {
+		frame = cropWidth(frame, int(d.header.RealWidth))
+	}
+	return Frame{Image: frame, Delay: delay}, nil
+}
+
+// readInterleavedFrame returns the next frame of a FlagInterleaved file,
+// reading all of them first if this is the first one.
+func (d *Decoder) readInterleavedFrame() (Frame, error) {
+	if d.read == 0 {
+		frames, err := d.readInterleaved()
+		if err != nil {
+			return Frame{}, err
+		}
+		d.interleaved = frames
+	}
+
+	frame := d.interleaved[d.read]
+	d.interleaved[d.read] = Frame{}
+	d.read++
+	if d.header.Flags&FlagPadded != 0 {
+		frame.Image = cropWidth(frame.Image, int(d.header.RealWidth))
+	}
+	return frame, nil
+}
+
+// readInterleaved reads the delays and the rows of all frames of a
+// FlagInterleaved file.
+func (d *Decoder) readInterleaved() ([]Frame, error) {
+	width, height := int(d.header.Width), int(d.header.Height)
+	frames := make([]Frame, d.header.FrameCount)
+	for i := range frames {
+		frames[i] = Frame{Image: image.NewPaletted(image.Rect(0, 0, width, height), d.palette), Delay: int(d.header.FrameDelay) * d.header.DelayUnit()}
+	}
+
+	if d.header.Flags&FlagFrameDelays != 0 {
+		buf := make([]byte, 2*len(frames))
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			return nil, err
+		}
+		for i := range frames {
+			frames[i].Delay = int(d.header.byteOrder().Uint16(buf[2*i:])) * d.header.DelayUnit()
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for _, frame := range frames {
+			if err := readRow(d.r, frame.Image, y, d.header.Flags, d.Strict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return frames, nil
+}
+
+// DecodeStream reads a SAG file from r in the background and sends every
+// frame on the returned channel as soon as it is decoded, so playback can
+// start before the whole file is read. The frame channel is closed after the
+// last frame or the first error; the error, if any, is sent on the error
+// channel, which is closed afterwards. The frames must be received until the
+// frame channel is closed.
+func DecodeStream(r io.Reader) (<-chan Frame, <-chan error) {
+	frames := make(chan Frame)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(frames)
+
+		d, err := NewDecoder(r)
+		if err != nil {
+			errc <- err
+			return
+		}
+		for {
+			frame, err := d.ReadFrame()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	return frames, errc
+}
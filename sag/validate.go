@@ -0,0 +1,150 @@
+package sag
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+)
+
+// ErrLimitExceeded is wrapped by the errors of Validate for a file outside
+// the limits.
+var ErrLimitExceeded = errors.New("sag: limit exceeded")
+
+// Limits are the bounds Validate checks an untrusted SAG file against. A zero
+// field means no limit.
+type Limits struct {
+	MaxWidth    int    // Largest stored width in pixels, including padding
+	MaxHeight   int    // Largest height in pixels
+	MaxFrames   int    // Largest number of frames
+	MaxFileSize int64  // Largest file size in bytes
+	Versions    []byte // Accepted format versions, all supported ones if empty
+}
+
+// Validate checks the SAG file in r against the limits before anything
+// larger than the header is allocated. The header must not use unknown flags
+// (see ReadHeader). Files whose frames all have a fixed size, without
+// FlagRowSkip, FlagDeflate and FlagLocalPalettes, are checked by their length
+// first and then read row by row; the others are decoded frame by frame, which
+// is safe once the header is within the limits. Both ways are strict, e.g.
+// about pixel indices beyond the palette size of FlagColors. Errors of
+// violated limits wrap ErrLimitExceeded and name the limit.
+func Validate(r io.Reader, limits Limits) error {
+	cr := &countingReader{r: r}
+	header, err := ReadHeader(cr)
+	if err != nil {
+		return err
+	}
+
+	if len(limits.Versions) > 0 && !slices.Contains(limits.Versions, header.Version) {
+		return fmt.Errorf("%w: version %d is not one of the accepted versions %v", ErrLimitExceeded, header.Version, limits.Versions)
+	}
+	if limits.MaxWidth > 0 && int(header.Width) > limits.MaxWidth {
+		return fmt.Errorf("%w: width of %d pixels exceeds the maximum width of %d", ErrLimitExceeded, header.Width, limits.MaxWidth)
+	}
+	if limits.MaxHeight > 0 && int(header.Height) > limits.MaxHeight {
+		return fmt.Errorf("%w: height of %d pixels exceeds the maximum height of %d", ErrLimitExceeded, header.Height, limits.MaxHeight)
+	}
+	if limits.MaxFrames > 0 && int(header.FrameCount) > limits.MaxFrames {
+		return fmt.Errorf("%w: %d frames exceed the maximum of %d frames", ErrLimitExceeded, header.FrameCount, limits.MaxFrames)
+	}
+
+	fixed := header.Flags&(FlagRowSkip|FlagDeflate|FlagLocalPalettes) == 0
+	if fixed {
+		if size := frameDataSize(header); limits.MaxFileSize > 0 && cr.n+size > limits.MaxFileSize {
+			return fmt.Errorf("%w: file size of %d bytes exceeds the maximum file size of %d", ErrLimitExceeded, cr.n+size, limits.MaxFileSize)
+		}
+	}
+
+	// Never read more than one byte beyond the limit
+	if limits.MaxFileSize > 0 {
+		cr.r = io.LimitReader(r, limits.MaxFileSize-cr.n+1)
+	}
+	tooLarge := fmt.Errorf("%w: file size exceeds the maximum file size of %d bytes", ErrLimitExceeded, limits.MaxFileSize)
+	if fixed {
+		if err := readFixedFrames(cr, header); err != nil {
+			return err
+		}
+		if n, _ := io.ReadFull(cr, make([]byte, 1)); n > 0 {
+			if limits.MaxFileSize > 0 && cr.n > limits.MaxFileSize {
+				return tooLarge
+			}
+			return errors.New("sag: trailing data after the last frame")
+		}
+		return nil
+	}
+
+	d, err := newDecoder(cr, header)
+	if err != nil {
+		return err
+	}
+	d.Strict = true
+	for {
+		_, err := d.ReadFrame()
+		// Reading stops one byte beyond the limit, which looks like a truncated file
+		if limits.MaxFileSize > 0 && cr.n > limits.MaxFileSize {
+			return tooLarge
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frameDataSize returns the size of the frame data of a file without
+// FlagRowSkip, FlagDeflate and FlagLocalPalettes, whose frames all have the
+// same size.
+func frameDataSize(header Header) int64 {
+	size := int64(header.FrameCount) * int64(header.Height) * int64(rowSize(int(header.Width), header.Flags))
+	if header.Flags&FlagFrameDelays != 0 {
+		size += 2 * int64(header.FrameCount)
+	}
+	return size
+}
+
+// readFixedFrames reads the frame data of a file whose frames all have a
+// fixed size in strict mode, one row at a time instead of whole frames.
+func readFixedFrames(r io.Reader, header Header) error {
+	row := image.NewPaletted(image.Rect(0, 0, int(header.Width), 1), header.Palette())
+	interleaved := header.Flags&FlagInterleaved != 0
+	delays := header.Flags&FlagFrameDelays != 0
+
+	// Interleaved files store all delays up front, the others one per frame
+	if interleaved && delays {
+		if _, err := io.CopyN(io.Discard, r, 2*int64(header.FrameCount)); err != nil {
+			return truncated(err)
+		}
+	}
+	frames, height := int(header.FrameCount), int(header.Height)
+	for i := 0; i < frames*height; i++ {
+		n, y := i/height, i%height
+		if interleaved {
+			n, y = i%frames, i/frames
+		} else if delays && y == 0 {
+			if _, err := io.CopyN(io.Discard, r, 2); err != nil {
+				return fmt.Errorf("%w (frame %d)", truncated(err), n)
+			}
+		}
+		if err := readRow(r, row, 0, header.Flags, true); err != nil {
+			return fmt.Errorf("%w (frame %d, row %d)", truncated(err), n, y)
+		}
+	}
+	return nil
+}
+
+// countingReader is an io.Reader that counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,110 @@
+package sag
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateLimits(t *testing.T) {
+	frames, palette := testFrames(16, 8, 5)
+	delays := []int{100, 100, 100, 100, 100}
+	encode := func(opts *Options) []byte {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	v1 := encode(nil)
+	deflated := encode(&Options{Deflate: true})
+	size := int64(len(v1))
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		limits Limits
+		limit  string // Part of the error naming the violated limit, "" for none
+	}{
+		{"no limits", v1, Limits{}, ""},
+		{"width at the limit", v1, Limits{MaxWidth: 16}, ""},
+		{"width above the limit", v1, Limits{MaxWidth: 15}, "maximum width"},
+		{"height at the limit", v1, Limits{MaxHeight: 8}, ""},
+		{"height above the limit", v1, Limits{MaxHeight: 7}, "maximum height"},
+		{"frames at the limit", v1, Limits{MaxFrames: 5}, ""},
+		{"frames above the limit", v1, Limits{MaxFrames: 4}, "maximum of 4 frames"},
+		{"file size at the limit", v1, Limits{MaxFileSize: size}, ""},
+		{"file size above the limit", v1, Limits{MaxFileSize: size - 1}, "maximum file size"},
+		{"accepted version", v1, Limits{Versions: []byte{Version}}, ""},
+		{"rejected version", deflated, Limits{Versions: []byte{Version}}, "accepted versions"},
+		{"compressed file size at the limit", deflated, Limits{MaxFileSize: int64(len(deflated))}, ""},
+		{"compressed file size above the limit", deflated, Limits{MaxFileSize: int64(len(deflated)) - 1}, "maximum file size"},
+	} {
+		err := Validate(bytes.NewReader(tc.data), tc.limits)
+		switch {
+		case tc.limit == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.limit != "" && !errors.Is(err, ErrLimitExceeded):
+			t.Errorf("%s: %v, want ErrLimitExceeded", tc.name, err)
+		case tc.limit != "" && !strings.Contains(err.Error(), tc.limit):
+			t.Errorf("%s: error %q does not name the %s", tc.name, err, tc.limit)
+		}
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	frames, palette := testFrames(16, 8, 2)
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if err := Validate(bytes.NewReader(data[:len(data)-1]), Limits{}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("short file: %v, want ErrTruncated", err)
+	}
+	if err := Validate(bytes.NewReader(append(data, 0)), Limits{}); err == nil || errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("trailing data: %v, want an error", err)
+	}
+	if err := Validate(strings.NewReader("GIF89a"), Limits{}); !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrBadSignature) {
+		t.Errorf("not a SAG file: %v", err)
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	frames, palette := testFrames(16, 8, 2)
+	encode := func(opts *Options) []byte {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, frames, []int{100, 200}, palette, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, opts := range []*Options{{PaletteSize: true}, {PaletteSize: true, FrameDelays: true}, {PaletteSize: true, Interleaved: true, FrameDelays: true}} {
+		data := encode(opts)
+		if err := Validate(bytes.NewReader(data), Limits{}); err != nil {
+			t.Fatalf("%+v: %v", *opts, err)
+		}
+
+		// An index beyond the stored palette size in the last pixel block
+		header, err := ReadHeader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad := append([]byte(nil), data...)
+		bad[len(bad)-1] = byte(header.Colors)
+		if err := Validate(bytes.NewReader(bad), Limits{}); err == nil || !strings.Contains(err.Error(), "outside the palette") {
+			t.Errorf("%+v: index %d of %d colors: %v, want an error", *opts, header.Colors, header.Colors, err)
+		}
+	}
+
+	// A flag this version does not know
+	data := encode(&Options{Title: "flags"})
+	unknown := append([]byte(nil), data...)
+	unknown[headerSizeV1] |= 0x80
+	if err := Validate(bytes.NewReader(unknown), Limits{}); err == nil || !strings.Contains(err.Error(), "unsupported flags") {
+		t.Errorf("unknown flag: %v, want an error", err)
+	}
+}
@@ -0,0 +1,54 @@
+package sag
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Verify decodes the SAG file in r and compares it index by index with the
+// frames it was encoded from. It returns an error naming the first difference.
+func Verify(r io.Reader, frames []*image.Paletted) error {
+	return verify(r, frames, nil)
+}
+
+// verify is Verify where a decoded transparent index may stand in for another
+// transparent one, as written with Options.SkipTransparent.
+func verify(r io.Reader, frames []*image.Paletted, transparent []bool) error {
+	decoded, _, err := Decode(r)
+	if err != nil {
+		return fmt.Errorf("decoding: %v", err)
+	}
+	if len(decoded) != len(frames) {
+		return fmt.Errorf("decoded %d frames, want %d", len(decoded), len(frames))
+	}
+
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		if got := decoded[i].Bounds(); got.Dx() != bounds.Dx() || got.Dy() != bounds.Dy() {
+			return fmt.Errorf("frame %d: decoded size %dx%d, want %dx%d", i, got.Dx(), got.Dy(), bounds.Dx(), bounds.Dy())
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				want := frame.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)
+				if got := decoded[i].ColorIndexAt(x, y); !sameIndex(got, want, transparent) {
+					return fmt.Errorf("frame %d pixel (%d,%d): decoded index %d, want %d", i, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// VerifyRoundTrip encodes the frames in memory, decodes them again and
+// compares the result with the frames. opts may be nil.
+func VerifyRoundTrip(frames []*image.Paletted, delays []int, palette []color.Color, opts *Options) error {
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, frames, delays, palette, opts); err != nil {
+		return fmt.Errorf("encoding: %v", err)
+	}
+	return verify(&buf, frames, transparentIndices(palette, opts))
+}
@@ -0,0 +1,40 @@
+package sag
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	frames, palette := testFrames(11, 5, 4)
+	delays := []int{100, 100, 100, 100}
+
+	if err := VerifyRoundTrip(frames, delays, palette, nil); err != nil {
+		t.Errorf("VerifyRoundTrip: %v", err)
+	}
+	if err := VerifyRoundTrip(frames, delays, palette, &Options{RowSkip: true}); err != nil {
+		t.Errorf("VerifyRoundTrip with row skipping: %v", err)
+	}
+}
+
+func TestVerifyReportsFirstMismatch(t *testing.T) {
+	frames, palette := testFrames(11, 5, 3)
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, frames, []int{100, 100, 100}, palette); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the expected frames after encoding
+	frames[2].SetColorIndex(9, 3, (frames[2].ColorIndexAt(9, 3)+1)%4)
+	frames[2].SetColorIndex(10, 4, (frames[2].ColorIndexAt(10, 4)+1)%4)
+
+	err := Verify(&buf, frames)
+	if err == nil {
+		t.Fatal("Verify succeeded on differing frames")
+	}
+	if !strings.Contains(err.Error(), "frame 2 pixel (9,3)") {
+		t.Errorf("error %q does not name the first differing pixel", err)
+	}
+}
@@ -1,157 +1,108 @@
 package main
 
 import (
-	"encoding/binary"
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
-	"image/gif"
+	"image/png"
+	"net/http"
 	"os"
-)
 
-// SAGHeader represents the header of the SAG file.
-type SAGHeader struct {
-	Signature    [3]byte
-	Version      byte
-	Width        uint16
-	Height       uint16
-	FrameCount   uint16
-	FrameDelay   uint16
-	ColorPalette [768]byte
-}
+	"./cli"
+	"./convert"
+	"./sag"
+)
 
-// readSAGFile reads a SAG file and returns the frames and the delays between them.
-func readSAGFile(filename string) ([]*image.Paletted, []int, error) {
-	file, err := os.Open(filename)
+// writeGIFFile writes the frames and delays (in milliseconds) as a GIF file with infinite looping.
+func writeGIFFile(frames []*image.Paletted, delays []int, outputFilename string) error {
+	file, err := os.Create(outputFilename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	defer file.Close()
 
-	header, err := readSAGHeader(file)
-	if err != nil {
-		return nil, nil, err
-	}
+	return convert.EncodeGIF(file, frames, delays)
+}
 
-	frames, delays, err := readSAGFrames(file, header)
+// writeTIFFFile writes the frames as a multi-page TIFF file. TIFF has no frame delays.
+func writeTIFFFile(frames []*image.Paletted, outputFilename string) error {
+	file, err := os.Create(outputFilename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
+	defer file.Close()
 
-	return frames, delays, nil
+	return convert.EncodeTIFF(file, frames)
 }
 
-// readSAGHeader reads the SAG header from the file.
-func readSAGHeader(file *os.File) (SAGHeader, error) {
-	var header SAGHeader
-	if err := binary.Read(file, binary.BigEndian, &header); err != nil {
-		return header, err
+// writeMontageFile writes all frames as a PNG contact sheet with cols frames per row.
+func writeMontageFile(frames []*image.Paletted, cols int, outputFilename string) error {
+	sheet, err := convert.Montage(frames, cols)
+	if err != nil {
+		return err
 	}
-	return header, nil
-}
-
-// readSAGFrames reads the frames and delays from the SAG file.
-func readSAGFrames(file *os.File, header SAGHeader) ([]*image.Paletted, []int, error) {
-	palette := extractPalette(header)
-	width, height := int(header.Width), int(header.Height)
-	frameCount, frameDelay := int(header.FrameCount), int(header.FrameDelay)
-
-	frames := make([]*image.Paletted, frameCount)
-	delays := make([]int, frameCount)
-
-	for i := 0; i < frameCount; i++ {
-		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
-
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x += 8 {
-				skipIdenticalByte(file)
-
-				pixelBlock, err := readPixelBlock(file, width, x)
-				if err != nil {
-					return nil, nil, err
-				}
-
-				applyPixelBlock(frame, pixelBlock, x, y, width)
-			}
-		}
 
-		frames[i] = frame
-		delays[i] = frameDelay / 10 // Convert back to 1/100th of a second for GIF
+	file, err := os.Create(outputFilename)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	return frames, delays, nil
+	return png.Encode(file, sheet)
 }
 
-// extractPalette creates a color palette from the SAG header.
-func extractPalette(header SAGHeader) color.Palette {
-	palette := make([]color.Color, 256)
-	for i := 0; i < 256; i++ {
-		r, g, b := header.ColorPalette[i*3], header.ColorPalette[i*3+1], header.ColorPalette[i*3+2]
-		palette[i] = color.RGBA{R: r, G: g, B: b, A: 0xff}
-	}
-	return palette
-}
-
-// skipIdenticalByte skips the identical byte in the SAG file.
-func skipIdenticalByte(file *os.File) {
-	file.Read(make([]byte, 1))
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
 }
 
-// readPixelBlock reads the next 8 pixels from the SAG file.
-func readPixelBlock(file *os.File, width, x int) ([]byte, error) {
-	pixelBlock := make([]byte, 8)
-	if x+8 > width {
-		pixelBlock = make([]byte, width-x)
+// run converts the files given on the command line. It returns errors marked
+// with their exit code instead of exiting, so main is the only place that
+// sets the exit code.
+func run() error {
+	format := flag.String("format", "gif", "output format: gif, tiff (webp is not supported, x/image has no WebP encoder)")
+	serve := flag.String("serve", "", "serve <input.sag> as an animated GIF on this address (e.g. :8080), re-read on every request")
+	minDelay := flag.Int("min-delay", 0, "raise shorter frame delays in the GIF output to this many milliseconds (e.g. 20 for browsers)")
+	montage := flag.Int("montage", -1, "write a PNG contact sheet with this many frames per row instead (0 = square grid)")
+	strict := flag.Bool("strict", false, "fail on a short file, a pixel index beyond the stored palette size or data after the last frame")
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if *serve != "" && flag.NArg() == 1 {
+		fmt.Printf("Serving %s on http://%s/\n", flag.Arg(0), *serve)
+		return http.ListenAndServe(*serve, convert.PreviewHandler(flag.Arg(0)))
 	}
-	_, err := file.Read(pixelBlock)
-	return pixelBlock, err
-}
 
-// applyPixelBlock applies a block of pixels to a frame.
-func applyPixelBlock(frame *image.Paletted, pixelBlock []byte, x, y, width int) {
-	for bit := 0; bit < len(pixelBlock); bit++ {
-		if x+bit < width {
-			frame.SetColorIndex(x+bit, y, pixelBlock[bit])
-		}
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: sag2gif [-format gif|tiff] [-min-delay ms] [-montage cols] <input.sag> <output>")
+		fmt.Println("       sag2gif -serve :8080 <input.sag>")
+		return cli.ErrUsage
 	}
-}
 
-// writeGIFFile writes the frames and delays as a GIF file with infinite looping.
-func writeGIFFile(frames []*image.Paletted, delays []int, outputFilename string) error {
-	outGif := &gif.GIF{
-		Image:     frames,
-		Delay:     delays,
-		LoopCount: 0, // Infinite loop
-	}
+	inputFilename := flag.Arg(0)
+	outputFilename := flag.Arg(1)
 
-	file, err := os.Create(outputFilename)
+	frames, delays, err := sag.DecodeFile(inputFilename, *strict)
 	if err != nil {
-		return err
+		return cli.Decode(fmt.Errorf("reading SAG file: %w", err))
 	}
-	defer file.Close()
-
-	return gif.EncodeAll(file, outGif)
-}
 
-func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: sag2gif <input.sag> <output.gif>")
-		os.Exit(1)
+	switch {
+	case *montage >= 0:
+		err = writeMontageFile(frames, *montage, outputFilename)
+	case *format == "gif":
+		// Only the GIF is clamped, the SAG file keeps its timing
+		err = writeGIFFile(frames, convert.ClampDelays(delays, *minDelay), outputFilename)
+	case *format == "tiff":
+		err = writeTIFFFile(frames, outputFilename)
+	default:
+		return cli.Usage(fmt.Errorf("unsupported output format: %s", *format))
 	}
-
-	inputFilename := os.Args[1]
-	outputFilename := os.Args[2]
-
-	frames, delays, err := readSAGFile(inputFilename)
 	if err != nil {
-		fmt.Println("Error reading SAG file:", err)
-		os.Exit(1)
+		return cli.Write(fmt.Errorf("writing output file: %w", err))
 	}
 
-	if err := writeGIFFile(frames, delays, outputFilename); err != nil {
-		fmt.Println("Error writing GIF file:", err)
-		os.Exit(1)
+	if !*quiet {
+		fmt.Println("Conversion completed successfully:", outputFilename)
 	}
-
-	fmt.Println("Conversion completed successfully:", outputFilename)
+	return nil
 }
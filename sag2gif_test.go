@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"./cli"
+	"./cli/clitest"
+)
+
+// TestRunExitCodes checks the exit codes of sag2gif for valid and invalid
+// arguments and for an input file that does not exist.
+func TestRunExitCodes(t *testing.T) {
+	input := clitest.SAGFile(t, 3)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{"-quiet", input, filepath.Join(dir, "out.gif")}, cli.ExitOK},
+		{"missing output", []string{"-quiet", input}, cli.ExitUsage},
+		{"unknown format", []string{"-quiet", "-format", "bmp", input, filepath.Join(dir, "out.bmp")}, cli.ExitUsage},
+		{"missing input", []string{"-quiet", missing, filepath.Join(dir, "out.gif")}, cli.ExitDecode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, output := clitest.Run(t, run, test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\n%s", code, test.code, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+// writeRawFiles writes the frames as <prefix>.pal and <prefix>.idx, see convert.EncodeRaw.
+func writeRawFiles(frames []*image.Paletted, prefix string) error {
+	pal, err := os.Create(prefix + ".pal")
+	if err != nil {
+		return err
+	}
+	defer pal.Close()
+
+	idx, err := os.Create(prefix + ".idx")
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	return convert.EncodeRaw(pal, idx, frames)
+}
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run dumps the file given on the command line.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: sag2raw [-quiet] <input.sag> <output>")
+		fmt.Println("Writes <output>.pal (256 RGB entries, 768 bytes) and <output>.idx (one index byte per pixel, row by row, frame after frame)")
+		return cli.ErrUsage
+	}
+
+	frames, _, err := sag.DecodeFile(flag.Arg(0), false)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading SAG file: %w", err))
+	}
+	if err := writeRawFiles(frames, flag.Arg(1)); err != nil {
+		return cli.Write(fmt.Errorf("writing raw files: %w", err))
+	}
+
+	if !*quiet {
+		bounds := frames[0].Bounds()
+		fmt.Printf("Wrote %d frames of %dx%d to %s.pal and %s.idx\n", len(frames), bounds.Dx(), bounds.Dy(), flag.Arg(1), flag.Arg(1))
+	}
+	return nil
+}
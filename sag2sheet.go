@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strconv"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+// writeSheet writes the sprite sheet as PNG and its frames as JSON.
+func writeSheet(sheet image.Image, frames []convert.SheetFrame, pngFilename, jsonFilename string) error {
+	pngFile, err := os.Create(pngFilename)
+	if err != nil {
+		return err
+	}
+	defer pngFile.Close()
+	if err := png.Encode(pngFile, sheet); err != nil {
+		return err
+	}
+
+	jsonFile, err := os.Create(jsonFilename)
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+	return convert.WriteSheetJSON(jsonFile, frames)
+}
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run writes the sprite sheet of the file given on the command line.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() != 3 && flag.NArg() != 4 {
+		fmt.Println("Usage: sag2sheet [-quiet] <input.sag> <output.png> <output.json> [cols]")
+		fmt.Println("Without cols all frames are laid out in one row.")
+		return cli.ErrUsage
+	}
+
+	cols := 0
+	if flag.NArg() == 4 {
+		var err error
+		if cols, err = strconv.Atoi(flag.Arg(3)); err != nil || cols < 1 {
+			return cli.Usage(fmt.Errorf("invalid column count %q", flag.Arg(3)))
+		}
+	}
+
+	frames, delays, err := sag.DecodeFile(flag.Arg(0), false)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading SAG file: %w", err))
+	}
+	sheet, rects, err := convert.SpriteSheet(frames, delays, cols)
+	if err != nil {
+		return cli.Decode(err)
+	}
+	if err := writeSheet(sheet, rects, flag.Arg(1), flag.Arg(2)); err != nil {
+		return cli.Write(fmt.Errorf("writing sprite sheet: %w", err))
+	}
+
+	if !*quiet {
+		fmt.Printf("Wrote %d frames to %s and %s\n", len(frames), flag.Arg(1), flag.Arg(2))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run joins the files given on the command line.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: sagcat [-quiet] <input.sag> <input.sag> [...] <output.sag>")
+		return cli.ErrUsage
+	}
+	args := flag.Args()
+	inputs, output := args[:len(args)-1], args[len(args)-1]
+
+	frames := make([][]*image.Paletted, len(inputs))
+	delays := make([][]int, len(inputs))
+	for i, input := range inputs {
+		var err error
+		if frames[i], delays[i], err = sag.DecodeFile(input, false); err != nil {
+			return cli.Decode(fmt.Errorf("reading %s: %w", input, err))
+		}
+	}
+
+	joined, joinedDelays, palette, err := convert.Concat(frames, delays)
+	if err != nil {
+		return cli.Decode(err)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+	}
+	defer file.Close()
+
+	// Per-frame delays are only stored if the inputs use different delays
+	if err := sag.EncodeWithOptions(file, joined, joinedDelays, palette, &sag.Options{FrameDelays: true}); err != nil {
+		return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+	}
+
+	if !*quiet {
+		fmt.Printf("Wrote %d frames to %s\n", len(joined), output)
+	}
+	return nil
+}
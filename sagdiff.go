@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+// writePNG writes the image as PNG file.
+func writePNG(filename string, img image.Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, img)
+}
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run compares the two files given on the command line and writes the heatmap.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		fmt.Println("Usage: sagdiff [-quiet] <a.sag> <b.sag> <output.png>")
+		fmt.Println("Prints the differing pixels per frame and writes a heatmap of them.")
+		return cli.ErrUsage
+	}
+
+	a, _, err := sag.DecodeFile(flag.Arg(0), false)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading %s: %w", flag.Arg(0), err))
+	}
+	b, _, err := sag.DecodeFile(flag.Arg(1), false)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading %s: %w", flag.Arg(1), err))
+	}
+	counts, heatmap, err := convert.DiffFrames(a, b)
+	if err != nil {
+		return cli.Usage(err)
+	}
+
+	total := 0
+	for i, n := range counts {
+		fmt.Printf("frame %d: %d pixels differ\n", i, n)
+		total += n
+	}
+	if err := writePNG(flag.Arg(2), heatmap); err != nil {
+		return cli.Write(fmt.Errorf("writing heatmap: %w", err))
+	}
+	if !*quiet {
+		fmt.Printf("%d differing pixels in %d frames, heatmap written to %s\n", total, len(counts), flag.Arg(2))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"./cli"
+	"./cli/clitest"
+)
+
+// TestRunExitCodes checks the exit codes of sagdiff for valid and invalid
+// arguments and for an input file that does not exist.
+func TestRunExitCodes(t *testing.T) {
+	input := clitest.SAGFile(t, 3)
+	shorter := clitest.SAGFile(t, 2)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{"-quiet", input, input, filepath.Join(dir, "diff.png")}, cli.ExitOK},
+		{"missing output", []string{"-quiet", input, input}, cli.ExitUsage},
+		{"different frame counts", []string{"-quiet", input, shorter, filepath.Join(dir, "diff.png")}, cli.ExitUsage},
+		{"missing input", []string{"-quiet", input, missing, filepath.Join(dir, "diff.png")}, cli.ExitDecode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, output := clitest.Run(t, run, test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\n%s", code, test.code, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"./cli"
+	"./sag"
+)
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run prints the header of the file given on the command line.
+func run() error {
+	jsonOutput := flag.Bool("json", false, "print the header, the delays, the palette and size statistics as JSON, reading the whole file")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: saginfo [-json] <input.sag>")
+		return cli.ErrUsage
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return cli.Decode(err)
+	}
+	defer file.Close()
+
+	if *jsonOutput {
+		info, err := sag.Inspect(file)
+		if err != nil {
+			return cli.Decode(fmt.Errorf("reading SAG file: %w", err))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			return cli.Write(err)
+		}
+		return nil
+	}
+
+	header, err := sag.ReadHeader(file)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading header: %w", err))
+	}
+
+	width := header.Width
+	if header.Flags&sag.FlagPadded != 0 {
+		width = header.RealWidth
+	}
+	fmt.Printf("Version: %d\n", header.Version)
+	if header.Flags&sag.FlagTitle != 0 {
+		fmt.Printf("Title:   %s\n", header.Title)
+	}
+	for _, entry := range header.Metadata {
+		fmt.Printf("Meta:    %s=%s\n", entry.Key, entry.Value)
+	}
+	fmt.Printf("Size:    %dx%d\n", width, header.Height)
+	fmt.Printf("Frames:  %d\n", header.FrameCount)
+	fmt.Printf("Delay:   %d ms\n", int(header.FrameDelay)*header.DelayUnit())
+	if header.Flags&sag.FlagTimeBase != 0 {
+		fmt.Printf("Unit:    %d ms per stored delay step\n", header.TimeBase)
+	}
+	if header.Flags&sag.FlagColors != 0 {
+		fmt.Printf("Colors:  %d\n", header.Colors)
+	}
+	fmt.Printf("Flags:   %#04x\n", header.Flags)
+	if header.Flags&sag.FlagLittleEndian != 0 {
+		fmt.Println("Order:   little-endian")
+	}
+	if header.Flags&sag.FlagCycle != 0 {
+		fmt.Printf("Cycle:   palette %d to %d, %d ms per step\n", header.CycleStart, int(header.CycleStart)+int(header.CycleCount)-1, header.CycleDelay)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run replaces the palette entries given on the command line.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: sagremap [-quiet] <input.sag> <output.sag> index=R,G,B ...")
+		fmt.Println("Only the palette changes, the frames keep their indices.")
+		return cli.ErrUsage
+	}
+
+	input, output := flag.Arg(0), flag.Arg(1)
+	colors, err := convert.ParsePaletteEntries(flag.Args()[2:])
+	if err != nil {
+		return cli.Usage(err)
+	}
+
+	in, err := os.Open(input)
+	if err != nil {
+		return cli.Decode(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+	}
+	defer out.Close()
+
+	if err := sag.RemapPalette(out, in, colors); err != nil {
+		return cli.Decode(fmt.Errorf("remapping %s: %w", input, err))
+	}
+
+	if !*quiet {
+		fmt.Printf("Changed %d palette entries in %s\n", len(colors), output)
+	}
+	return nil
+}
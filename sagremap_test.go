@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"./cli"
+	"./cli/clitest"
+)
+
+// TestRunExitCodes checks the exit codes of sagremap for valid and invalid
+// arguments and for an input file that does not exist.
+func TestRunExitCodes(t *testing.T) {
+	input := clitest.SAGFile(t, 3)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"valid", []string{"-quiet", input, filepath.Join(dir, "out.sag"), "1=255,0,0"}, cli.ExitOK},
+		{"missing entries", []string{"-quiet", input, filepath.Join(dir, "out.sag")}, cli.ExitUsage},
+		{"invalid entry", []string{"-quiet", input, filepath.Join(dir, "out.sag"), "red"}, cli.ExitUsage},
+		{"missing input", []string{"-quiet", missing, filepath.Join(dir, "out.sag"), "1=255,0,0"}, cli.ExitDecode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, output := clitest.Run(t, run, test.args...); code != test.code {
+				t.Errorf("exit code %d, want %d\n%s", code, test.code, output)
+			}
+		})
+	}
+}
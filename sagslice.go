@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+
+	"./cli"
+	"./convert"
+	"./sag"
+)
+
+func main() {
+	os.Exit(cli.Exit(os.Stdout, run()))
+}
+
+// run extracts the frame range given on the command line.
+func run() error {
+	quiet := flag.Bool("quiet", false, "do not print the success message, check the exit code instead")
+	flag.Parse()
+
+	if flag.NArg() != 4 {
+		fmt.Println("Usage: sagslice [-quiet] <input.sag> <output.sag> <first> <last>")
+		fmt.Println("Frames are counted from 0, first and last are both included.")
+		return cli.ErrUsage
+	}
+	input, output := flag.Arg(0), flag.Arg(1)
+	first, err := strconv.Atoi(flag.Arg(2))
+	if err != nil {
+		return cli.Usage(fmt.Errorf("invalid first frame %q", flag.Arg(2)))
+	}
+	last, err := strconv.Atoi(flag.Arg(3))
+	if err != nil {
+		return cli.Usage(fmt.Errorf("invalid last frame %q", flag.Arg(3)))
+	}
+
+	frames, delays, err := sag.DecodeFile(input, false)
+	if err != nil {
+		return cli.Decode(fmt.Errorf("reading %s: %w", input, err))
+	}
+	frames, delays, err = convert.SliceFrames(frames, delays, first, last)
+	if err != nil {
+		return cli.Usage(err)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+	}
+	defer file.Close()
+
+	// The decoded palette is the original one, a stored palette size is kept
+	palette := frames[0].Palette
+	opts := &sag.Options{FrameDelays: true, PaletteSize: len(palette) < 256}
+	// Frames of a file with local palettes keep them
+	for _, frame := range frames[1:] {
+		if !reflect.DeepEqual(frame.Palette, palette) {
+			opts.LocalPalettes = true
+		}
+	}
+	if err := sag.EncodeWithOptions(file, frames, delays, palette, opts); err != nil {
+		return cli.Write(fmt.Errorf("creating SAG file: %w", err))
+	}
+
+	if !*quiet {
+		fmt.Printf("Wrote frames %d to %d to %s\n", first, last, output)
+	}
+	return nil
+}